@@ -2,27 +2,176 @@ package handlers
 
 import (
 	"github.com/theo-bot/service4.1-video/app/services/sales-api/handlers/v1/testgrp"
+	"github.com/theo-bot/service4.1-video/app/services/sales-api/handlers/v1/usergrp"
+	"github.com/theo-bot/service4.1-video/app/services/sales-api/handlers/v1/wellknowngrp"
+	"github.com/theo-bot/service4.1-video/business/core/user"
 	"github.com/theo-bot/service4.1-video/business/web/auth"
+	"github.com/theo-bot/service4.1-video/business/web/refresh"
+	v1 "github.com/theo-bot/service4.1-video/business/web/v1"
 	"github.com/theo-bot/service4.1-video/business/web/v1/mid"
+	"github.com/theo-bot/service4.1-video/foundation/keystore"
 	"github.com/theo-bot/service4.1-video/foundation/web"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"net/http"
 	"os"
+	"time"
 )
 
+// KeySet declares the behavior needed to publish the public keys and to pick
+// the key tokens are signed with
+type KeySet interface {
+	wellknowngrp.KeySet
+	usergrp.KeySet
+}
+
 // APIMuxConfig contains all the mandatory systems requirements by handlers
 type APIMuxConfig struct {
+	Build    string
 	Shutdown chan os.Signal
 	Log      *zap.SugaredLogger
 	Auth     *auth.Auth
+	Tracer   trace.Tracer
+
+	// HandlerTimeout is how long the handlers of the versioned routes have
+	// to respond. There is no deadline when it's zero
+	HandlerTimeout time.Duration
+
+	// CORS configures the cross-origin requests browsers are allowed to make
+	CORS mid.CORSConfig
+
+	// Errors configures the form of the responses from failures
+	Errors mid.ErrorsConfig
+
+	// Keys are the keys tokens are signed with. Their public part is
+	// published so other services can verify the tokens
+	Keys   KeySet
+	Issuer string
+
+	// UserCore authenticates the users asking for a token. Tokens are
+	// valid for TokenExpiry
+	UserCore    *user.Core
+	TokenExpiry time.Duration
+
+	// Refresh stores the refresh tokens used to renew the tokens once they
+	// expire. No refresh tokens are issued when it's nil
+	Refresh       refresh.Storer
+	RefreshExpiry time.Duration
 }
 
 // APIMux construcs a http.Handler with all application routers defined
 func APIMux(cfg APIMuxConfig) *web.App {
-	app := web.NewApp(cfg.Shutdown, mid.Logger(cfg.Log), mid.Errors(cfg.Log), mid.Metrics(), mid.Panics())
+	app := web.NewApp(cfg.Shutdown, cfg.Tracer, mid.Logger(cfg.Log), mid.CORS(cfg.CORS), mid.Compress(1024), mid.Errors(cfg.Log, cfg.Errors), mid.Metrics(), mid.Panics())
+
+	app.Handle(http.MethodGet, "/test", testgrp.Test).
+		Public().
+		Summary("Test route").
+		Tags("test").
+		Response(http.StatusOK, testgrp.Status{})
+
+	admin := app.Group("/test", mid.Authenticate(cfg.Auth), mid.Authorize(cfg.Auth, auth.RuleAdminOnly)).
+		Annotate(web.AnnotationSecurity, mid.SecuritySchemeBearer).
+		Annotate(web.AnnotationRule, auth.RuleAdminOnly)
+	admin.Handle(http.MethodGet, "/auth", testgrp.Test).
+		Summary("Test route for admins").
+		Tags("test").
+		Response(http.StatusOK, testgrp.Status{})
+
+	admin.HandleWebSocket("/ws", testgrp.Echo).
+		Summary("Websocket echoing every message for admins").
+		Tags("test")
+
+	// The key set changes rarely so verifiers are allowed to cache it for a
+	// while. A verifier meeting an unknown kid is expected to refetch it.
+	wkg := wellknowngrp.Handlers{
+		Keys:   cfg.Keys,
+		Issuer: cfg.Issuer,
+	}
+	app.Handle(http.MethodGet, wellknowngrp.JWKSPath, wkg.JWKS, web.CacheControl("public, max-age=900")).
+		Public().
+		Summary("Public keys tokens are signed with").
+		Tags("auth").
+		Response(http.StatusOK, keystore.JWKS{})
+	app.Handle(http.MethodGet, wellknowngrp.OpenIDConfigPath, wkg.OpenIDConfig, web.CacheControl("public, max-age=3600")).
+		Public().
+		Summary("Discovery document of the token issuer").
+		Tags("auth").
+		Response(http.StatusOK, wellknowngrp.OpenIDConfig{})
+
+	openAPI := web.OpenAPIConfig{
+		Title:       "Sales API",
+		Description: "Service managing the users and products of the sales system",
+		Version:     cfg.Build,
+		SecuritySchemes: map[string]web.SecurityScheme{
+			mid.SecuritySchemeBearer: {
+				Type:         "http",
+				Scheme:       "bearer",
+				BearerFormat: "JWT",
+			},
+		},
+		ErrorResponse:   v1.ErrorResponse{},
+		ProblemResponse: v1.ProblemDetails{},
+	}
+
+	// Routes of a later version are added with their own version group so
+	// both versions are served side by side. A version can respond with
+	// another error shape by passing mid.Errors to the group.
+	var v1MW []web.Middleware
+	if cfg.HandlerTimeout > 0 {
+		v1MW = append(v1MW, web.Timeout(cfg.HandlerTimeout))
+	}
+	v1API := app.Version("v1", v1MW...)
+
+	v1API.Handle(http.MethodGet, "/openapi.json", app.OpenAPIHandler(openAPI)).
+		Public().
+		Summary("OpenAPI document of the API").
+		Tags("docs")
+
+	ugh := usergrp.Handlers{
+		User:        cfg.UserCore,
+		Auth:        cfg.Auth,
+		Keys:        cfg.Keys,
+		TokenExpiry: cfg.TokenExpiry,
+	}
+	if cfg.Refresh != nil {
+		ugh.RefreshTokens = refresh.New(cfg.Log, cfg.Refresh, cfg.RefreshExpiry)
+	}
+
+	// Tokens are credentials so they must never be cached. They are signed
+	// with the active key unless the client asks for a kid.
+	for _, path := range []string{"/users/token", "/users/token/:kid"} {
+		v1API.Handle(http.MethodGet, path, ugh.Token, web.CacheControl("no-store")).
+			Public().
+			Summary("Token for the user sending basic auth credentials").
+			Tags("users").
+			Response(http.StatusOK, usergrp.Token{})
+		v1API.Handle(http.MethodPost, path, ugh.Token, web.CacheControl("no-store")).
+			Public().
+			Summary("Token for the user sending basic auth or body credentials").
+			Tags("users").
+			Request(usergrp.Credentials{}).
+			Response(http.StatusOK, usergrp.Token{})
+	}
+
+	// Clients polling a user may keep a copy but must revalidate it, which
+	// costs a 304 as long as the user doesn't change.
+	v1API.Handle(http.MethodGet, "/users/:user_id", ugh.QueryByID, mid.Authenticate(cfg.Auth), mid.Authorize(cfg.Auth, auth.RuleAdminOnly), web.CacheControl("private, no-cache")).
+		Annotate(web.AnnotationSecurity, mid.SecuritySchemeBearer).
+		Annotate(web.AnnotationRule, auth.RuleAdminOnly).
+		Summary("User by id").
+		Tags("users").
+		Response(http.StatusOK, usergrp.AppUser{}).
+		Response(http.StatusNotFound, nil)
 
-	app.Handle(http.MethodGet, "/test", testgrp.Test)
-	app.Handle(http.MethodGet, "/test/auth", testgrp.Test, mid.Authenticate(cfg.Auth), mid.Authorize(cfg.Auth, auth.RuleAdminOnly))
+	if ugh.RefreshTokens != nil {
+		v1API.Handle(http.MethodPost, "/users/refresh", ugh.Refresh, web.CacheControl("no-store")).
+			Public().
+			Summary("New token for a refresh token").
+			Description("A refresh token can only be used once. Using it again revokes every refresh token issued from the same login.").
+			Tags("users").
+			Request(usergrp.RefreshRequest{}).
+			Response(http.StatusOK, usergrp.Token{})
+	}
 
 	return app
 }
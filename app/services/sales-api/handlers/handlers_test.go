@@ -0,0 +1,189 @@
+package handlers_test
+
+import (
+	"github.com/theo-bot/service4.1-video/app/services/sales-api/handlers"
+	"github.com/theo-bot/service4.1-video/app/services/sales-api/handlers/v1/wellknowngrp"
+	"github.com/theo-bot/service4.1-video/business/core/user"
+	"github.com/theo-bot/service4.1-video/business/web/refresh"
+	"github.com/theo-bot/service4.1-video/business/web/v1/apitest"
+	"github.com/theo-bot/service4.1-video/business/web/v1/mid"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func boot(test *apitest.Test) http.Handler {
+	return handlers.APIMux(handlers.APIMuxConfig{
+		Build:         "test",
+		Shutdown:      test.Shutdown,
+		Log:           test.Log,
+		Auth:          test.Auth,
+		CORS:          mid.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+		Keys:          test.Keys,
+		Issuer:        apitest.Issuer,
+		UserCore:      test.UserCore,
+		TokenExpiry:   time.Hour,
+		Refresh:       refresh.NewMemory(),
+		RefreshExpiry: time.Hour,
+	})
+}
+
+// testRoute checks the response of the test route, which panics for half of
+// the requests to show how panics are handled
+func testRoute(t *testing.T, w *httptest.ResponseRecorder) {
+	switch w.Code {
+	case http.StatusOK:
+		apitest.Golden(t, "test_ok", w.Body.Bytes())
+	case http.StatusInternalServerError:
+		apitest.Golden(t, "test_panic", w.Body.Bytes())
+	default:
+		t.Errorf("status code: got %d, want %d or %d", w.Code, http.StatusOK, http.StatusInternalServerError)
+	}
+}
+
+func TestTestRoutes(t *testing.T) {
+	test := apitest.New(t, boot)
+
+	admin := test.Token("admin", user.RoleAdmin)
+	usr := test.Token("user", user.RoleUser)
+
+	var cases []apitest.Case
+	for i := 0; i < 10; i++ {
+		cases = append(cases,
+			apitest.Case{
+				Name:   "public",
+				Method: http.MethodGet,
+				URL:    "/test",
+				Check:  testRoute,
+			},
+			apitest.Case{
+				Name:   "admin",
+				Method: http.MethodGet,
+				URL:    "/test/auth",
+				Token:  admin,
+				Check:  testRoute,
+			},
+		)
+	}
+
+	cases = append(cases,
+		apitest.Case{
+			Name:       "no token",
+			Method:     http.MethodGet,
+			URL:        "/test/auth",
+			StatusCode: http.StatusUnauthorized,
+			Golden:     "unauthorized",
+		},
+		apitest.Case{
+			Name:       "invalid token",
+			Method:     http.MethodGet,
+			URL:        "/test/auth",
+			Token:      "invalid",
+			StatusCode: http.StatusUnauthorized,
+			Golden:     "unauthorized",
+		},
+		apitest.Case{
+			Name:       "not an admin",
+			Method:     http.MethodGet,
+			URL:        "/test/auth",
+			Token:      usr,
+			StatusCode: http.StatusUnauthorized,
+			Golden:     "unauthorized",
+		},
+	)
+
+	test.Run(t, cases)
+}
+
+func TestErrors(t *testing.T) {
+	test := apitest.New(t, boot)
+
+	contentType := func(want string) func(t *testing.T, w *httptest.ResponseRecorder) {
+		return func(t *testing.T, w *httptest.ResponseRecorder) {
+			if got := w.Header().Get("Content-Type"); got != want {
+				t.Errorf("content type = %q, want %q", got, want)
+			}
+		}
+	}
+
+	test.Run(t, []apitest.Case{
+		{
+			Name:       "error not hidden behind a 406",
+			Method:     http.MethodGet,
+			URL:        "/test/auth",
+			Header:     http.Header{"Accept": {"image/png"}},
+			StatusCode: http.StatusUnauthorized,
+			Golden:     "unauthorized",
+			Check:      contentType("application/json"),
+		},
+		{
+			Name:       "problem details",
+			Method:     http.MethodGet,
+			URL:        "/test/auth",
+			Header:     http.Header{"Accept": {"application/problem+json"}},
+			StatusCode: http.StatusUnauthorized,
+			Golden:     "unauthorized_problem",
+			Ignore:     []string{"instance"},
+			Check:      contentType("application/problem+json"),
+		},
+		{
+			Name:       "malformed body",
+			Method:     http.MethodPost,
+			URL:        "/v1/users/token",
+			Body:       `{"name":`,
+			StatusCode: http.StatusBadRequest,
+			Golden:     "malformed_body",
+		},
+		{
+			Name:       "validation",
+			Method:     http.MethodPost,
+			URL:        "/v1/users/token",
+			Body:       map[string]any{"email": "not an email"},
+			StatusCode: http.StatusBadRequest,
+			Golden:     "validation",
+		},
+		{
+			Name:       "unknown route",
+			Method:     http.MethodGet,
+			URL:        "/v1/unknown",
+			StatusCode: http.StatusNotFound,
+		},
+	})
+}
+
+func TestWellKnown(t *testing.T) {
+	test := apitest.New(t, boot)
+
+	test.Run(t, []apitest.Case{
+		{
+			Name:       "jwks",
+			Method:     http.MethodGet,
+			URL:        wellknowngrp.JWKSPath,
+			StatusCode: http.StatusOK,
+			Golden:     "jwks",
+			Ignore:     []string{"kid", "n"},
+		},
+		{
+			Name:       "openid configuration",
+			Method:     http.MethodGet,
+			URL:        wellknowngrp.OpenIDConfigPath,
+			StatusCode: http.StatusOK,
+			Golden:     "openid_configuration",
+		},
+	})
+}
+
+func TestOpenAPI(t *testing.T) {
+	test := apitest.New(t, boot)
+
+	test.Run(t, []apitest.Case{
+		{
+			Name:       "document",
+			Method:     http.MethodGet,
+			URL:        "/v1/openapi.json",
+			StatusCode: http.StatusOK,
+			Golden:     "openapi",
+		},
+	})
+}
@@ -7,6 +7,11 @@ import (
 	"net/http"
 )
 
+// Status represents the response of the test route
+type Status struct {
+	Status string
+}
+
 // Test is our example route
 func Test(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	if n := rand.Intn(100); n%2 == 0 {
@@ -15,11 +20,23 @@ func Test(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		panic("OOOOHHH NO PANIC")
 	}
 
-	status := struct {
-		Status string
-	}{
+	status := Status{
 		Status: "OK",
 	}
 
 	return web.Respond(ctx, w, status, http.StatusOK)
 }
+
+// Echo is our example websocket route that sends every message back
+func Echo(ctx context.Context, ws *web.WebSocket) error {
+	for {
+		typ, msg, err := ws.Read(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := ws.Write(ctx, typ, msg); err != nil {
+			return err
+		}
+	}
+}
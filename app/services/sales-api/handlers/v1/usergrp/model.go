@@ -0,0 +1,36 @@
+package usergrp
+
+import (
+	"github.com/theo-bot/service4.1-video/business/core/user"
+	"time"
+)
+
+// AppUser represents information about an individual user
+type AppUser struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles"`
+	Department  string   `json:"department"`
+	Enabled     bool     `json:"enabled"`
+	DateCreated string   `json:"dateCreated"`
+	DateUpdated string   `json:"dateUpdated"`
+}
+
+func toAppUser(usr user.User) AppUser {
+	roles := make([]string, len(usr.Roles))
+	for i, role := range usr.Roles {
+		roles[i] = role.Name()
+	}
+
+	return AppUser{
+		ID:          usr.ID.String(),
+		Name:        usr.Name,
+		Email:       usr.Email.Address,
+		Roles:       roles,
+		Department:  usr.Department,
+		Enabled:     usr.Enabled,
+		DateCreated: usr.DateCreated.Format(time.RFC3339),
+		DateUpdated: usr.DateUpdated.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,278 @@
+// Package usergrp provides the user endpoints of the API
+package usergrp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/theo-bot/service4.1-video/business/core/user"
+	"github.com/theo-bot/service4.1-video/business/sys/validate"
+	"github.com/theo-bot/service4.1-video/business/web/auth"
+	"github.com/theo-bot/service4.1-video/business/web/refresh"
+	v1 "github.com/theo-bot/service4.1-video/business/web/v1"
+	"github.com/theo-bot/service4.1-video/foundation/keystore"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"time"
+)
+
+// Credentials are the email and password of the user asking for a token
+type Credentials struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+// Validate checks the credentials are complete
+func (c Credentials) Validate() error {
+	return validate.Check(c)
+}
+
+// RefreshRequest holds the refresh token exchanged for a new token
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Validate checks the refresh token is present
+func (rr RefreshRequest) Validate() error {
+	return validate.Check(rr)
+}
+
+// Token represents a token issued to a user. The refresh token is used once
+// to get the next token when the token expires
+type Token struct {
+	Token        string `json:"token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// KeySet declares the behavior needed to pick the key tokens are signed
+// with when the client doesn't ask for one
+type KeySet interface {
+	ActiveKID() string
+}
+
+// Handlers manages the set of user endpoints
+type Handlers struct {
+	User *user.Core
+	Auth *auth.Auth
+	Keys KeySet
+
+	// TokenExpiry is how long the tokens issued to users are valid
+	TokenExpiry time.Duration
+
+	// RefreshTokens issues the refresh tokens. No refresh tokens are issued
+	// when it's nil
+	RefreshTokens *refresh.Tokens
+}
+
+// Token authenticates the user and returns a token signed with the active
+// key, or with the key of the kid in the path when there is one. The
+// credentials are sent with basic auth, or as a JSON body when the request
+// is a POST
+func (h Handlers) Token(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	kid := web.Param(r, "kid")
+
+	creds, err := credentials(r)
+	if err != nil {
+		return unauthorized(w, err)
+	}
+
+	email, err := mail.ParseAddress(creds.Email)
+	if err != nil {
+		return unauthorized(w, auth.NewAuthError("invalid email %q", creds.Email))
+	}
+
+	usr, err := h.User.Authenticate(ctx, *email, creds.Password)
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) || errors.Is(err, user.ErrAuthenticationFailure) {
+			return unauthorized(w, auth.NewAuthError("authenticating %s: %s", email.Address, err))
+		}
+		return fmt.Errorf("authenticate: %w", err)
+	}
+
+	if !usr.Enabled {
+		return v1.NewRequestError(errors.New("user is disabled"), http.StatusForbidden)
+	}
+
+	tkn, err := h.token(ctx, usr, kid)
+	if err != nil {
+		if kid != "" && errors.Is(err, keystore.ErrKeyNotFound) {
+			return v1.NewRequestError(fmt.Errorf("kid %q not found", kid), http.StatusNotFound)
+		}
+		return err
+	}
+
+	if h.RefreshTokens != nil {
+		tkn.RefreshToken, err = h.RefreshTokens.Issue(ctx, usr.ID.String(), kid)
+		if err != nil {
+			return fmt.Errorf("issue: %w", err)
+		}
+	}
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// Refresh exchanges a refresh token for a new token and refresh token. The
+// refresh token can only be used once, using it again revokes every refresh
+// token issued from the same login. The refresh token stays valid when no
+// token can be issued for it
+func (h Handlers) Refresh(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var rr RefreshRequest
+	if err := web.Decode(r, &rr); err != nil {
+		return err
+	}
+
+	var tkn Token
+	grant := func(rec refresh.Record) error {
+		// The roles and status of the user may have changed since the
+		// login so they are read again.
+		userID, err := uuid.Parse(rec.Subject)
+		if err != nil {
+			return fmt.Errorf("parsing subject: %w", err)
+		}
+
+		usr, err := h.User.QueryByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, user.ErrNotFound) {
+				return h.revoke(ctx, rec, auth.NewAuthError("refreshing token: %s", err))
+			}
+			return fmt.Errorf("querybyid: userID[%s]: %w", userID, err)
+		}
+
+		if !usr.Enabled {
+			return h.revoke(ctx, rec, v1.NewRequestError(errors.New("user is disabled"), http.StatusForbidden))
+		}
+
+		tkn, err = h.token(ctx, usr, rec.KID)
+		if err != nil {
+			if rec.KID != "" && errors.Is(err, keystore.ErrKeyNotFound) {
+				return h.revoke(ctx, rec, auth.NewAuthError("refreshing token: kid %s retired", rec.KID))
+			}
+			return err
+		}
+
+		return nil
+	}
+
+	next, err := h.RefreshTokens.Rotate(ctx, rr.RefreshToken, grant)
+	if err != nil {
+		switch {
+		case errors.Is(err, refresh.ErrNotFound),
+			errors.Is(err, refresh.ErrExpired),
+			errors.Is(err, refresh.ErrRevoked),
+			errors.Is(err, refresh.ErrReused):
+			return auth.NewAuthError("refreshing token: %s", err)
+		}
+		return err
+	}
+	tkn.RefreshToken = next
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// QueryByID returns the user with the id in the path. The entity tag and
+// the last modification time of the response are derived from the time the
+// user was last updated so clients polling the user get a 304 until it
+// changes
+func (h Handlers) QueryByID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID, err := uuid.Parse(web.Param(r, "user_id"))
+	if err != nil {
+		return v1.NewRequestError(fmt.Errorf("invalid user id %q", web.Param(r, "user_id")), http.StatusBadRequest)
+	}
+
+	usr, err := h.User.QueryByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return v1.NewRequestError(err, http.StatusNotFound)
+		}
+		return fmt.Errorf("querybyid: userID[%s]: %w", userID, err)
+	}
+
+	web.SetETag(ctx, strconv.FormatInt(usr.DateUpdated.UnixNano(), 36))
+	web.SetLastModified(ctx, usr.DateUpdated)
+
+	return web.Respond(ctx, w, toAppUser(usr), http.StatusOK)
+}
+
+// =============================================================================
+
+// token generates a token for the user signed with the key of the kid. The
+// active key is used when the kid is empty
+func (h Handlers) token(ctx context.Context, usr user.User, kid string) (Token, error) {
+	if kid == "" {
+		kid = h.Keys.ActiveKID()
+		if kid == "" {
+			return Token{}, errors.New("no active kid")
+		}
+	}
+
+	now := time.Now().UTC()
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   usr.ID.String(),
+			Issuer:    h.Auth.Issuer(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(h.TokenExpiry)),
+		},
+		Roles: usr.Roles,
+	}
+
+	token, err := h.Auth.GenerateToken(kid, claims)
+	if err != nil {
+		return Token{}, fmt.Errorf("generatetoken: %w", err)
+	}
+
+	tkn := Token{
+		Token:     token,
+		TokenType: "Bearer",
+		ExpiresIn: int(h.TokenExpiry / time.Second),
+	}
+
+	return tkn, nil
+}
+
+// revoke revokes the family of the refresh token when it can no longer be
+// used and returns the error for the client
+func (h Handlers) revoke(ctx context.Context, rec refresh.Record, err error) error {
+	if rerr := h.RefreshTokens.RevokeFamily(ctx, rec.FamilyID); rerr != nil {
+		return fmt.Errorf("%w: %w", err, rerr)
+	}
+	return err
+}
+
+// credentials returns the credentials sent with the request. Basic auth
+// takes precedence over the body
+func credentials(r *http.Request) (Credentials, error) {
+	if email, password, ok := r.BasicAuth(); ok {
+		if email == "" || password == "" {
+			return Credentials{}, auth.NewAuthError("basic auth credentials incomplete")
+		}
+		return Credentials{Email: email, Password: password}, nil
+	}
+
+	if r.Method != http.MethodPost {
+		return Credentials{}, auth.NewAuthError("basic auth credentials expected")
+	}
+
+	var creds Credentials
+	if err := web.Decode(r, &creds); err != nil {
+		return Credentials{}, err
+	}
+
+	return creds, nil
+}
+
+// unauthorized tells the client how to authenticate when the credentials
+// are missing or wrong
+func unauthorized(w http.ResponseWriter, err error) error {
+	if auth.IsAuthError(err) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="sales-api", charset="UTF-8"`)
+	}
+	return err
+}
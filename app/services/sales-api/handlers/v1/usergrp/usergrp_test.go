@@ -0,0 +1,371 @@
+package usergrp_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/theo-bot/service4.1-video/app/services/sales-api/handlers"
+	"github.com/theo-bot/service4.1-video/app/services/sales-api/handlers/v1/usergrp"
+	"github.com/theo-bot/service4.1-video/business/core/user"
+	"github.com/theo-bot/service4.1-video/business/web/refresh"
+	"github.com/theo-bot/service4.1-video/business/web/v1/apitest"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"testing"
+	"time"
+)
+
+const tokenExpiry = 15 * time.Minute
+
+func boot(test *apitest.Test) http.Handler {
+	return handlers.APIMux(handlers.APIMuxConfig{
+		Shutdown:      test.Shutdown,
+		Log:           test.Log,
+		Auth:          test.Auth,
+		Keys:          test.Keys,
+		Issuer:        apitest.Issuer,
+		UserCore:      test.UserCore,
+		TokenExpiry:   tokenExpiry,
+		Refresh:       refresh.NewMemory(),
+		RefreshExpiry: time.Hour,
+	})
+}
+
+func newUser(t *testing.T, test *apitest.Test, email string, password string, enabled bool) user.User {
+	t.Helper()
+
+	usr := test.CreateUser(user.NewUser{
+		Name:            "Test User",
+		Email:           mail.Address{Address: email},
+		Roles:           []user.Role{user.RoleUser},
+		Password:        password,
+		PasswordConfirm: password,
+	})
+
+	if !enabled {
+		var err error
+		usr, err = test.UserCore.Update(context.Background(), usr, user.UpdateUser{Enabled: &enabled})
+		if err != nil {
+			t.Fatalf("disabling user: %s", err)
+		}
+	}
+
+	return usr
+}
+
+func basicAuth(email string, password string) http.Header {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth(email, password)
+	return http.Header{"Authorization": r.Header["Authorization"]}
+}
+
+// checkToken verifies the token and checks its claims were issued for the
+// user and signed with the key of the kid
+func checkToken(test *apitest.Test, usr user.User, kid string) func(t *testing.T, w *httptest.ResponseRecorder) {
+	return func(t *testing.T, w *httptest.ResponseRecorder) {
+		var tkn usergrp.Token
+		if err := json.Unmarshal(w.Body.Bytes(), &tkn); err != nil {
+			t.Fatalf("decoding token: %s", err)
+		}
+
+		if tkn.TokenType != "Bearer" || tkn.ExpiresIn != int(tokenExpiry/time.Second) {
+			t.Errorf("token type %q expires in %d, want Bearer and %d", tkn.TokenType, tkn.ExpiresIn, int(tokenExpiry/time.Second))
+		}
+		if tkn.RefreshToken == "" {
+			t.Errorf("no refresh token issued")
+		}
+
+		claims, err := test.Auth.Authenticate(context.Background(), "Bearer "+tkn.Token)
+		if err != nil {
+			t.Fatalf("authenticating token: %s", err)
+		}
+
+		if claims.ID == "" {
+			t.Errorf("token has no jti")
+		}
+		if claims.Issuer != apitest.Issuer {
+			t.Errorf("iss = %q, want %q", claims.Issuer, apitest.Issuer)
+		}
+		if claims.Subject != usr.ID.String() {
+			t.Errorf("sub = %q, want %q", claims.Subject, usr.ID)
+		}
+		if claims.IssuedAt == nil || claims.ExpiresAt == nil || claims.ExpiresAt.Sub(claims.IssuedAt.Time) != tokenExpiry {
+			t.Errorf("iat %v exp %v, want %s apart", claims.IssuedAt, claims.ExpiresAt, tokenExpiry)
+		}
+
+		token, _, err := jwt.NewParser().ParseUnverified(tkn.Token, &jwt.RegisteredClaims{})
+		if err != nil {
+			t.Fatalf("parsing token: %s", err)
+		}
+		if token.Header["kid"] != kid {
+			t.Errorf("kid = %v, want %s", token.Header["kid"], kid)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	test := apitest.New(t, boot)
+
+	usr := newUser(t, test, "user@example.com", "gophers", true)
+	newUser(t, test, "disabled@example.com", "gophers", false)
+
+	challenge := func(t *testing.T, w *httptest.ResponseRecorder) {
+		if got := w.Header().Get("WWW-Authenticate"); got == "" {
+			t.Errorf("no WWW-Authenticate challenge")
+		}
+	}
+
+	test.Run(t, []apitest.Case{
+		{
+			Name:       "basic auth",
+			Method:     http.MethodGet,
+			URL:        "/v1/users/token",
+			Header:     basicAuth("user@example.com", "gophers"),
+			StatusCode: http.StatusOK,
+			Check:      checkToken(test, usr, test.KID),
+		},
+		{
+			Name:       "basic auth with kid",
+			Method:     http.MethodGet,
+			URL:        "/v1/users/token/" + test.KID,
+			Header:     basicAuth("user@example.com", "gophers"),
+			StatusCode: http.StatusOK,
+			Check:      checkToken(test, usr, test.KID),
+		},
+		{
+			Name:       "body",
+			Method:     http.MethodPost,
+			URL:        "/v1/users/token",
+			Body:       usergrp.Credentials{Email: "user@example.com", Password: "gophers"},
+			StatusCode: http.StatusOK,
+			Check:      checkToken(test, usr, test.KID),
+		},
+		{
+			Name:       "bad password",
+			Method:     http.MethodGet,
+			URL:        "/v1/users/token",
+			Header:     basicAuth("user@example.com", "rust"),
+			StatusCode: http.StatusUnauthorized,
+			Golden:     "token_unauthorized",
+			Check:      challenge,
+		},
+		{
+			Name:       "unknown user",
+			Method:     http.MethodGet,
+			URL:        "/v1/users/token",
+			Header:     basicAuth("nobody@example.com", "gophers"),
+			StatusCode: http.StatusUnauthorized,
+			Golden:     "token_unauthorized",
+			Check:      challenge,
+		},
+		{
+			Name:       "no credentials",
+			Method:     http.MethodGet,
+			URL:        "/v1/users/token",
+			StatusCode: http.StatusUnauthorized,
+			Golden:     "token_unauthorized",
+			Check:      challenge,
+		},
+		{
+			Name:       "incomplete body",
+			Method:     http.MethodPost,
+			URL:        "/v1/users/token",
+			Body:       usergrp.Credentials{Email: "user@example.com"},
+			StatusCode: http.StatusBadRequest,
+			Golden:     "token_incomplete",
+		},
+		{
+			Name:       "disabled user",
+			Method:     http.MethodGet,
+			URL:        "/v1/users/token",
+			Header:     basicAuth("disabled@example.com", "gophers"),
+			StatusCode: http.StatusForbidden,
+			Golden:     "token_disabled",
+		},
+		{
+			Name:       "unknown kid",
+			Method:     http.MethodGet,
+			URL:        "/v1/users/token/unknown",
+			Header:     basicAuth("user@example.com", "gophers"),
+			StatusCode: http.StatusNotFound,
+			Golden:     "token_unknown_kid",
+		},
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	test := apitest.New(t, boot)
+
+	usr := newUser(t, test, "user@example.com", "gophers", true)
+	other := newUser(t, test, "other@example.com", "gophers", true)
+
+	login := func(email string) string {
+		t.Helper()
+
+		w := test.Do(t, http.MethodGet, "/v1/users/token", "", basicAuth(email, "gophers"), nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("login status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+
+		var tkn usergrp.Token
+		if err := json.Unmarshal(w.Body.Bytes(), &tkn); err != nil {
+			t.Fatalf("decoding token: %s", err)
+		}
+		return tkn.RefreshToken
+	}
+
+	// next is filled in by the first case with the refresh token it got.
+	var next string
+	first := login("user@example.com")
+	otherToken := login("other@example.com")
+
+	rotated := func(t *testing.T, w *httptest.ResponseRecorder) {
+		checkToken(test, usr, test.KID)(t, w)
+
+		var tkn usergrp.Token
+		if err := json.Unmarshal(w.Body.Bytes(), &tkn); err != nil {
+			t.Fatalf("decoding token: %s", err)
+		}
+		if tkn.RefreshToken == first {
+			t.Errorf("refresh token wasn't rotated")
+		}
+		next = tkn.RefreshToken
+	}
+
+	test.Run(t, []apitest.Case{
+		{
+			Name:       "rotate",
+			Method:     http.MethodPost,
+			URL:        "/v1/users/refresh",
+			Body:       usergrp.RefreshRequest{RefreshToken: first},
+			StatusCode: http.StatusOK,
+			Check:      rotated,
+		},
+		{
+			Name:       "reuse",
+			Method:     http.MethodPost,
+			URL:        "/v1/users/refresh",
+			Body:       usergrp.RefreshRequest{RefreshToken: first},
+			StatusCode: http.StatusUnauthorized,
+			Golden:     "token_unauthorized",
+		},
+		{
+			Name:       "unknown",
+			Method:     http.MethodPost,
+			URL:        "/v1/users/refresh",
+			Body:       usergrp.RefreshRequest{RefreshToken: "unknown"},
+			StatusCode: http.StatusUnauthorized,
+			Golden:     "token_unauthorized",
+		},
+		{
+			Name:       "missing",
+			Method:     http.MethodPost,
+			URL:        "/v1/users/refresh",
+			Body:       usergrp.RefreshRequest{},
+			StatusCode: http.StatusBadRequest,
+			Golden:     "refresh_missing",
+		},
+	})
+
+	// The reuse revoked the family so the token the rotation returned is
+	// refused too.
+	w := test.Do(t, http.MethodPost, "/v1/users/refresh", "", nil, usergrp.RefreshRequest{RefreshToken: next})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("rotated token after reuse status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// A disabled user can't refresh the tokens issued before.
+	enabled := false
+	if _, err := test.UserCore.Update(context.Background(), other, user.UpdateUser{Enabled: &enabled}); err != nil {
+		t.Fatalf("disabling user: %s", err)
+	}
+
+	w = test.Do(t, http.MethodPost, "/v1/users/refresh", "", nil, usergrp.RefreshRequest{RefreshToken: otherToken})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("disabled user status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestQueryByID(t *testing.T) {
+	test := apitest.New(t, boot)
+
+	usr := newUser(t, test, "user@example.com", "gophers", true)
+	admin := test.Token(uuid.NewString(), user.RoleAdmin)
+	url := "/v1/users/" + usr.ID.String()
+
+	// etag is filled in by the first case with the entity tag it got.
+	var etag string
+	validators := func(t *testing.T, w *httptest.ResponseRecorder) {
+		etag = w.Header().Get("ETag")
+		if etag == "" || etag[0] != '"' {
+			t.Errorf("etag = %q, want a strong entity tag", etag)
+		}
+		if got, want := w.Header().Get("Last-Modified"), usr.DateUpdated.UTC().Format(http.TimeFormat); got != want {
+			t.Errorf("last modified = %q, want %q", got, want)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "private, no-cache" {
+			t.Errorf("cache control = %q, want %q", got, "private, no-cache")
+		}
+	}
+
+	test.Run(t, []apitest.Case{
+		{
+			Name:       "found",
+			Method:     http.MethodGet,
+			URL:        url,
+			Token:      admin,
+			StatusCode: http.StatusOK,
+			Golden:     "user",
+			Ignore:     []string{"id", "dateCreated", "dateUpdated"},
+			Check:      validators,
+		},
+		{
+			Name:       "unknown",
+			Method:     http.MethodGet,
+			URL:        "/v1/users/" + uuid.NewString(),
+			Token:      admin,
+			StatusCode: http.StatusNotFound,
+		},
+		{
+			Name:       "invalid id",
+			Method:     http.MethodGet,
+			URL:        "/v1/users/123",
+			Token:      admin,
+			StatusCode: http.StatusBadRequest,
+		},
+		{
+			Name:       "not an admin",
+			Method:     http.MethodGet,
+			URL:        url,
+			Token:      test.TokenFor(usr),
+			StatusCode: http.StatusUnauthorized,
+		},
+	})
+
+	// The client holding the current copy gets a 304 until the user is
+	// updated.
+	w := test.Do(t, http.MethodGet, url, admin, http.Header{"If-None-Match": {etag}}, nil)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("current copy status = %d with %d bytes, want %d", w.Code, w.Body.Len(), http.StatusNotModified)
+	}
+
+	w = test.Do(t, http.MethodGet, url, admin, http.Header{"If-Modified-Since": {usr.DateUpdated.UTC().Format(http.TimeFormat)}}, nil)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("not modified since status = %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	name := "Updated User"
+	if _, err := test.UserCore.Update(context.Background(), usr, user.UpdateUser{Name: &name}); err != nil {
+		t.Fatalf("updating user: %s", err)
+	}
+
+	w = test.Do(t, http.MethodGet, url, admin, http.Header{"If-None-Match": {etag}}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("stale copy status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("ETag"); got == etag {
+		t.Errorf("etag %s did not change with the user", got)
+	}
+}
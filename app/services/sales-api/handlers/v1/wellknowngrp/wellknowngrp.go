@@ -0,0 +1,97 @@
+// Package wellknowngrp provides the discovery endpoints other services use
+// to verify the tokens issued by the API
+package wellknowngrp
+
+import (
+	"context"
+	"fmt"
+	"github.com/theo-bot/service4.1-video/foundation/keystore"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Set of paths of the discovery endpoints
+const (
+	JWKSPath         = "/.well-known/jwks.json"
+	OpenIDConfigPath = "/.well-known/openid-configuration"
+)
+
+// KeySet declares the behavior needed to publish the public keys
+type KeySet interface {
+	JWKS() keystore.JWKS
+}
+
+// OpenIDConfig represents the subset of the OpenID Connect discovery
+// document that applies to the tokens the API issues
+type OpenIDConfig struct {
+	Issuer           string   `json:"issuer"`
+	JWKSURI          string   `json:"jwks_uri"`
+	ResponseTypes    []string `json:"response_types_supported"`
+	SubjectTypes     []string `json:"subject_types_supported"`
+	SigningAlgValues []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported  []string `json:"claims_supported"`
+}
+
+// ValidateIssuer checks the issuer can be published in the discovery
+// document. The issuer must be the absolute URL the discovery paths are
+// relative to since verifiers compare it with the URL they fetched the
+// document from
+func ValidateIssuer(issuer string) error {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return fmt.Errorf("parsing issuer: %w", err)
+	}
+
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("issuer %q isn't an absolute http or https URL", issuer)
+	}
+
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("issuer %q has a query or a fragment", issuer)
+	}
+
+	return nil
+}
+
+// Handlers manages the set of discovery endpoints
+type Handlers struct {
+	Keys KeySet
+
+	// Issuer is the URL of the issuer of the tokens. It's checked by
+	// ValidateIssuer
+	Issuer string
+}
+
+// JWKS returns the public keys tokens are signed with as a JSON Web Key Set
+func (h Handlers) JWKS(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return web.Respond(ctx, w, h.Keys.JWKS(), http.StatusOK)
+}
+
+// OpenIDConfig returns the discovery document pointing verifiers to the key
+// set. The key set is located relative to the issuer and never relative to
+// the request, whose host is chosen by the client
+func (h Handlers) OpenIDConfig(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	jwks := h.Keys.JWKS()
+
+	algs := make([]string, 0, len(jwks.Keys))
+	seen := make(map[string]bool)
+	for _, key := range jwks.Keys {
+		if !seen[key.Algorithm] {
+			seen[key.Algorithm] = true
+			algs = append(algs, key.Algorithm)
+		}
+	}
+
+	cfg := OpenIDConfig{
+		Issuer:           h.Issuer,
+		JWKSURI:          strings.TrimRight(h.Issuer, "/") + JWKSPath,
+		ResponseTypes:    []string{"token"},
+		SubjectTypes:     []string{"public"},
+		SigningAlgValues: algs,
+		ClaimsSupported:  []string{"sub", "iss", "exp", "iat", "roles"},
+	}
+
+	return web.Respond(ctx, w, cfg, http.StatusOK)
+}
@@ -0,0 +1,69 @@
+package wellknowngrp
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/theo-bot/service4.1-video/foundation/keystore"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type keySet struct{}
+
+func (keySet) JWKS() keystore.JWKS {
+	return keystore.JWKS{Keys: []keystore.JWK{{KeyID: "kid", Algorithm: "RS256"}}}
+}
+
+func TestValidateIssuer(t *testing.T) {
+	tests := []struct {
+		name    string
+		issuer  string
+		wantErr bool
+	}{
+		{"https", "https://auth.example.com", false},
+		{"http with path", "http://localhost:3000/sales", false},
+		{"name", "service project", true},
+		{"relative", "/sales", true},
+		{"other scheme", "ftp://auth.example.com", true},
+		{"query", "https://auth.example.com?tenant=1", true},
+		{"fragment", "https://auth.example.com#top", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateIssuer(tt.issuer); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateIssuer(%q) error = %v, want error %t", tt.issuer, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpenIDConfig(t *testing.T) {
+	h := Handlers{
+		Keys:   keySet{},
+		Issuer: "https://auth.example.com/",
+	}
+
+	// The host is chosen by the client and must not end up in a document
+	// caches share between clients.
+	r := httptest.NewRequest(http.MethodGet, OpenIDConfigPath, nil)
+	r.Host = "evil.example.com"
+	w := httptest.NewRecorder()
+
+	if err := h.OpenIDConfig(context.Background(), w, r); err != nil {
+		t.Fatalf("handling: %s", err)
+	}
+
+	var cfg OpenIDConfig
+	if err := json.NewDecoder(w.Body).Decode(&cfg); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+
+	if want := "https://auth.example.com" + JWKSPath; cfg.JWKSURI != want {
+		t.Errorf("jwks_uri = %q, want %q", cfg.JWKSURI, want)
+	}
+	if cfg.Issuer != h.Issuer {
+		t.Errorf("issuer = %q, want %q", cfg.Issuer, h.Issuer)
+	}
+}
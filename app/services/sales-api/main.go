@@ -6,12 +6,22 @@ import (
 	"fmt"
 	"github.com/ardanlabs/conf/v3"
 	"github.com/theo-bot/service4.1-video/app/services/sales-api/handlers"
+	"github.com/theo-bot/service4.1-video/app/services/sales-api/handlers/v1/wellknowngrp"
+	"github.com/theo-bot/service4.1-video/business/core/user"
+	"github.com/theo-bot/service4.1-video/business/core/user/stores/usermem"
 	"github.com/theo-bot/service4.1-video/business/web/auth"
+	"github.com/theo-bot/service4.1-video/business/web/refresh"
+	v1 "github.com/theo-bot/service4.1-video/business/web/v1"
 	"github.com/theo-bot/service4.1-video/business/web/v1/debug"
+	"github.com/theo-bot/service4.1-video/business/web/v1/mid"
+	"github.com/theo-bot/service4.1-video/foundation/certstore"
 	"github.com/theo-bot/service4.1-video/foundation/keystore"
+	"github.com/theo-bot/service4.1-video/foundation/keystore/jwks"
 	"github.com/theo-bot/service4.1-video/foundation/logger"
+	"github.com/theo-bot/service4.1-video/foundation/tracer"
 	"go.uber.org/zap"
 	"net/http"
+	"net/mail"
 	"os"
 	"os/signal"
 	"runtime"
@@ -50,15 +60,56 @@ func run(log *zap.SugaredLogger) error {
 		Web struct {
 			ReadTimeout     time.Duration `conf:"default:5s"`
 			WriteTimeout    time.Duration `conf:"default:10s"`
+			HandlerTimeout  time.Duration `conf:"default:5s"`
 			IdleTimeout     time.Duration `conf:"default:120s"`
 			ShutdownTimeout time.Duration `conf:"default:20s,mask"`
+			ShutdownDelay   time.Duration `conf:"default:0s"`
 			APIHost         string        `conf:"default:0.0.0.0:3000"`
 			DebugHost       string        `conf:"default:0.0.0.0:4000"`
+			TLSCertFile     string
+			TLSKeyFile      string
+			TLSReload       time.Duration `conf:"default:1m"`
+			CheckRoutes     bool          `conf:"default:true"`
 		}
 		Auth struct {
-			KeysFolder string `conf:"default:zarf/keys/"`
-			ActiveKID  string `conf:"default:cdd3b9bf-33c0-472c-b762-22c39cddc395"`
-			Issuer     string `conf:"default:service project"`
+			KeysFolder    string        `conf:"default:zarf/keys/"`
+			ActiveKID     string        `conf:"default:cdd3b9bf-33c0-472c-b762-22c39cddc395"`
+			Issuer        string        `conf:"default:http://localhost:3000"`
+			KeysReload    time.Duration `conf:"default:1m"`
+			TokenExpiry   time.Duration `conf:"default:15m"`
+			RefreshExpiry time.Duration `conf:"default:720h"`
+
+			// The tokens of the corporate identity provider are accepted
+			// when its issuer and key set are configured
+			IDPIssuer     string
+			IDPKeysURL    string
+			IDPMinRefresh time.Duration `conf:"default:30s"`
+			IDPMaxRefresh time.Duration `conf:"default:1h"`
+		}
+		Users struct {
+			AdminName     string `conf:"default:Admin"`
+			AdminEmail    string `conf:"default:admin@example.com"`
+			AdminPassword string `conf:"mask"`
+		}
+		CORS struct {
+			AllowedOrigins   []string
+			AllowedMethods   []string      `conf:"default:GET;POST;PUT;PATCH;DELETE"`
+			AllowedHeaders   []string      `conf:"default:Accept;Authorization;Content-Type;Idempotency-Key;Traceparent;Tracestate"`
+			ExposedHeaders   []string      `conf:"default:ETag;Location;X-Trace-ID;Idempotent-Replayed"`
+			AllowCredentials bool          `conf:"default:false"`
+			MaxAge           time.Duration `conf:"default:1h"`
+		}
+		Errors struct {
+			Format          string `conf:"default:legacy"`
+			ProblemTypeBase string `conf:"default:/problems/"`
+		}
+		Tracer struct {
+			Exporter    string  `conf:"default:none"`
+			ServiceName string  `conf:"default:sales-api"`
+			ReporterURI string  `conf:"default:tempo.sales-system.svc.cluster.local:4318"`
+			Insecure    bool    `conf:"default:true"`
+			FilePath    string  `conf:"default:traces.json"`
+			Probability float64 `conf:"default:0.05"`
 		}
 	}{
 		Version: conf.Version{
@@ -88,9 +139,48 @@ func run(log *zap.SugaredLogger) error {
 		return fmt.Errorf("reading keys: %w", err)
 	}
 
+	if err := wellknowngrp.ValidateIssuer(cfg.Auth.Issuer); err != nil {
+		return fmt.Errorf("validating issuer: %w", err)
+	}
+
+	// The keys of the identity provider are fetched from its key set and
+	// only verify the tokens it issued.
+	var trusted []auth.TrustedIssuer
+	var idpKeys *jwks.Lookup
+
+	switch {
+	case cfg.Auth.IDPIssuer == "" && cfg.Auth.IDPKeysURL == "":
+		// No identity provider is configured.
+
+	case cfg.Auth.IDPIssuer == "" || cfg.Auth.IDPKeysURL == "":
+		return errors.New("identity provider requires both an issuer and a key set url")
+
+	default:
+		jwksCfg := jwks.Config{
+			URL:        cfg.Auth.IDPKeysURL,
+			MinRefresh: cfg.Auth.IDPMinRefresh,
+			MaxRefresh: cfg.Auth.IDPMaxRefresh,
+			OnInvalidKey: func(kid string, err error) {
+				log.Errorw("idp keys", "status", "skipping invalid key", "kid", kid, "ERROR", err)
+			},
+		}
+
+		idpKeys, err = jwks.New(jwksCfg)
+		if err != nil {
+			return fmt.Errorf("constructing identity provider keys: %w", err)
+		}
+
+		trusted = append(trusted, auth.TrustedIssuer{
+			Issuer:    cfg.Auth.IDPIssuer,
+			KeyLookup: idpKeys,
+		})
+	}
+
 	authCfg := auth.Config{
 		Log:       log,
 		KeyLookup: ks,
+		Issuer:    cfg.Auth.Issuer,
+		Trusted:   trusted,
 	}
 
 	auth, err := auth.New(authCfg)
@@ -98,6 +188,98 @@ func run(log *zap.SugaredLogger) error {
 		return fmt.Errorf("cnstructing auth: %w", err)
 	}
 
+	// The active key can be changed through the debug service later on.
+	if err := ks.SetActiveKID(cfg.Auth.ActiveKID); err != nil {
+		return fmt.Errorf("setting active kid: %w", err)
+	}
+
+	// Tokens signed with a retired key must be refused right away, not when
+	// the service restarts.
+	ks.OnRetire(func(kids []string) {
+		auth.InvalidateKeys(kids...)
+
+		for _, kid := range kids {
+			if kid == ks.ActiveKID() {
+				log.Errorw("keys", "status", "active key retired, tokens can't be signed until another key is activated", "kid", kid)
+			}
+		}
+	})
+
+	keysCtx, keysCancel := context.WithCancel(context.Background())
+	defer keysCancel()
+
+	logReload := func(added []string, retired []string, err error) {
+		if err != nil {
+			log.Errorw("keys", "status", "reloading keys", "ERROR", err)
+			return
+		}
+		log.Infow("keys", "status", "keys reloaded", "added", added, "retired", retired)
+	}
+
+	if cfg.Auth.KeysReload > 0 {
+		go ks.Watch(keysCtx, cfg.Auth.KeysReload, logReload)
+	}
+
+	if idpKeys != nil {
+		idpKeys.OnRetire(func(kids []string) {
+			auth.InvalidateKeys(kids...)
+		})
+
+		// The key set is fetched right away and then whenever its cache
+		// lifetime runs out. The last keys fetched are used while the
+		// identity provider is down.
+		go idpKeys.Watch(keysCtx, func(kids []string, err error) {
+			if err != nil {
+				log.Errorw("idp keys", "status", "refreshing keys", "ERROR", err)
+				return
+			}
+			log.Infow("idp keys", "status", "keys refreshed", "kids", kids)
+		})
+	}
+
+	// Operators can rotate keys right away by sending a SIGHUP.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	go func() {
+		for {
+			select {
+			case <-keysCtx.Done():
+				return
+			case <-reload:
+				added, retired, err := ks.Reload()
+				logReload(added, retired, err)
+			}
+		}
+	}()
+
+	// --------------------------------------------------------------------------------
+	// Start Tracing Support
+
+	log.Infow("startup", "status", "initializing OT/Tempo tracing support", "exporter", cfg.Tracer.Exporter)
+
+	traceProvider, err := tracer.New(tracer.Config{
+		ServiceName: cfg.Tracer.ServiceName,
+		Build:       build,
+		Exporter:    cfg.Tracer.Exporter,
+		Endpoint:    cfg.Tracer.ReporterURI,
+		Insecure:    cfg.Tracer.Insecure,
+		FilePath:    cfg.Tracer.FilePath,
+		Probability: cfg.Tracer.Probability,
+	})
+	if err != nil {
+		return fmt.Errorf("starting tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := traceProvider.Shutdown(ctx); err != nil {
+			log.Errorw("shutdown", "status", "tracing shutdown", "ERROR", err)
+		}
+	}()
+
 	// --------------------------------------------------------------------------------
 	// App Starting
 	log.Infow("starting service", "version", build)
@@ -110,25 +292,141 @@ func run(log *zap.SugaredLogger) error {
 	log.Infow("startup", "config", out)
 
 	// --------------------------------------------------------------------------------
-	// Start Debug service
-	log.Infow("startup", "status", "debug v1 router started", "host", cfg.Web.DebugHost)
-	go func() {
-		if err := http.ListenAndServe(cfg.Web.DebugHost, debug.Mux(build, log)); err != nil {
-			log.Errorw("shutdown", "status", "debug v1 router closed", "host", cfg.Web.DebugHost, "ERROR", err)
+	// Start API service
+
+	// Simple in memory store versus using a database. The store starts out
+	// empty so an admin is added to create the other users with.
+	userCore := user.NewCore(usermem.NewStore())
+
+	if cfg.Users.AdminPassword == "" {
+		log.Infow("startup", "status", "no admin password configured, no user can get a token")
+	} else {
+		if err := seedAdmin(context.Background(), userCore, cfg.Users.AdminName, cfg.Users.AdminEmail, cfg.Users.AdminPassword); err != nil {
+			return fmt.Errorf("seeding admin: %w", err)
 		}
-	}()
+		log.Infow("startup", "status", "admin added", "email", cfg.Users.AdminEmail)
+	}
+
+	corsCfg := mid.CORSConfig{
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedMethods:   cfg.CORS.AllowedMethods,
+		AllowedHeaders:   cfg.CORS.AllowedHeaders,
+		ExposedHeaders:   cfg.CORS.ExposedHeaders,
+		AllowCredentials: cfg.CORS.AllowCredentials,
+		MaxAge:           cfg.CORS.MaxAge,
+	}
+	if err := corsCfg.Validate(); err != nil {
+		return fmt.Errorf("validating cors config: %w", err)
+	}
+
+	errorFormat, err := v1.ParseErrorFormat(cfg.Errors.Format)
+	if err != nil {
+		return fmt.Errorf("parsing error format: %w", err)
+	}
 
-	// --------------------------------------------------------------------------------
-	// Start API service
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
 
 	apiMux := handlers.APIMux(handlers.APIMuxConfig{
+		Build:    build,
 		Shutdown: shutdown,
 		Log:      log,
 		Auth:     auth,
+		Tracer:   traceProvider.Tracer("service"),
+
+		HandlerTimeout: cfg.Web.HandlerTimeout,
+
+		CORS: corsCfg,
+		Errors: mid.ErrorsConfig{
+			Format:          errorFormat,
+			ProblemTypeBase: cfg.Errors.ProblemTypeBase,
+		},
+
+		Keys:   ks,
+		Issuer: cfg.Auth.Issuer,
+
+		UserCore:    userCore,
+		TokenExpiry: cfg.Auth.TokenExpiry,
+
+		// Simple in memory store versus using Redis
+		Refresh:       refresh.NewMemory(),
+		RefreshExpiry: cfg.Auth.RefreshExpiry,
 	})
 
+	// Catch routes that were registered without the authentication they
+	// need before they are exposed.
+	if cfg.Web.CheckRoutes {
+		if err := mid.CheckRoutes(apiMux.Routes()); err != nil {
+			return fmt.Errorf("checking routes: %w", err)
+		}
+	}
+
+	// --------------------------------------------------------------------------------
+	// Initialize TLS support
+
+	debugCfg := debug.MuxConfig{
+		Build:  build,
+		Log:    log,
+		API:    apiMux,
+		Routes: apiMux,
+		Keys:   ks,
+	}
+
+	var certs *certstore.Store
+	if cfg.Web.TLSCertFile != "" || cfg.Web.TLSKeyFile != "" {
+		log.Infow("startup", "status", "initializing TLS support", "cert", cfg.Web.TLSCertFile)
+
+		certs, err = certstore.New(cfg.Web.TLSCertFile, cfg.Web.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("loading tls certificate: %w", err)
+		}
+		debugCfg.Cert = certs
+
+		info := certs.Info()
+		log.Infow("startup", "status", "tls certificate loaded", "subject", info.Subject, "notAfter", info.NotAfter)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		if cfg.Web.TLSReload > 0 {
+			go certs.Watch(ctx, cfg.Web.TLSReload, func(info certstore.Info, err error) {
+				if err != nil {
+					log.Errorw("tls", "status", "reloading certificate", "ERROR", err)
+					return
+				}
+				log.Infow("tls", "status", "certificate reloaded", "subject", info.Subject, "notAfter", info.NotAfter)
+			})
+		}
+	}
+
+	// --------------------------------------------------------------------------------
+	// Start Debug service
+
+	debugServer := http.Server{
+		Addr:     cfg.Web.DebugHost,
+		Handler:  debug.Mux(debugCfg),
+		ErrorLog: zap.NewStdLog(log.Desugar()),
+	}
+
+	go func() {
+		log.Infow("startup", "status", "debug v1 router started", "host", debugServer.Addr)
+		if err := debugServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorw("shutdown", "status", "debug v1 router closed", "host", debugServer.Addr, "ERROR", err)
+		}
+	}()
+
+	// The debug service is stopped last so the readiness check keeps on
+	// reporting the API is shutting down while it drains.
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
+		defer cancel()
+
+		if err := debugServer.Shutdown(ctx); err != nil {
+			debugServer.Close()
+			log.Errorw("shutdown", "status", "could not stop debug v1 router gracefully", "host", debugServer.Addr, "ERROR", err)
+		}
+	}()
+
 	api := http.Server{
 		Addr:         cfg.Web.APIHost,
 		Handler:      apiMux,
@@ -138,8 +436,19 @@ func run(log *zap.SugaredLogger) error {
 		ErrorLog:     zap.NewStdLog(log.Desugar()),
 	}
 
+	// The certificate and key come from the store so they can be rotated.
+	if certs != nil {
+		api.TLSConfig = certs.TLSConfig()
+	}
+
 	serverErrors := make(chan error, 1)
 	go func() {
+		if api.TLSConfig != nil {
+			log.Infow("startup", "status", "api router started", "host", api.Addr, "tls", true)
+			serverErrors <- api.ListenAndServeTLS("", "")
+			return
+		}
+
 		log.Infow("startup", "status", "api router started", "host", api.Addr)
 		serverErrors <- api.ListenAndServe()
 	}()
@@ -155,6 +464,13 @@ func run(log *zap.SugaredLogger) error {
 		log.Infow("shutdown", "status", "shutdown started", "signal", sig)
 		defer log.Infow("shutdown", "status", "shutdown complete", "signal", sig)
 
+		// The readiness check starts failing so the load balancer stops
+		// sending new traffic. The delay gives it time to notice before the
+		// listener is closed and the in-flight requests are drained.
+		apiMux.StartShutdown()
+		log.Infow("shutdown", "status", "draining", "delay", cfg.Web.ShutdownDelay, "inflight", apiMux.InFlight())
+		time.Sleep(cfg.Web.ShutdownDelay)
+
 		ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
 		defer cancel()
 
@@ -166,3 +482,25 @@ func run(log *zap.SugaredLogger) error {
 
 	return nil
 }
+
+// seedAdmin adds the admin users log in with to create the other users
+func seedAdmin(ctx context.Context, core *user.Core, name string, email string, password string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return fmt.Errorf("parsing email: %w", err)
+	}
+
+	nu := user.NewUser{
+		Name:            name,
+		Email:           *addr,
+		Roles:           []user.Role{user.RoleAdmin},
+		Password:        password,
+		PasswordConfirm: password,
+	}
+
+	if _, err := core.Create(ctx, nu); err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+
+	return nil
+}
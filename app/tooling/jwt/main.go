@@ -47,7 +47,7 @@ func genToken() error {
 	}{
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   "12345678",
-			Issuer:    "service project",
+			Issuer:    "http://localhost:3000",
 			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(8760 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		},
@@ -36,12 +36,12 @@ func main() {
 		}
 
 		// If a service filter was provided, check.
-		if service != "" && strings.ToLower(m["service"].(string)) != service {
+		if service != "" && strings.ToLower(fmt.Sprint(m["service"])) != service {
 			continue
 		}
 
 		// I like always having a traceid present in the logs.
-		traceID := "00000000-0000-0000-0000-000000000000"
+		traceID := "00000000000000000000000000000000"
 		if v, ok := m["trace_id"]; ok {
 			traceID = fmt.Sprintf("%v", v)
 		}
@@ -10,6 +10,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/theo-bot/service4.1-video/business/core/user"
 	"github.com/theo-bot/service4.1-video/business/data/order"
+	"github.com/theo-bot/service4.1-video/foundation/web"
 	"go.uber.org/zap"
 	"time"
 )
@@ -52,6 +53,9 @@ func NewCore(log *zap.SugaredLogger, usrCore *user.Core, storer Storer) *Core {
 // Create adds a Product to the database. It returns the created Product with
 // fields like ID and DateCreated populated.
 func (c *Core) Create(ctx context.Context, np NewProduct) (Product, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.product.create")
+	defer span.End()
+
 	now := time.Now()
 
 	prd := Product{
@@ -74,6 +78,9 @@ func (c *Core) Create(ctx context.Context, np NewProduct) (Product, error) {
 // Update modifies data about a Product. It will error if the specified ID is
 // invalid or does not reference an existing Product.
 func (c *Core) Update(ctx context.Context, prd Product, up UpdateProduct) (Product, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.product.update")
+	defer span.End()
+
 	if up.Name != nil {
 		prd.Name = *up.Name
 	}
@@ -94,6 +101,9 @@ func (c *Core) Update(ctx context.Context, prd Product, up UpdateProduct) (Produ
 
 // Delete removes the product identified by a given ID.
 func (c *Core) Delete(ctx context.Context, prd Product) error {
+	ctx, span := web.AddSpan(ctx, "business.core.product.delete")
+	defer span.End()
+
 	if err := c.storer.Delete(ctx, prd); err != nil {
 		return fmt.Errorf("delete: %w", err)
 	}
@@ -103,6 +113,9 @@ func (c *Core) Delete(ctx context.Context, prd Product) error {
 
 // Query gets all Products from the database.
 func (c *Core) Query(ctx context.Context, filter QueryFilter, orderBy order.By, pageNumber int, rowsPerPage int) ([]Product, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.product.query")
+	defer span.End()
+
 	prds, err := c.storer.Query(ctx, filter, orderBy, pageNumber, rowsPerPage)
 	if err != nil {
 		return nil, fmt.Errorf("query: %w", err)
@@ -113,11 +126,17 @@ func (c *Core) Query(ctx context.Context, filter QueryFilter, orderBy order.By,
 
 // Count returns the total number of products in the store.
 func (c *Core) Count(ctx context.Context, filter QueryFilter) (int, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.product.count")
+	defer span.End()
+
 	return c.storer.Count(ctx, filter)
 }
 
 // QueryByID finds the product identified by a given ID.
 func (c *Core) QueryByID(ctx context.Context, productID uuid.UUID) (Product, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.product.queryByID")
+	defer span.End()
+
 	prd, err := c.storer.QueryByID(ctx, productID)
 	if err != nil {
 		return Product{}, fmt.Errorf("query: productID[%s]: %w", productID, err)
@@ -128,6 +147,9 @@ func (c *Core) QueryByID(ctx context.Context, productID uuid.UUID) (Product, err
 
 // QueryByUserID finds the products identified by a given User ID.
 func (c *Core) QueryByUserID(ctx context.Context, userID uuid.UUID) ([]Product, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.product.queryByUserID")
+	defer span.End()
+
 	prds, err := c.storer.QueryByUserID(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("query: %w", err)
@@ -0,0 +1,190 @@
+// Package productmem contains product related CRUD functionality backed by
+// memory. It's meant for tests and local development where no database is
+// available.
+package productmem
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/theo-bot/service4.1-video/business/core/product"
+	"github.com/theo-bot/service4.1-video/business/data/order"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store manages the set of APIs for product data access.
+type Store struct {
+	mu       sync.RWMutex
+	products map[uuid.UUID]product.Product
+}
+
+// NewStore constructs an empty store for product data access.
+func NewStore() *Store {
+	return &Store{
+		products: make(map[uuid.UUID]product.Product),
+	}
+}
+
+// Create adds a Product to the store.
+func (s *Store) Create(ctx context.Context, prd product.Product) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.products[prd.ID] = prd
+
+	return nil
+}
+
+// Update modifies data about a Product in the store.
+func (s *Store) Update(ctx context.Context, prd product.Product) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.products[prd.ID]; !exists {
+		return fmt.Errorf("update: %w", product.ErrNotFound)
+	}
+
+	s.products[prd.ID] = prd
+
+	return nil
+}
+
+// Delete removes the product from the store.
+func (s *Store) Delete(ctx context.Context, prd product.Product) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.products, prd.ID)
+
+	return nil
+}
+
+// Query gets the products from the store.
+func (s *Store) Query(ctx context.Context, filter product.QueryFilter, orderBy order.By, pageNumber int, rowsPerPage int) ([]product.Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prds := s.filter(filter)
+
+	less, err := orderFunc(prds, orderBy)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(prds, less)
+
+	return page(prds, pageNumber, rowsPerPage), nil
+}
+
+// Count returns the total number of products in the store.
+func (s *Store) Count(ctx context.Context, filter product.QueryFilter) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.filter(filter)), nil
+}
+
+// QueryByID finds the product identified by a given ID.
+func (s *Store) QueryByID(ctx context.Context, productID uuid.UUID) (product.Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prd, exists := s.products[productID]
+	if !exists {
+		return product.Product{}, fmt.Errorf("query: productID[%s]: %w", productID, product.ErrNotFound)
+	}
+
+	return prd, nil
+}
+
+// QueryByUserID finds the products identified by a given User ID.
+func (s *Store) QueryByUserID(ctx context.Context, userID uuid.UUID) ([]product.Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var prds []product.Product
+	for _, prd := range s.products {
+		if prd.UserID == userID {
+			prds = append(prds, prd)
+		}
+	}
+
+	sort.Slice(prds, func(i, j int) bool { return prds[i].DateCreated.Before(prds[j].DateCreated) })
+
+	return prds, nil
+}
+
+// =============================================================================
+
+// filter returns the products matching the filter. The mutex must be held by
+// the caller.
+func (s *Store) filter(filter product.QueryFilter) []product.Product {
+	var prds []product.Product
+
+	for _, prd := range s.products {
+		switch {
+		case filter.ID != nil && prd.ID != *filter.ID:
+			continue
+		case filter.Name != nil && !strings.Contains(strings.ToLower(prd.Name), strings.ToLower(*filter.Name)):
+			continue
+		case filter.Cost != nil && prd.Cost != *filter.Cost:
+			continue
+		case filter.Quantity != nil && prd.Quantity != *filter.Quantity:
+			continue
+		}
+
+		prds = append(prds, prd)
+	}
+
+	return prds
+}
+
+// orderFunc returns the function sorting the products in the requested order.
+func orderFunc(prds []product.Product, orderBy order.By) (func(i, j int) bool, error) {
+	var less func(a, b product.Product) bool
+
+	switch orderBy.Field {
+	case product.OrderByProdID:
+		less = func(a, b product.Product) bool { return a.ID.String() < b.ID.String() }
+	case product.OrderByName:
+		less = func(a, b product.Product) bool { return a.Name < b.Name }
+	case product.OrderByCost:
+		less = func(a, b product.Product) bool { return a.Cost < b.Cost }
+	case product.OrderByQuantity:
+		less = func(a, b product.Product) bool { return a.Quantity < b.Quantity }
+	case product.OrderBySold:
+		less = func(a, b product.Product) bool { return a.Sold < b.Sold }
+	case product.OrderByRevenue:
+		less = func(a, b product.Product) bool { return a.Revenue < b.Revenue }
+	case product.OrderByUserID:
+		less = func(a, b product.Product) bool { return a.UserID.String() < b.UserID.String() }
+	default:
+		return nil, fmt.Errorf("field %q does not exist", orderBy.Field)
+	}
+
+	if orderBy.Direction == order.DESC {
+		return func(i, j int) bool { return less(prds[j], prds[i]) }, nil
+	}
+
+	return func(i, j int) bool { return less(prds[i], prds[j]) }, nil
+}
+
+// page returns the requested page of products. Pages start at 1.
+func page(prds []product.Product, pageNumber int, rowsPerPage int) []product.Product {
+	if pageNumber < 1 || rowsPerPage < 1 {
+		return nil
+	}
+
+	start := (pageNumber - 1) * rowsPerPage
+	if start >= len(prds) {
+		return nil
+	}
+
+	end := start + rowsPerPage
+	if end > len(prds) {
+		end = len(prds)
+	}
+
+	return prds[start:end]
+}
@@ -0,0 +1,236 @@
+// Package usermem contains user related CRUD functionality backed by memory.
+// It's meant for tests and local development where no database is available.
+package usermem
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/theo-bot/service4.1-video/business/core/user"
+	"github.com/theo-bot/service4.1-video/business/data/order"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store manages the set of APIs for user data access.
+type Store struct {
+	mu    sync.RWMutex
+	users map[uuid.UUID]user.User
+}
+
+// NewStore constructs an empty store for user data access.
+func NewStore() *Store {
+	return &Store{
+		users: make(map[uuid.UUID]user.User),
+	}
+}
+
+// Create inserts a new user into the store.
+func (s *Store) Create(ctx context.Context, usr user.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emailTaken(usr) {
+		return fmt.Errorf("create: %w", user.ErrUniqueEmail)
+	}
+
+	s.users[usr.ID] = copyUser(usr)
+
+	return nil
+}
+
+// Update replaces a user document in the store.
+func (s *Store) Update(ctx context.Context, usr user.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[usr.ID]; !exists {
+		return fmt.Errorf("update: %w", user.ErrNotFound)
+	}
+
+	if s.emailTaken(usr) {
+		return fmt.Errorf("update: %w", user.ErrUniqueEmail)
+	}
+
+	s.users[usr.ID] = copyUser(usr)
+
+	return nil
+}
+
+// Delete removes a user from the store.
+func (s *Store) Delete(ctx context.Context, usr user.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, usr.ID)
+
+	return nil
+}
+
+// Query retrieves a list of existing users from the store.
+func (s *Store) Query(ctx context.Context, filter user.QueryFilter, orderBy order.By, pageNumber int, rowsPerPage int) ([]user.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := s.filter(filter)
+
+	less, err := orderFunc(users, orderBy)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(users, less)
+
+	return page(users, pageNumber, rowsPerPage), nil
+}
+
+// Count returns the total number of users in the store.
+func (s *Store) Count(ctx context.Context, filter user.QueryFilter) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.filter(filter)), nil
+}
+
+// QueryByID gets the specified user from the store.
+func (s *Store) QueryByID(ctx context.Context, userID uuid.UUID) (user.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usr, exists := s.users[userID]
+	if !exists {
+		return user.User{}, fmt.Errorf("query: userID[%s]: %w", userID, user.ErrNotFound)
+	}
+
+	return copyUser(usr), nil
+}
+
+// QueryByIDs gets the specified users from the store.
+func (s *Store) QueryByIDs(ctx context.Context, userIDs []uuid.UUID) ([]user.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users []user.User
+	for _, id := range userIDs {
+		if usr, exists := s.users[id]; exists {
+			users = append(users, copyUser(usr))
+		}
+	}
+
+	return users, nil
+}
+
+// QueryByEmail gets the specified user from the store by email.
+func (s *Store) QueryByEmail(ctx context.Context, email mail.Address) (user.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, usr := range s.users {
+		if strings.EqualFold(usr.Email.Address, email.Address) {
+			return copyUser(usr), nil
+		}
+	}
+
+	return user.User{}, fmt.Errorf("query: email[%s]: %w", email.Address, user.ErrNotFound)
+}
+
+// =============================================================================
+
+// emailTaken checks if another user already uses the email address. The
+// mutex must be held by the caller.
+func (s *Store) emailTaken(usr user.User) bool {
+	for _, existing := range s.users {
+		if existing.ID != usr.ID && strings.EqualFold(existing.Email.Address, usr.Email.Address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filter returns copies of the users matching the filter. The mutex must be
+// held by the caller.
+func (s *Store) filter(filter user.QueryFilter) []user.User {
+	var users []user.User
+
+	for _, usr := range s.users {
+		switch {
+		case filter.ID != nil && usr.ID != *filter.ID:
+			continue
+		case filter.Name != nil && !strings.Contains(strings.ToLower(usr.Name), strings.ToLower(*filter.Name)):
+			continue
+		case filter.Email != nil && !strings.EqualFold(usr.Email.Address, filter.Email.Address):
+			continue
+		case filter.StartCreatedDate != nil && usr.DateCreated.Before(*filter.StartCreatedDate):
+			continue
+		case filter.EndCreatedDate != nil && usr.DateCreated.After(*filter.EndCreatedDate):
+			continue
+		}
+
+		users = append(users, copyUser(usr))
+	}
+
+	return users
+}
+
+// orderFunc returns the function sorting the users in the requested order.
+func orderFunc(users []user.User, orderBy order.By) (func(i, j int) bool, error) {
+	var less func(a, b user.User) bool
+
+	switch orderBy.Field {
+	case user.OrderByID:
+		less = func(a, b user.User) bool { return a.ID.String() < b.ID.String() }
+	case user.OrderByName:
+		less = func(a, b user.User) bool { return a.Name < b.Name }
+	case user.OrderByEmail:
+		less = func(a, b user.User) bool { return a.Email.Address < b.Email.Address }
+	case user.OrderByRoles:
+		less = func(a, b user.User) bool { return roleNames(a) < roleNames(b) }
+	case user.OrderByEnabled:
+		less = func(a, b user.User) bool { return !a.Enabled && b.Enabled }
+	default:
+		return nil, fmt.Errorf("field %q does not exist", orderBy.Field)
+	}
+
+	if orderBy.Direction == order.DESC {
+		return func(i, j int) bool { return less(users[j], users[i]) }, nil
+	}
+
+	return func(i, j int) bool { return less(users[i], users[j]) }, nil
+}
+
+func roleNames(usr user.User) string {
+	names := make([]string, len(usr.Roles))
+	for i, role := range usr.Roles {
+		names[i] = role.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// page returns the requested page of users. Pages start at 1.
+func page(users []user.User, pageNumber int, rowsPerPage int) []user.User {
+	if pageNumber < 1 || rowsPerPage < 1 {
+		return nil
+	}
+
+	start := (pageNumber - 1) * rowsPerPage
+	if start >= len(users) {
+		return nil
+	}
+
+	end := start + rowsPerPage
+	if end > len(users) {
+		end = len(users)
+	}
+
+	return users[start:end]
+}
+
+// copyUser makes sure callers can't modify the stored user through the
+// slices it holds.
+func copyUser(usr user.User) user.User {
+	usr.Roles = append([]user.Role(nil), usr.Roles...)
+	usr.PasswordHash = append([]byte(nil), usr.PasswordHash...)
+	return usr
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/theo-bot/service4.1-video/business/data/order"
+	"github.com/theo-bot/service4.1-video/foundation/web"
 	"golang.org/x/crypto/bcrypt"
 	"net/mail"
 	"time"
@@ -48,6 +49,9 @@ func NewCore(storer Storer) *Core {
 
 // Create inserts a new user in to the store
 func (c *Core) Create(ctx context.Context, nu NewUser) (User, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.user.create")
+	defer span.End()
+
 	hash, err := bcrypt.GenerateFromPassword([]byte(nu.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return User{}, fmt.Errorf("generatefrompassword: %w", err)
@@ -76,6 +80,9 @@ func (c *Core) Create(ctx context.Context, nu NewUser) (User, error) {
 
 // Update replaces a user document in the database
 func (c *Core) Update(ctx context.Context, usr User, uu UpdateUser) (User, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.user.update")
+	defer span.End()
+
 	if uu.Name != nil {
 		usr.Name = *uu.Name
 	}
@@ -109,6 +116,9 @@ func (c *Core) Update(ctx context.Context, usr User, uu UpdateUser) (User, error
 
 // Delete removes a user from the store
 func (c *Core) Delete(ctx context.Context, usr User) error {
+	ctx, span := web.AddSpan(ctx, "business.core.user.delete")
+	defer span.End()
+
 	if err := c.storer.Delete(ctx, usr); err != nil {
 		return fmt.Errorf("delete: %w", err)
 	}
@@ -118,6 +128,9 @@ func (c *Core) Delete(ctx context.Context, usr User) error {
 
 // Query retrieves a list of existing users.
 func (c *Core) Query(ctx context.Context, filter QueryFilter, orderBy order.By, pageNumber int, rowsPerPage int) ([]User, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.user.query")
+	defer span.End()
+
 	if err := filter.Validate(); err != nil {
 		return nil, err
 	}
@@ -132,6 +145,9 @@ func (c *Core) Query(ctx context.Context, filter QueryFilter, orderBy order.By,
 
 // Count returns the total number of users.
 func (c *Core) Count(ctx context.Context, filter QueryFilter) (int, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.user.count")
+	defer span.End()
+
 	if err := filter.Validate(); err != nil {
 		return 0, err
 	}
@@ -141,6 +157,9 @@ func (c *Core) Count(ctx context.Context, filter QueryFilter) (int, error) {
 
 // QueryByID finds the user by the specified ID.
 func (c *Core) QueryByID(ctx context.Context, userID uuid.UUID) (User, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.user.queryByID")
+	defer span.End()
+
 	user, err := c.storer.QueryByID(ctx, userID)
 	if err != nil {
 		return User{}, fmt.Errorf("query: userID[%s]: %w", userID, err)
@@ -151,6 +170,9 @@ func (c *Core) QueryByID(ctx context.Context, userID uuid.UUID) (User, error) {
 
 // QueryByIDs finds the users by a specified User IDs.
 func (c *Core) QueryByIDs(ctx context.Context, userIDs []uuid.UUID) ([]User, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.user.queryByIDs")
+	defer span.End()
+
 	user, err := c.storer.QueryByIDs(ctx, userIDs)
 	if err != nil {
 		return nil, fmt.Errorf("query: userIDs[%s]: %w", userIDs, err)
@@ -161,6 +183,9 @@ func (c *Core) QueryByIDs(ctx context.Context, userIDs []uuid.UUID) ([]User, err
 
 // QueryByEmail finds the user by a specified user email.
 func (c *Core) QueryByEmail(ctx context.Context, email mail.Address) (User, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.user.queryByEmail")
+	defer span.End()
+
 	user, err := c.storer.QueryByEmail(ctx, email)
 	if err != nil {
 		return User{}, fmt.Errorf("query: email[%s]: %w", email, err)
@@ -173,6 +198,9 @@ func (c *Core) QueryByEmail(ctx context.Context, email mail.Address) (User, erro
 // success it returns a Claims User representing this user. The claims can be
 // used to generate a token for future authentication.
 func (c *Core) Authenticate(ctx context.Context, email mail.Address, password string) (User, error) {
+	ctx, span := web.AddSpan(ctx, "business.core.user.authenticate")
+	defer span.End()
+
 	usr, err := c.QueryByEmail(ctx, email)
 	if err != nil {
 		return User{}, fmt.Errorf("query: email[%s]: %w", email, err)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/theo-bot/service4.1-video/business/data/order"
+	"github.com/theo-bot/service4.1-video/foundation/web"
 )
 
 // Storer interface declares the behavior this package needs to perists and
@@ -29,6 +30,9 @@ func NewCore(storer Storer) *Core {
 
 // Query retrieves a list of existing users from the database.
 func (c *Core) Query(ctx context.Context, filter QueryFilter, orderBy order.By, pageNumber int, rowsPerPage int) ([]Summary, error) {
+	ctx, span := web.AddSpan(ctx, "business.cview.user.summary.query")
+	defer span.End()
+
 	users, err := c.storer.Query(ctx, filter, orderBy, pageNumber, rowsPerPage)
 	if err != nil {
 		return nil, fmt.Errorf("query: %w", err)
@@ -39,5 +43,8 @@ func (c *Core) Query(ctx context.Context, filter QueryFilter, orderBy order.By,
 
 // Count returns the total number of users in the store.
 func (c *Core) Count(ctx context.Context, filter QueryFilter) (int, error) {
+	ctx, span := web.AddSpan(ctx, "business.cview.user.summary.count")
+	defer span.End()
+
 	return c.storer.Count(ctx, filter)
 }
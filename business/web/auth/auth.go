@@ -8,6 +8,9 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/theo-bot/service4.1-video/business/core/user"
+	"github.com/theo-bot/service4.1-video/foundation/keystore"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"strings"
 	"sync"
@@ -30,11 +33,21 @@ type KeyLookup interface {
 	PublicKey(kid string) (key string, err error)
 }
 
+// TrustedIssuer represents an issuer other than this service whose tokens
+// are accepted, like a corporate identity provider. Its keys are only used
+// to verify the tokens carrying its iss claim and only its public keys are
+// looked up
+type TrustedIssuer struct {
+	Issuer    string
+	KeyLookup KeyLookup
+}
+
 // Config represents information required to initialize auth
 type Config struct {
 	Log       *zap.SugaredLogger
 	KeyLookup KeyLookup
 	Issuer    string
+	Trusted   []TrustedIssuer
 }
 
 // Authe is used to initialize clients. It can generate a token for a
@@ -42,42 +55,70 @@ type Config struct {
 type Auth struct {
 	log        *zap.SugaredLogger
 	keyLoookup KeyLookup
-	method     jwt.SigningMethod
 	parser     *jwt.Parser
 	issuer     string
+	trusted    map[string]KeyLookup
 	mu         sync.RWMutex
-	cache      map[string]string
+	cache      map[cacheKey]string
+
+	// generation changes every time keys are invalidated so a key fetched
+	// before an invalidation isn't cached after it
+	generation uint64
+}
+
+// cacheKey identifies a public key by the issuer it belongs to and its kid
+// since the kids of different issuers can collide
+type cacheKey struct {
+	issuer string
+	kid    string
 }
 
 // New creates an Auth to support authentication/authorization
 func New(cfg Config) (*Auth, error) {
+	trusted := make(map[string]KeyLookup)
+	for _, ti := range cfg.Trusted {
+		switch {
+		case ti.Issuer == "" || ti.Issuer == cfg.Issuer:
+			return nil, fmt.Errorf("trusted issuer %q must differ from the issuer of the service", ti.Issuer)
+		case ti.KeyLookup == nil:
+			return nil, fmt.Errorf("trusted issuer %q has no key lookup", ti.Issuer)
+		}
+		trusted[ti.Issuer] = ti.KeyLookup
+	}
+
 	a := Auth{
 		log:        cfg.Log,
 		keyLoookup: cfg.KeyLookup,
-		method:     jwt.GetSigningMethod(jwt.SigningMethodRS256.Name),
-		parser:     jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Name})),
+		parser:     jwt.NewParser(jwt.WithValidMethods(keystore.Algorithms)),
 		issuer:     cfg.Issuer,
-		cache:      make(map[string]string),
+		trusted:    trusted,
+		cache:      make(map[cacheKey]string),
 	}
 
 	return &a, nil
 }
 
-// GenerateToken generates a signed JWT token string representing the user claims
+// GenerateToken generates a signed JWT token string representing the user
+// claims. The signing method is derived from the type of the key
 func (a *Auth) GenerateToken(kid string, claims Claims) (string, error) {
-	token := jwt.NewWithClaims(a.method, claims)
-	token.Header["kid"] = kid
-
 	privateKeyPEM, err := a.keyLoookup.PrivateKey(kid)
 	if err != nil {
 		return "", fmt.Errorf("private key: %w", err)
 	}
 
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(privateKeyPEM))
+	privateKey, err := keystore.ParsePrivateKeyPEM([]byte(privateKeyPEM))
 	if err != nil {
 		return "", fmt.Errorf("parsing private pem: %w", err)
 	}
 
+	alg, err := keystore.Algorithm(privateKey.Public())
+	if err != nil {
+		return "", fmt.Errorf("signing algorithm: %w", err)
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(alg), claims)
+	token.Header["kid"] = kid
+
 	str, err := token.SignedString(privateKey)
 	if err != nil {
 		return "", fmt.Errorf("signing token: %w", err)
@@ -88,6 +129,9 @@ func (a *Auth) GenerateToken(kid string, claims Claims) (string, error) {
 
 // Authenticate processes the token to validate the sender's token
 func (a *Auth) Authenticate(ctx context.Context, bearerToken string) (Claims, error) {
+	ctx, span := web.AddSpan(ctx, "business.web.auth.authenticate")
+	defer span.End()
+
 	parts := strings.Split(bearerToken, " ")
 	if len(parts) != 2 || parts[0] != "Bearer" {
 		return Claims{}, errors.New("expected authorization header format: Bearer <token>")
@@ -111,15 +155,49 @@ func (a *Auth) Authenticate(ctx context.Context, bearerToken string) (Claims, er
 		return Claims{}, fmt.Errorf("kid malformed: %w", err)
 	}
 
-	pem, err := a.publicKeyLookup(kid)
+	// Tokens of a trusted issuer are verified with its keys and its issuer,
+	// any other token with the keys and the issuer of this service.
+	issuer := a.issuer
+	if _, exists := a.trusted[claims.Issuer]; exists {
+		issuer = claims.Issuer
+	}
+
+	pem, err := a.publicKeyLookup(issuer, kid)
 	if err != nil {
 		return Claims{}, fmt.Errorf("failed to fetch public key: %w", err)
 	}
 
+	// The algorithm in the header is chosen by the sender so it must match
+	// the one of the key the token claims to be signed with
+	publicKey, err := keystore.ParsePublicKeyPEM([]byte(pem))
+	if err != nil {
+		return Claims{}, fmt.Errorf("parsing public key: %w", err)
+	}
+
+	alg, err := keystore.Algorithm(publicKey)
+	if err != nil {
+		return Claims{}, fmt.Errorf("signing algorithm: %w", err)
+	}
+
+	if token.Method.Alg() != alg {
+		return Claims{}, fmt.Errorf("token signed with %s, kid %s requires %s", token.Method.Alg(), kid, alg)
+	}
+
 	input := map[string]any{
 		"Key":   pem,
 		"Token": parts[1],
-		"ISS":   a.issuer,
+		"ISS":   issuer,
+		"Alg":   alg,
+	}
+
+	// OPA can't verify EdDSA signatures so the signature is verified here
+	// and OPA verifies the claims
+	if alg == keystore.AlgorithmEdDSA {
+		keyFunc := func(*jwt.Token) (any, error) { return publicKey, nil }
+		if _, err := a.parser.ParseWithClaims(parts[1], &Claims{}, keyFunc); err != nil {
+			return Claims{}, fmt.Errorf("verifying signature: %w", err)
+		}
+		input["SignatureVerified"] = true
 	}
 
 	if err := a.opaPolicyEvaluation(ctx, opaAuthentication, RuleAuthenticate, input); err != nil {
@@ -133,6 +211,9 @@ func (a *Auth) Authenticate(ctx context.Context, bearerToken string) (Claims, er
 // none of the input roles are within the user's claims, we return an error
 // otherwise the user is authorized
 func (a *Auth) Authorize(ctx context.Context, claims Claims, rule string) error {
+	ctx, span := web.AddSpan(ctx, "business.web.auth.authorize", attribute.String("rule", rule))
+	defer span.End()
+
 	input := map[string]any{
 		"Roles":   claims.Roles,
 		"Subject": claims.Subject,
@@ -146,15 +227,45 @@ func (a *Auth) Authorize(ctx context.Context, claims Claims, rule string) error
 	return nil
 }
 
+// Issuer returns the issuer the tokens are expected to be issued by
+func (a *Auth) Issuer() string {
+	return a.issuer
+}
+
+// InvalidateKeys removes the public keys of the kids from the cache so
+// tokens signed with keys that were retired are no longer accepted. The keys
+// are removed whatever issuer they belong to
+func (a *Auth) InvalidateKeys(kids ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	retired := make(map[string]bool, len(kids))
+	for _, kid := range kids {
+		retired[kid] = true
+	}
+
+	for key := range a.cache {
+		if retired[key.kid] {
+			delete(a.cache, key)
+		}
+	}
+	a.generation++
+}
+
 // ==============================================================================
 
 // publicKeyLookup performs a lookup for the public pem for the specificx kid
-func (a *Auth) publicKeyLookup(kid string) (string, error) {
+// with the key lookup of the issuer
+func (a *Auth) publicKeyLookup(issuer string, kid string) (string, error) {
+	key := cacheKey{issuer: issuer, kid: kid}
+
+	var generation uint64
 	pem, err := func() (string, error) {
 		a.mu.RLock()
 		defer a.mu.RUnlock()
 
-		pem, exists := a.cache[kid]
+		generation = a.generation
+		pem, exists := a.cache[key]
 		if !exists {
 			return "", errors.New("not found")
 		}
@@ -164,20 +275,30 @@ func (a *Auth) publicKeyLookup(kid string) (string, error) {
 		return pem, nil
 	}
 
-	pem, err = a.keyLoookup.PublicKey(kid)
+	lookup, exists := a.trusted[issuer]
+	if !exists {
+		lookup = a.keyLoookup
+	}
+
+	pem, err = lookup.PublicKey(kid)
 	if err != nil {
 		return "", fmt.Errorf("fetching public key: %w", err)
 	}
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	a.cache[kid] = pem
+	if a.generation == generation {
+		a.cache[key] = pem
+	}
 	return pem, nil
 }
 
 // opaPolicyEvaluation asks opa to evaluate the token against the specified token
 // policy and public key
 func (a *Auth) opaPolicyEvaluation(ctx context.Context, opaPolicy string, rule string, input any) error {
+	ctx, span := web.AddSpan(ctx, "business.web.auth.opaPolicyEvaluation", attribute.String("rule", rule))
+	defer span.End()
+
 	query := fmt.Sprintf("x = data.%s.%s", opaPackage, rule)
 
 	q, err := rego.New(
@@ -0,0 +1,405 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/theo-bot/service4.1-video/business/core/user"
+	"github.com/theo-bot/service4.1-video/foundation/keystore"
+	"github.com/theo-bot/service4.1-video/foundation/keystore/jwks"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testIssuer is the issuer of the tokens generated by the tests
+const testIssuer = "http://sales-api.test"
+
+// newKey generates a private key for the signing algorithm
+func newKey(t *testing.T, alg string) keystore.PrivateKey {
+	t.Helper()
+
+	var pk crypto.Signer
+	var err error
+
+	switch alg {
+	case keystore.AlgorithmRS256:
+		pk, err = rsa.GenerateKey(rand.Reader, 2048)
+	case keystore.AlgorithmES256:
+		pk, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case keystore.AlgorithmEdDSA:
+		_, pk, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unknown algorithm %s", alg)
+	}
+	if err != nil {
+		t.Fatalf("generating %s key: %s", alg, err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(pk)
+	if err != nil {
+		t.Fatalf("marshaling %s key: %s", alg, err)
+	}
+
+	key := keystore.PrivateKey{
+		PK:  pk,
+		PEM: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+	}
+
+	return key
+}
+
+// sign signs the claims with the key and the method, whatever the key of
+// the kid in the header, so tokens a client forged can be built
+func sign(t *testing.T, method jwt.SigningMethod, kid string, claims Claims, key any) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	str, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("signing token: %s", err)
+	}
+
+	return str
+}
+
+// newClaims constructs the claims of a token issued by the test issuer for
+// an admin that expires after the duration
+func newClaims(expiry time.Duration) Claims {
+	now := time.Now()
+
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "5cf37266-3473-4006-984f-9325122678b7",
+			Issuer:    testIssuer,
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
+			IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)),
+		},
+		Roles: []user.Role{user.RoleAdmin},
+	}
+
+	return claims
+}
+
+// =============================================================================
+
+func TestAuthenticate(t *testing.T) {
+	algs := []string{keystore.AlgorithmRS256, keystore.AlgorithmES256, keystore.AlgorithmEdDSA}
+
+	keys := make(map[string]keystore.PrivateKey)
+	others := make(map[string]keystore.PrivateKey)
+	for _, alg := range algs {
+		keys[alg] = newKey(t, alg)
+		others[alg] = newKey(t, alg)
+	}
+
+	a, err := New(Config{
+		Log:       zap.NewNop().Sugar(),
+		KeyLookup: keystore.NewMap(keys),
+		Issuer:    testIssuer,
+	})
+	if err != nil {
+		t.Fatalf("constructing auth: %s", err)
+	}
+
+	// otherAlg returns a supported algorithm other than the one of the kid.
+	otherAlg := func(kid string) string {
+		if kid == keystore.AlgorithmEdDSA {
+			return keystore.AlgorithmES256
+		}
+		return keystore.AlgorithmEdDSA
+	}
+
+	tests := []struct {
+		name    string
+		token   func(t *testing.T, kid string) string
+		success bool
+
+		// reason is part of the error for tokens that must be rejected
+		// before their signature is verified.
+		reason string
+	}{
+		{
+			name: "generated",
+			token: func(t *testing.T, kid string) string {
+				token, err := a.GenerateToken(kid, newClaims(time.Hour))
+				if err != nil {
+					t.Fatalf("generating token: %s", err)
+				}
+				return token
+			},
+			success: true,
+		},
+		{
+			name: "expired",
+			token: func(t *testing.T, kid string) string {
+				token, err := a.GenerateToken(kid, newClaims(-time.Minute))
+				if err != nil {
+					t.Fatalf("generating token: %s", err)
+				}
+				return token
+			},
+		},
+		{
+			name: "other issuer",
+			token: func(t *testing.T, kid string) string {
+				claims := newClaims(time.Hour)
+				claims.Issuer = "http://evil.test"
+				token, err := a.GenerateToken(kid, claims)
+				if err != nil {
+					t.Fatalf("generating token: %s", err)
+				}
+				return token
+			},
+		},
+		{
+			name: "signed by another key",
+			token: func(t *testing.T, kid string) string {
+				return sign(t, jwt.GetSigningMethod(kid), kid, newClaims(time.Hour), others[kid].PK)
+			},
+		},
+		{
+			name: "alg does not match the key of the kid",
+			token: func(t *testing.T, kid string) string {
+				alg := otherAlg(kid)
+				return sign(t, jwt.GetSigningMethod(alg), kid, newClaims(time.Hour), keys[alg].PK)
+			},
+			reason: "requires",
+		},
+		{
+			name: "hmac with the public key as secret",
+			token: func(t *testing.T, kid string) string {
+				pub, err := keystore.NewMap(keys).PublicKey(kid)
+				if err != nil {
+					t.Fatalf("public key: %s", err)
+				}
+				return sign(t, jwt.SigningMethodHS256, kid, newClaims(time.Hour), []byte(pub))
+			},
+			reason: "requires",
+		},
+		{
+			name: "none",
+			token: func(t *testing.T, kid string) string {
+				return sign(t, jwt.SigningMethodNone, kid, newClaims(time.Hour), jwt.UnsafeAllowNoneSignatureType)
+			},
+			reason: "requires",
+		},
+		{
+			name: "unknown kid",
+			token: func(t *testing.T, kid string) string {
+				return sign(t, jwt.GetSigningMethod(kid), "unknown", newClaims(time.Hour), keys[kid].PK)
+			},
+		},
+	}
+
+	for _, alg := range algs {
+		for _, tt := range tests {
+			t.Run(alg+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+
+				claims, err := a.Authenticate(ctx, "Bearer "+tt.token(t, alg))
+				if (err == nil) != tt.success {
+					t.Fatalf("error = %v, want success %t", err, tt.success)
+				}
+				if !tt.success {
+					if tt.reason != "" && !strings.Contains(err.Error(), tt.reason) {
+						t.Errorf("error = %v, want it to contain %q", err, tt.reason)
+					}
+					return
+				}
+
+				if claims.Subject != "5cf37266-3473-4006-984f-9325122678b7" {
+					t.Errorf("subject = %q", claims.Subject)
+				}
+
+				// The claims of the token are used by the authorization
+				// policy.
+				if err := a.Authorize(ctx, claims, RuleAdminOnly); err != nil {
+					t.Errorf("authorizing admin: %s", err)
+				}
+				if err := a.Authorize(ctx, claims, RuleUserOnly); err == nil {
+					t.Error("admin authorized as user")
+				}
+			})
+		}
+	}
+}
+
+func TestAuthenticateTrusted(t *testing.T) {
+	const idpIssuer = "https://idp.test"
+
+	own := newKey(t, keystore.AlgorithmES256)
+	idp := newKey(t, keystore.AlgorithmES256)
+
+	// The identity provider publishes its key set like any issuer does.
+	jwk, err := keystore.NewJWK("idp-key", idp.PK.Public())
+	if err != nil {
+		t.Fatalf("constructing jwk: %s", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(keystore.JWKS{Keys: []keystore.JWK{jwk}})
+	}))
+	defer srv.Close()
+
+	lookup, err := jwks.New(jwks.Config{URL: srv.URL, Client: srv.Client()})
+	if err != nil {
+		t.Fatalf("constructing key set lookup: %s", err)
+	}
+
+	a, err := New(Config{
+		Log:       zap.NewNop().Sugar(),
+		KeyLookup: keystore.NewMap(map[string]keystore.PrivateKey{"own-key": own}),
+		Issuer:    testIssuer,
+		Trusted:   []TrustedIssuer{{Issuer: idpIssuer, KeyLookup: lookup}},
+	})
+	if err != nil {
+		t.Fatalf("constructing auth: %s", err)
+	}
+
+	idpClaims := newClaims(time.Hour)
+	idpClaims.Issuer = idpIssuer
+
+	tests := []struct {
+		name    string
+		token   string
+		success bool
+	}{
+		{"issued by the service", sign(t, jwt.SigningMethodES256, "own-key", newClaims(time.Hour), own.PK), true},
+		{"issued by the identity provider", sign(t, jwt.SigningMethodES256, "idp-key", idpClaims, idp.PK), true},
+		{"identity provider issuer signed by the service", sign(t, jwt.SigningMethodES256, "own-key", idpClaims, own.PK), false},
+		{"service issuer signed by the identity provider", sign(t, jwt.SigningMethodES256, "idp-key", newClaims(time.Hour), idp.PK), false},
+		{"identity provider kid signed by another key", sign(t, jwt.SigningMethodES256, "idp-key", idpClaims, own.PK), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := a.Authenticate(context.Background(), "Bearer "+tt.token)
+			if (err == nil) != tt.success {
+				t.Fatalf("error = %v, want success %t", err, tt.success)
+			}
+			if tt.success && claims.Subject != "5cf37266-3473-4006-984f-9325122678b7" {
+				t.Errorf("subject = %q", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestNewTrusted(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted TrustedIssuer
+	}{
+		{"empty issuer", TrustedIssuer{KeyLookup: keystore.NewMap(nil)}},
+		{"issuer of the service", TrustedIssuer{Issuer: testIssuer, KeyLookup: keystore.NewMap(nil)}},
+		{"no key lookup", TrustedIssuer{Issuer: "https://idp.test"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				Issuer:  testIssuer,
+				Trusted: []TrustedIssuer{tt.trusted},
+			}
+			if _, err := New(cfg); err == nil {
+				t.Fatalf("constructing auth succeeded")
+			}
+		})
+	}
+}
+
+// blockingLookup returns the public key it holds once the test releases the
+// lookup so keys can be invalidated while a lookup is in flight
+type blockingLookup struct {
+	mu      sync.Mutex
+	pem     string
+	started chan struct{}
+	release chan struct{}
+}
+
+func (bl *blockingLookup) PrivateKey(kid string) (string, error) {
+	return "", nil
+}
+
+func (bl *blockingLookup) PublicKey(kid string) (string, error) {
+	bl.mu.Lock()
+	pem, started, release := bl.pem, bl.started, bl.release
+	bl.mu.Unlock()
+
+	if started != nil {
+		close(started)
+		<-release
+	}
+
+	return pem, nil
+}
+
+func TestInvalidateKeys(t *testing.T) {
+	bl := blockingLookup{
+		pem:     "old",
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+
+	a, err := New(Config{KeyLookup: &bl})
+	if err != nil {
+		t.Fatalf("constructing auth: %s", err)
+	}
+
+	// The key is retired while the old key is being looked up. The old key
+	// must not end up in the cache after the invalidation.
+	result := make(chan string)
+	go func() {
+		pem, err := a.publicKeyLookup("", "k1")
+		if err != nil {
+			t.Errorf("looking up key: %s", err)
+		}
+		result <- pem
+	}()
+
+	<-bl.started
+	a.InvalidateKeys("k1")
+	close(bl.release)
+
+	if pem := <-result; pem != "old" {
+		t.Errorf("in flight lookup = %q, want %q", pem, "old")
+	}
+
+	a.mu.RLock()
+	_, cached := a.cache[cacheKey{kid: "k1"}]
+	a.mu.RUnlock()
+	if cached {
+		t.Fatalf("key fetched before the invalidation was cached")
+	}
+
+	// The next lookup gets the new key and caches it.
+	bl.mu.Lock()
+	bl.pem = "new"
+	bl.started = nil
+	bl.mu.Unlock()
+
+	if pem, err := a.publicKeyLookup("", "k1"); err != nil || pem != "new" {
+		t.Fatalf("lookup after invalidation = %q, %v, want %q", pem, err, "new")
+	}
+
+	a.mu.RLock()
+	pem := a.cache[cacheKey{kid: "k1"}]
+	a.mu.RUnlock()
+	if pem != "new" {
+		t.Errorf("cached key = %q, want %q", pem, "new")
+	}
+}
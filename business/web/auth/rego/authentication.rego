@@ -5,12 +5,37 @@ import future.keywords.in
 
 default auth := false
 
+# RS256 and ES256 signatures are verified along with the claims.
 auth if {
+	input.Alg in {"RS256", "ES256"}
 	[valid, _, _] := verify_jwt
 	valid = true
 }
 
+# EdDSA signatures can't be verified by OPA so they are verified before the
+# policy is evaluated and only the claims are verified here.
+auth if {
+	input.Alg == "EdDSA"
+	input.SignatureVerified == true
+	[_, payload, _] := io.jwt.decode(input.Token)
+	valid_claims(payload)
+}
+
 verify_jwt := io.jwt.decode_verify(input.Token, {
 	"cert": input.Key,
 	"iss": input.ISS,
 })
+
+valid_claims(payload) if {
+	valid_issuer(payload)
+	not expired(payload)
+	not premature(payload)
+}
+
+valid_issuer(_) if input.ISS == ""
+
+valid_issuer(payload) if payload.iss == input.ISS
+
+expired(payload) if time.now_ns() >= payload.exp * 1000000000
+
+premature(payload) if time.now_ns() < payload.nbf * 1000000000
@@ -0,0 +1,45 @@
+// Package idempotency provides support for storing the responses of
+// mutating requests so a retried request is answered with the original
+// response instead of being processed again.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Set of error variables for the idempotency stores.
+var (
+	ErrInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+// Record represents the request stored under an idempotency key and, once
+// the request completes, the response it received.
+type Record struct {
+	Fingerprint string
+	Completed   bool
+	StatusCode  int
+	Header      http.Header
+	Body        []byte
+	DateCreated time.Time
+}
+
+// Storer interface declares the behaviour the idempotency middleware needs
+// to persist and retrieve records. Implementations must be safe for
+// concurrent use since retries are likely to arrive while the original
+// request is still running.
+type Storer interface {
+	// Reserve claims the key for a new request. When the key is already in
+	// use the record stored under it is returned with reserved set to
+	// false.
+	Reserve(ctx context.Context, key string, fingerprint string) (rec Record, reserved bool, err error)
+
+	// Complete stores the response for a key claimed by Reserve.
+	Complete(ctx context.Context, key string, rec Record) error
+
+	// Release removes a key claimed by Reserve so the request can be
+	// tried again.
+	Release(ctx context.Context, key string) error
+}
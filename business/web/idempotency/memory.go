@@ -0,0 +1,92 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is an in memory implementation of the Storer interface. Records
+// expire once they are older than the configured time to live.
+type Memory struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	records   map[string]Record
+	lastSweep time.Time
+}
+
+// NewMemory constructs a Memory store that keeps records for the specified
+// duration.
+func NewMemory(ttl time.Duration) *Memory {
+	return &Memory{
+		ttl:       ttl,
+		records:   make(map[string]Record),
+		lastSweep: time.Now(),
+	}
+}
+
+// Reserve claims the key for a new request unless an unexpired record
+// exists for it.
+func (m *Memory) Reserve(ctx context.Context, key string, fingerprint string) (Record, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.sweep(now)
+
+	if rec, exists := m.records[key]; exists && now.Sub(rec.DateCreated) < m.ttl {
+		return rec, false, nil
+	}
+
+	rec := Record{
+		Fingerprint: fingerprint,
+		DateCreated: now,
+	}
+	m.records[key] = rec
+
+	return rec, true, nil
+}
+
+// Complete stores the response for the key. The time to live still counts
+// from the moment the key was reserved.
+func (m *Memory) Complete(ctx context.Context, key string, rec Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, exists := m.records[key]; exists {
+		rec.DateCreated = existing.DateCreated
+	}
+	rec.Completed = true
+
+	m.records[key] = rec
+
+	return nil
+}
+
+// Release removes the key from the store.
+func (m *Memory) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, key)
+
+	return nil
+}
+
+// sweep removes the expired records. To keep the cost of a call low the
+// records are only checked once every half of the time to live. The mutex
+// must be held by the caller.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < m.ttl/2 {
+		return
+	}
+
+	for key, rec := range m.records {
+		if now.Sub(rec.DateCreated) >= m.ttl {
+			delete(m.records, key)
+		}
+	}
+
+	m.lastSweep = now
+}
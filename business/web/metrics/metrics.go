@@ -5,6 +5,7 @@ import (
 	"context"
 	"expvar"
 	"runtime"
+	"strconv"
 )
 
 // This holds the single instance of the metrics value needed for
@@ -22,6 +23,8 @@ type metrics struct {
 	requests   *expvar.Int
 	errors     *expvar.Int
 	panics     *expvar.Int
+	responses  *expvar.Map
+	deprecated *expvar.Map
 }
 
 // init constructs the metrics value that will be used to capture metrics.
@@ -34,6 +37,8 @@ func init() {
 		requests:   expvar.NewInt("requests"),
 		errors:     expvar.NewInt("errors"),
 		panics:     expvar.NewInt("panics"),
+		responses:  expvar.NewMap("responses"),
+		deprecated: expvar.NewMap("deprecated"),
 	}
 }
 
@@ -77,3 +82,17 @@ func AddPanics(ctx context.Context) {
 		v.panics.Add(1)
 	}
 }
+
+// AddResponse increments the metric for the status code of the response by 1
+func AddResponse(ctx context.Context, statusCode int) {
+	if v, ok := ctx.Value(key).(*metrics); ok {
+		v.responses.Add(strconv.Itoa(statusCode), 1)
+	}
+}
+
+// AddDeprecated increments the metric for the deprecated route by 1
+func AddDeprecated(ctx context.Context, route string) {
+	if v, ok := ctx.Value(key).(*metrics); ok {
+		v.deprecated.Add(route, 1)
+	}
+}
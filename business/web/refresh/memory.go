@@ -0,0 +1,103 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the expired records are removed from memory
+const sweepInterval = time.Minute
+
+// Memory is an in memory implementation of the Storer interface. Records
+// are kept until they expire so a used token is recognised when it's
+// presented again
+type Memory struct {
+	mu        sync.Mutex
+	records   map[string]Record
+	lastSweep time.Time
+}
+
+// NewMemory constructs an empty Memory store
+func NewMemory() *Memory {
+	return &Memory{
+		records:   make(map[string]Record),
+		lastSweep: time.Now(),
+	}
+}
+
+// Create stores a new refresh token
+func (m *Memory) Create(ctx context.Context, rec Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(time.Now())
+
+	m.records[rec.Hash] = rec
+
+	return nil
+}
+
+// QueryByHash returns the record of the refresh token with the hash
+func (m *Memory) QueryByHash(ctx context.Context, hash string) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, exists := m.records[hash]
+	if !exists {
+		return Record{}, fmt.Errorf("querybyhash: %w", ErrNotFound)
+	}
+
+	return rec, nil
+}
+
+// Use marks the refresh token as used and returns the record as it was
+// before
+func (m *Memory) Use(ctx context.Context, hash string) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, exists := m.records[hash]
+	if !exists {
+		return Record{}, fmt.Errorf("use: %w", ErrNotFound)
+	}
+
+	used := rec
+	used.Used = true
+	m.records[hash] = used
+
+	return rec, nil
+}
+
+// RevokeFamily revokes every refresh token of the family
+func (m *Memory) RevokeFamily(ctx context.Context, familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, rec := range m.records {
+		if rec.FamilyID == familyID {
+			rec.Revoked = true
+			m.records[hash] = rec
+		}
+	}
+
+	return nil
+}
+
+// sweep removes the expired records. To keep the cost of a call low the
+// records are only checked once every sweep interval. The mutex must be held
+// by the caller
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+
+	for hash, rec := range m.records {
+		if !now.Before(rec.DateExpires) {
+			delete(m.records, hash)
+		}
+	}
+
+	m.lastSweep = now
+}
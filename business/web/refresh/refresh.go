@@ -0,0 +1,202 @@
+// Package refresh provides support for the refresh tokens used to renew
+// short lived access tokens. Refresh tokens are opaque and only their hash
+// is stored. Every refresh token can be used once: using it issues the next
+// token of its family. A used token presented again means the token leaked
+// so the whole family is revoked. A family expires when the first token of
+// the family does, however often it's rotated
+package refresh
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"go.uber.org/zap"
+	"time"
+)
+
+// Set of error variables for the refresh tokens
+var (
+	ErrNotFound = errors.New("refresh token not found")
+	ErrExpired  = errors.New("refresh token expired")
+	ErrRevoked  = errors.New("refresh token revoked")
+	ErrReused   = errors.New("refresh token reused")
+)
+
+// Record represents a refresh token in the store. The token itself is never
+// stored, only its hash
+type Record struct {
+	Hash     string
+	FamilyID string
+	Subject  string
+
+	// KID is the key the tokens of the family are signed with. The key
+	// that's active when a token is issued is used when it's empty
+	KID string
+
+	Used        bool
+	Revoked     bool
+	DateCreated time.Time
+
+	// DateExpires is when the family expires. Every token of the family
+	// carries the date of the first one
+	DateExpires time.Time
+}
+
+// Storer interface declares the behaviour the refresh tokens need to be
+// persisted and retrieved. Implementations must be safe for concurrent use
+type Storer interface {
+	// Create stores a new refresh token
+	Create(ctx context.Context, rec Record) error
+
+	// QueryByHash returns the record of the refresh token with the hash
+	QueryByHash(ctx context.Context, hash string) (Record, error)
+
+	// Use marks the refresh token with the hash as used and returns the
+	// record as it was before. Two concurrent calls for the same hash must
+	// not both see an unused record
+	Use(ctx context.Context, hash string) (Record, error)
+
+	// RevokeFamily revokes every refresh token of the family
+	RevokeFamily(ctx context.Context, familyID string) error
+}
+
+// Tokens issues and rotates the refresh tokens kept in a store
+type Tokens struct {
+	log   *zap.SugaredLogger
+	store Storer
+	ttl   time.Duration
+}
+
+// New constructs Tokens backed by the store. A family of refresh tokens is
+// valid for the specified duration from its first token
+func New(log *zap.SugaredLogger, store Storer, ttl time.Duration) *Tokens {
+	return &Tokens{
+		log:   log,
+		store: store,
+		ttl:   ttl,
+	}
+}
+
+// Issue creates the first refresh token of a new family for the subject.
+// The kid is the key the access tokens of the family are signed with
+func (t *Tokens) Issue(ctx context.Context, subject string, kid string) (string, error) {
+	ctx, span := web.AddSpan(ctx, "business.web.refresh.issue")
+	defer span.End()
+
+	return t.create(ctx, uuid.NewString(), subject, kid, time.Now().UTC().Add(t.ttl))
+}
+
+// Rotate exchanges the refresh token for the next token of its family. The
+// function is called with the record of the token before the token is used,
+// to check the subject can still be granted a token and to prepare the
+// response. The token is only used once the function succeeded so a failure
+// leaves it valid. Presenting a token that was already used revokes its
+// family
+func (t *Tokens) Rotate(ctx context.Context, token string, fn func(rec Record) error) (string, error) {
+	ctx, span := web.AddSpan(ctx, "business.web.refresh.rotate")
+	defer span.End()
+
+	hash := Hash(token)
+
+	rec, err := t.store.QueryByHash(ctx, hash)
+	if err != nil {
+		return "", fmt.Errorf("querybyhash: %w", err)
+	}
+
+	if err := t.check(ctx, rec); err != nil {
+		return "", err
+	}
+
+	if err := fn(rec); err != nil {
+		return "", err
+	}
+
+	// The next token is stored before the token is used so a failure can't
+	// leave the client without a valid token. A concurrent rotation of the
+	// same token is caught by Use and revokes the family, next token
+	// included.
+	next, err := t.create(ctx, rec.FamilyID, rec.Subject, rec.KID, rec.DateExpires)
+	if err != nil {
+		return "", err
+	}
+
+	prev, err := t.store.Use(ctx, hash)
+	if err != nil {
+		return "", fmt.Errorf("use: %w", err)
+	}
+
+	if err := t.check(ctx, prev); err != nil {
+		return "", err
+	}
+
+	return next, nil
+}
+
+// RevokeFamily revokes every refresh token of the family
+func (t *Tokens) RevokeFamily(ctx context.Context, familyID string) error {
+	if err := t.store.RevokeFamily(ctx, familyID); err != nil {
+		return fmt.Errorf("revokefamily: familyID[%s]: %w", familyID, err)
+	}
+
+	return nil
+}
+
+// Hash returns the hash a refresh token is stored under
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// =============================================================================
+
+// check refuses a refresh token that can't be used. A token that was used
+// before revokes its family
+func (t *Tokens) check(ctx context.Context, rec Record) error {
+	switch {
+	case rec.Revoked:
+		return ErrRevoked
+
+	case rec.Used:
+		if err := t.store.RevokeFamily(ctx, rec.FamilyID); err != nil {
+			return fmt.Errorf("revokefamily: familyID[%s]: %w", rec.FamilyID, err)
+		}
+		t.log.Errorw("refresh", "status", "refresh token reused, family revoked", "trace_id", web.GetTraceID(ctx), "family_id", rec.FamilyID, "subject", rec.Subject)
+		return ErrReused
+
+	case !time.Now().Before(rec.DateExpires):
+		return ErrExpired
+	}
+
+	return nil
+}
+
+// create generates a refresh token for the family and stores its hash
+func (t *Tokens) create(ctx context.Context, familyID string, subject string, kid string, expires time.Time) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now().UTC()
+	rec := Record{
+		Hash:        Hash(token),
+		FamilyID:    familyID,
+		Subject:     subject,
+		KID:         kid,
+		DateCreated: now,
+		DateExpires: expires,
+	}
+
+	if err := t.store.Create(ctx, rec); err != nil {
+		return "", fmt.Errorf("create: %w", err)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,126 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func grant(rec Record) error {
+	return nil
+}
+
+func TestRotate(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	tokens := New(zap.NewNop().Sugar(), store, time.Hour)
+
+	first, err := tokens.Issue(ctx, "subject", "kid")
+	if err != nil {
+		t.Fatalf("issuing: %s", err)
+	}
+
+	var granted Record
+	second, err := tokens.Rotate(ctx, first, func(rec Record) error {
+		granted = rec
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("rotating: %s", err)
+	}
+	if granted.Subject != "subject" || granted.KID != "kid" || granted.Used {
+		t.Errorf("granted record = %+v, want the unused record of the subject", granted)
+	}
+
+	// The family expires with its first token however often it's rotated.
+	firstRec, err := store.QueryByHash(ctx, Hash(first))
+	if err != nil {
+		t.Fatalf("querying first: %s", err)
+	}
+	secondRec, err := store.QueryByHash(ctx, Hash(second))
+	if err != nil {
+		t.Fatalf("querying second: %s", err)
+	}
+	if !secondRec.DateExpires.Equal(firstRec.DateExpires) || secondRec.FamilyID != firstRec.FamilyID {
+		t.Errorf("second token expires %v in family %s, want %v in family %s", secondRec.DateExpires, secondRec.FamilyID, firstRec.DateExpires, firstRec.FamilyID)
+	}
+
+	third, err := tokens.Rotate(ctx, second, grant)
+	if err != nil {
+		t.Fatalf("rotating second: %s", err)
+	}
+
+	// Presenting a used token revokes the family so the latest token of
+	// the family can't be used either.
+	if _, err := tokens.Rotate(ctx, first, grant); !errors.Is(err, ErrReused) {
+		t.Fatalf("reusing first = %v, want ErrReused", err)
+	}
+	if _, err := tokens.Rotate(ctx, third, grant); !errors.Is(err, ErrRevoked) {
+		t.Errorf("rotating third after reuse = %v, want ErrRevoked", err)
+	}
+}
+
+func TestRotateGrantFailure(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	tokens := New(zap.NewNop().Sugar(), store, time.Hour)
+
+	token, err := tokens.Issue(ctx, "subject", "")
+	if err != nil {
+		t.Fatalf("issuing: %s", err)
+	}
+
+	failure := errors.New("database down")
+	if _, err := tokens.Rotate(ctx, token, func(rec Record) error { return failure }); !errors.Is(err, failure) {
+		t.Fatalf("rotating = %v, want the grant error", err)
+	}
+
+	// The token wasn't used so the client can try again.
+	if _, err := tokens.Rotate(ctx, token, grant); err != nil {
+		t.Fatalf("rotating after failure: %s", err)
+	}
+}
+
+func TestRotateRefused(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+	tokens := New(zap.NewNop().Sugar(), store, time.Hour)
+
+	expired, err := tokens.Issue(ctx, "subject", "")
+	if err != nil {
+		t.Fatalf("issuing: %s", err)
+	}
+
+	store.mu.Lock()
+	rec := store.records[Hash(expired)]
+	rec.DateExpires = time.Now().Add(-time.Second)
+	store.records[Hash(expired)] = rec
+	store.mu.Unlock()
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"unknown", "unknown", ErrNotFound},
+		{"expired", expired, ErrExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			_, err := tokens.Rotate(ctx, tt.token, func(rec Record) error {
+				called = true
+				return nil
+			})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("rotating = %v, want %v", err, tt.want)
+			}
+			if called {
+				t.Errorf("grant called for a refused token")
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Package apitest provides support for testing the API end to end in
+// process. It boots the API with in memory stores and an ephemeral signing
+// key, mints tokens and runs table driven requests against it
+package apitest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/theo-bot/service4.1-video/business/core/product"
+	"github.com/theo-bot/service4.1-video/business/core/product/stores/productmem"
+	"github.com/theo-bot/service4.1-video/business/core/user"
+	"github.com/theo-bot/service4.1-video/business/core/user/stores/usermem"
+	"github.com/theo-bot/service4.1-video/business/web/auth"
+	"github.com/theo-bot/service4.1-video/foundation/keystore"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Issuer is the issuer of the tokens minted by the test
+const Issuer = "http://sales-api.test"
+
+// Test holds the systems the API under test is booted with
+type Test struct {
+	Log         *zap.SugaredLogger
+	Auth        *auth.Auth
+	Keys        *keystore.KeyStore
+	KID         string
+	Shutdown    chan os.Signal
+	UserCore    *user.Core
+	ProductCore *product.Core
+	Handler     http.Handler
+
+	t testing.TB
+}
+
+// BootFunc constructs the handler under test from the systems of the test,
+// like a call to handlers.APIMux
+type BootFunc func(test *Test) http.Handler
+
+// New constructs a Test with in memory stores and a freshly generated
+// signing key and boots the handler under test with it
+func New(t testing.TB, boot BootFunc) *Test {
+	t.Helper()
+
+	log := newLogger(t)
+
+	kid, ks := keys(t)
+
+	a, err := auth.New(auth.Config{
+		Log:       log,
+		KeyLookup: ks,
+		Issuer:    Issuer,
+	})
+	if err != nil {
+		t.Fatalf("constructing auth: %s", err)
+	}
+
+	usrCore := user.NewCore(usermem.NewStore())
+
+	test := Test{
+		Log:         log,
+		Auth:        a,
+		Keys:        ks,
+		KID:         kid,
+		Shutdown:    make(chan os.Signal, 1),
+		UserCore:    usrCore,
+		ProductCore: product.NewCore(log, usrCore, productmem.NewStore()),
+		t:           t,
+	}
+
+	test.Handler = boot(&test)
+
+	return &test
+}
+
+// Token mints a token for the subject with the specified roles
+func (test *Test) Token(subject string, roles ...user.Role) string {
+	test.t.Helper()
+
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			Issuer:    Issuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		},
+		Roles: roles,
+	}
+
+	token, err := test.Auth.GenerateToken(test.KID, claims)
+	if err != nil {
+		test.t.Fatalf("generating token: %s", err)
+	}
+
+	return token
+}
+
+// TokenFor mints a token for the user with the user's roles
+func (test *Test) TokenFor(usr user.User) string {
+	test.t.Helper()
+
+	return test.Token(usr.ID.String(), usr.Roles...)
+}
+
+// CreateUser adds a user to the user store
+func (test *Test) CreateUser(nu user.NewUser) user.User {
+	test.t.Helper()
+
+	usr, err := test.UserCore.Create(context.Background(), nu)
+	if err != nil {
+		test.t.Fatalf("creating user: %s", err)
+	}
+
+	return usr
+}
+
+// CreateProduct adds a product to the product store
+func (test *Test) CreateProduct(np product.NewProduct) product.Product {
+	test.t.Helper()
+
+	prd, err := test.ProductCore.Create(context.Background(), np)
+	if err != nil {
+		test.t.Fatalf("creating product: %s", err)
+	}
+
+	return prd
+}
+
+// =============================================================================
+
+// The key is generated once per test binary since generating a RSA key is
+// slow. It never leaves the process
+var ephemeral struct {
+	once sync.Once
+	kid  string
+	key  keystore.PrivateKey
+	err  error
+}
+
+// keys returns a keystore holding the ephemeral signing key as the active key
+func keys(t testing.TB) (string, *keystore.KeyStore) {
+	t.Helper()
+
+	ephemeral.once.Do(func() {
+		pk, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			ephemeral.err = err
+			return
+		}
+
+		block := pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(pk),
+		}
+
+		ephemeral.kid = uuid.NewString()
+		ephemeral.key = keystore.PrivateKey{
+			PK:  pk,
+			PEM: pem.EncodeToMemory(&block),
+		}
+	})
+
+	if ephemeral.err != nil {
+		t.Fatalf("generating key: %s", ephemeral.err)
+	}
+
+	ks := keystore.NewMap(map[string]keystore.PrivateKey{
+		ephemeral.kid: ephemeral.key,
+	})
+	if err := ks.SetActiveKID(ephemeral.kid); err != nil {
+		t.Fatalf("setting active kid: %s", err)
+	}
+
+	return ephemeral.kid, ks
+}
+
+// newLogger constructs a logger writing to the test's log so the output is
+// only shown for failing tests. Logging stops once the test completes since
+// the testing package doesn't allow it
+func newLogger(t testing.TB) *zap.SugaredLogger {
+	w := testWriter{t: t}
+	t.Cleanup(func() { w.done.Store(true) })
+
+	encoder := zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	core := zapcore.NewCore(encoder, zapcore.AddSync(&w), zap.DebugLevel)
+
+	return zap.New(core).Sugar()
+}
+
+type testWriter struct {
+	t    testing.TB
+	done atomic.Bool
+}
+
+func (w *testWriter) Write(p []byte) (int, error) {
+	if !w.done.Load() {
+		w.t.Logf("%s", p)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,198 @@
+package apitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// UpdateEnv is the environment variable that makes Run write the golden
+// files instead of comparing against them, like APITEST_UPDATE=1 go test ./..
+const UpdateEnv = "APITEST_UPDATE"
+
+// ignored replaces the values of the ignored fields in the golden files
+const ignored = "<ignored>"
+
+// Case represents a request to send to the API and the response expected
+type Case struct {
+	Name   string
+	Method string
+	URL    string
+
+	// Token is sent as the bearer token when set.
+	Token  string
+	Header http.Header
+
+	// Body is sent as JSON unless it's a string or a []byte which are sent
+	// as is.
+	Body any
+
+	StatusCode int
+
+	// Golden is the name of the file in the testdata folder holding the
+	// expected JSON response body, without the .json extension. The fields
+	// listed in Ignore, like ids and dates, are left out of the comparison
+	// at any depth.
+	Golden string
+	Ignore []string
+
+	// Check performs any additional checks on the response.
+	Check func(t *testing.T, w *httptest.ResponseRecorder)
+}
+
+// Run sends the request of every case to the API under test as a subtest
+// and checks the responses
+func (test *Test) Run(t *testing.T, cases []Case) {
+	t.Helper()
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			w := test.Do(t, c.Method, c.URL, c.Token, c.Header, c.Body)
+
+			if c.StatusCode != 0 && w.Code != c.StatusCode {
+				t.Fatalf("status code: got %d, want %d: body: %s", w.Code, c.StatusCode, w.Body.String())
+			}
+
+			if c.Golden != "" {
+				Golden(t, c.Golden, w.Body.Bytes(), c.Ignore...)
+			}
+
+			if c.Check != nil {
+				c.Check(t, w)
+			}
+		})
+	}
+}
+
+// Do sends a single request to the API under test. The test fails when the
+// API signals it wants to shut down
+func (test *Test) Do(t testing.TB, method string, url string, token string, header http.Header, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		r = bytes.NewReader(b)
+	case string:
+		r = bytes.NewReader([]byte(b))
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("marshaling body: %s", err)
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, url, r)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	test.Handler.ServeHTTP(w, req)
+
+	select {
+	case sig := <-test.Shutdown:
+		t.Fatalf("api signaled shutdown with %v: body: %s", sig, w.Body.String())
+	default:
+	}
+
+	return w
+}
+
+// Golden compares the JSON document with the golden file of the specified
+// name in the testdata folder. Both documents are normalized so formatting
+// and the order of fields don't matter. When the UpdateEnv environment
+// variable is set the golden file is written instead
+func Golden(t testing.TB, name string, body []byte, ignore ...string) {
+	t.Helper()
+
+	got, err := normalize(body, ignore)
+	if err != nil {
+		t.Fatalf("normalizing response: %s: body: %s", err, body)
+	}
+
+	path := filepath.Join("testdata", name+".json")
+
+	if os.Getenv(UpdateEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("creating testdata folder: %s", err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("writing golden file: %s", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("golden file %s does not exist, run the test with %s=1 to create it", path, UpdateEnv)
+		}
+		t.Fatalf("reading golden file: %s", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("response does not match golden file %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// normalize decodes and encodes the document with indentation and replaces
+// the values of the ignored fields
+func normalize(body []byte, ignore []string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	skip := make(map[string]bool, len(ignore))
+	for _, field := range ignore {
+		skip[field] = true
+	}
+	doc = replaceIgnored(doc, skip)
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding: %w", err)
+	}
+
+	return append(data, '\n'), nil
+}
+
+func replaceIgnored(doc any, skip map[string]bool) any {
+	switch v := doc.(type) {
+	case map[string]any:
+		for k, value := range v {
+			if skip[k] {
+				v[k] = ignored
+				continue
+			}
+			v[k] = replaceIgnored(value, skip)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = replaceIgnored(value, skip)
+		}
+	}
+
+	return doc
+}
@@ -0,0 +1,60 @@
+// Package certgrp provides the debug endpoint reporting the TLS certificate
+// the API is serving
+package certgrp
+
+import (
+	"encoding/json"
+	"github.com/theo-bot/service4.1-video/foundation/certstore"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// Certificate declares the behavior needed to report on the certificate
+type Certificate interface {
+	Info() certstore.Info
+}
+
+// Handlers manages the set of certificate endpoints.
+type Handlers struct {
+	Log  *zap.SugaredLogger
+	Cert Certificate
+}
+
+// Certificate returns the details of the certificate being served and the
+// time left until it expires. A 404 is returned when the API isn't serving
+// TLS
+func (h Handlers) Certificate(w http.ResponseWriter, r *http.Request) {
+	if h.Cert == nil {
+		http.Error(w, "tls is not enabled", http.StatusNotFound)
+		return
+	}
+
+	info := h.Cert.Info()
+
+	data := struct {
+		certstore.Info
+		ExpiresIn        string  `json:"expiresIn"`
+		ExpiresInSeconds float64 `json:"expiresInSeconds"`
+		Expired          bool    `json:"expired"`
+	}{
+		Info:             info,
+		ExpiresIn:        time.Until(info.NotAfter).Round(time.Second).String(),
+		ExpiresInSeconds: time.Until(info.NotAfter).Seconds(),
+		Expired:          time.Now().After(info.NotAfter),
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		h.Log.Errorw("certificate", "ERROR", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(jsonData); err != nil {
+		h.Log.Errorw("certificate", "ERROR", err)
+	}
+}
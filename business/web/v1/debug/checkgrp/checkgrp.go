@@ -7,23 +7,41 @@ import (
 	"os"
 )
 
+// API declares the behavior the checks need from the API application to
+// report its shutdown state.
+type API interface {
+	IsShuttingDown() bool
+	InFlight() int64
+}
+
 // Handlers manages the set of check endpoints.
 type Handlers struct {
 	Build string
 	Log   *zap.SugaredLogger
+	API   API
 }
 
 // Readiness checks if the database is ready and if not will return a 500 status
 // Do not respond by just returning an error because further up in the call
-// stack it will interpret that as an non-trusted error
+// stack it will interpret that as an non-trusted error. Once the API starts
+// shutting down a 503 is returned so no new traffic is routed to the service
 func (h Handlers) Readiness(w http.ResponseWriter, r *http.Request) {
 	statusCode := http.StatusOK
 	data := struct {
-		Status string `json:"status"`
+		Status   string `json:"status"`
+		InFlight *int64 `json:"inFlight,omitempty"`
 	}{
 		Status: "OK",
 	}
 
+	if h.API != nil && h.API.IsShuttingDown() {
+		inFlight := h.API.InFlight()
+
+		statusCode = http.StatusServiceUnavailable
+		data.Status = "shutting down"
+		data.InFlight = &inFlight
+	}
+
 	if err := response(w, statusCode, data); err != nil {
 		h.Log.Errorw("readiness", "ERROR", err)
 	}
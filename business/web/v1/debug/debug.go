@@ -3,7 +3,10 @@ package debug
 
 import (
 	"expvar"
+	"github.com/theo-bot/service4.1-video/business/web/v1/debug/certgrp"
 	"github.com/theo-bot/service4.1-video/business/web/v1/debug/checkgrp"
+	"github.com/theo-bot/service4.1-video/business/web/v1/debug/keygrp"
+	"github.com/theo-bot/service4.1-video/business/web/v1/debug/routegrp"
 	"go.uber.org/zap"
 	"net/http"
 	"net/http/pprof"
@@ -26,19 +29,60 @@ func StandardLibraryMux() *http.ServeMux {
 	return mux
 }
 
+// MuxConfig contains the systems the debug application routes report on
+type MuxConfig struct {
+	Build string
+	Log   *zap.SugaredLogger
+	API   checkgrp.API
+
+	// Routes lists the routes of the API with the middleware they run
+	Routes routegrp.Lister
+
+	// Cert is the certificate store of the API. It's nil when the API isn't
+	// serving TLS
+	Cert certgrp.Certificate
+
+	// Keys is the keystore tokens are signed with. The key endpoints aren't
+	// registered when it's nil
+	Keys keygrp.KeyStore
+}
+
 // Mux registers all the debug standard library routes and then custom
 // debug application routes for the services. This bypassing the use of the
 // DefaultServerMux. Using the DefaultServerMux would be a security risk since
 // a depency could inject a handler into our service without us knowing it
-func Mux(build string, log *zap.SugaredLogger) http.Handler {
+func Mux(cfg MuxConfig) http.Handler {
 	mux := StandardLibraryMux()
 
 	cgh := checkgrp.Handlers{
-		Build: build,
-		Log:   log,
+		Build: cfg.Build,
+		Log:   cfg.Log,
+		API:   cfg.API,
 	}
 	mux.HandleFunc("/debug/readiness", cgh.Readiness)
 	mux.HandleFunc("/debug/liveness", cgh.Liveness)
 
+	crh := certgrp.Handlers{
+		Log:  cfg.Log,
+		Cert: cfg.Cert,
+	}
+	mux.HandleFunc("/debug/certificate", crh.Certificate)
+
+	rgh := routegrp.Handlers{
+		Log:    cfg.Log,
+		Routes: cfg.Routes,
+	}
+	mux.HandleFunc("/debug/routes", rgh.List)
+
+	if cfg.Keys != nil {
+		kgh := keygrp.Handlers{
+			Log:  cfg.Log,
+			Keys: cfg.Keys,
+		}
+		mux.HandleFunc("/debug/keys", kgh.List)
+		mux.HandleFunc("/debug/keys/active", kgh.SetActive)
+		mux.HandleFunc("/debug/keys/reload", kgh.Reload)
+	}
+
 	return mux
 }
@@ -0,0 +1,81 @@
+package debug_test
+
+import (
+	"github.com/theo-bot/service4.1-video/business/web/v1/apitest"
+	"github.com/theo-bot/service4.1-video/business/web/v1/debug"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+// api reports the shutdown state the test sets
+type api struct {
+	shuttingDown atomic.Bool
+	inFlight     atomic.Int64
+}
+
+func (a *api) IsShuttingDown() bool {
+	return a.shuttingDown.Load()
+}
+
+func (a *api) InFlight() int64 {
+	return a.inFlight.Load()
+}
+
+func TestChecks(t *testing.T) {
+	// Liveness reports the environment of the pod, clear it so the golden
+	// doesn't depend on where the test runs.
+	for _, env := range []string{"KUBERNETES_NAME", "KUBERNETES_POD_IP", "KUBERNETES_NODE_NAME", "KUBERNETES_NAMESPACE", "GOMAXPROCS"} {
+		t.Setenv(env, "")
+	}
+
+	var a api
+	test := apitest.New(t, func(test *apitest.Test) http.Handler {
+		return debug.Mux(debug.MuxConfig{
+			Build: "test",
+			Log:   test.Log,
+			API:   &a,
+		})
+	})
+
+	test.Run(t, []apitest.Case{
+		{
+			Name:       "ready",
+			Method:     http.MethodGet,
+			URL:        "/debug/readiness",
+			StatusCode: http.StatusOK,
+			Golden:     "readiness",
+		},
+		{
+			Name:       "alive",
+			Method:     http.MethodGet,
+			URL:        "/debug/liveness",
+			StatusCode: http.StatusOK,
+			Golden:     "liveness",
+			Ignore:     []string{"host"},
+		},
+	})
+
+	// Once the API shuts down it's no longer ready but still alive so the
+	// requests in flight can finish.
+	a.shuttingDown.Store(true)
+	a.inFlight.Store(3)
+
+	test.Run(t, []apitest.Case{
+		{
+			Name:       "shutting down",
+			Method:     http.MethodGet,
+			URL:        "/debug/readiness",
+			StatusCode: http.StatusServiceUnavailable,
+			Golden:     "readiness_shutting_down",
+		},
+		{
+			Name:       "alive while shutting down",
+			Method:     http.MethodGet,
+			URL:        "/debug/liveness",
+			StatusCode: http.StatusOK,
+			Golden:     "liveness",
+			Ignore:     []string{"host"},
+		},
+	})
+}
@@ -0,0 +1,133 @@
+// Package keygrp provides the debug endpoints used to rotate the keys tokens
+// are signed with
+package keygrp
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+)
+
+// KeyStore declares the behavior needed to rotate the keys
+type KeyStore interface {
+	KIDs() []string
+	ActiveKID() string
+	SetActiveKID(kid string) error
+	Reload() (added []string, retired []string, err error)
+}
+
+// Handlers manages the set of key endpoints.
+type Handlers struct {
+	Log  *zap.SugaredLogger
+	Keys KeyStore
+}
+
+// status represents the keys in the keystore
+type status struct {
+	Active string   `json:"active"`
+	KIDs   []string `json:"kids"`
+}
+
+// List returns the kids of the keys in the keystore and the kid of the key
+// new tokens are signed with
+func (h Handlers) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.respond(w, h.status(), http.StatusOK)
+}
+
+// SetActive changes the key new tokens are signed with. The body holds the
+// kid of the key, like {"kid": "54bb2165-71e1-41a6-af3e-7da4a0e1e2c1"}
+func (h Handlers) SetActive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.Header().Set("Allow", http.MethodPut)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		KID string `json:"kid"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1024)).Decode(&body); err != nil || body.KID == "" {
+		http.Error(w, "expected body: {\"kid\": \"<kid>\"}", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Keys.SetActiveKID(body.KID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	h.Log.Infow("keys", "status", "active kid changed", "kid", body.KID)
+
+	h.respond(w, h.status(), http.StatusOK)
+}
+
+// Reload reads the keys folder again so keys added to it can be activated
+// and keys removed from it are no longer accepted
+func (h Handlers) Reload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	added, retired, err := h.Keys.Reload()
+	if err != nil {
+		h.Log.Errorw("keys", "status", "reloading keys", "ERROR", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.Log.Infow("keys", "status", "keys reloaded", "added", added, "retired", retired)
+
+	data := struct {
+		status
+		Added   []string `json:"added"`
+		Retired []string `json:"retired"`
+	}{
+		status:  h.status(),
+		Added:   nonNil(added),
+		Retired: nonNil(retired),
+	}
+
+	h.respond(w, data, http.StatusOK)
+}
+
+// =============================================================================
+
+func (h Handlers) status() status {
+	return status{
+		Active: h.Keys.ActiveKID(),
+		KIDs:   h.Keys.KIDs(),
+	}
+}
+
+func (h Handlers) respond(w http.ResponseWriter, data any, statusCode int) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		h.Log.Errorw("keys", "ERROR", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if _, err := w.Write(jsonData); err != nil {
+		h.Log.Errorw("keys", "ERROR", err)
+	}
+}
+
+// nonNil makes sure an empty list is encoded as an empty array
+func nonNil(kids []string) []string {
+	if kids == nil {
+		return []string{}
+	}
+	return kids
+}
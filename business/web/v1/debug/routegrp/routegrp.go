@@ -0,0 +1,44 @@
+// Package routegrp provides the debug endpoint listing the routes of the API
+// and the middleware they run
+package routegrp
+
+import (
+	"encoding/json"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// Lister declares the behavior needed to list the routes of the API
+type Lister interface {
+	Routes() []web.RouteInfo
+}
+
+// Handlers manages the set of route endpoints.
+type Handlers struct {
+	Log    *zap.SugaredLogger
+	Routes Lister
+}
+
+// List returns every route registered with the API with the middleware it
+// runs in order of execution and the authorization rule it enforces
+func (h Handlers) List(w http.ResponseWriter, r *http.Request) {
+	routes := []web.RouteInfo{}
+	if h.Routes != nil {
+		routes = h.Routes.Routes()
+	}
+
+	jsonData, err := json.Marshal(routes)
+	if err != nil {
+		h.Log.Errorw("routes", "ERROR", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(jsonData); err != nil {
+		h.Log.Errorw("routes", "ERROR", err)
+	}
+}
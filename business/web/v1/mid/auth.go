@@ -4,13 +4,22 @@ import (
 	"context"
 	"github.com/theo-bot/service4.1-video/business/web/auth"
 	"github.com/theo-bot/service4.1-video/foundation/web"
+	"go.opentelemetry.io/otel/attribute"
 	"net/http"
 )
 
+// SecuritySchemeBearer is the name of the security scheme the routes using
+// Authenticate are documented with. Annotate the routes with it through
+// web.AnnotationSecurity
+const SecuritySchemeBearer = "bearerAuth"
+
 // Authenticate validates a JWT from the `Auhtorization` header
 func Authenticate(a *auth.Auth) web.Middleware {
 	m := func(handler web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := web.AddSpan(ctx, "business.web.v1.mid.authenticate")
+			defer span.End()
+
 			claims, err := a.Authenticate(ctx, r.Header.Get("authorization"))
 			if err != nil {
 				return auth.NewAuthError("authentication failed: %s", err)
@@ -32,6 +41,9 @@ func Authenticate(a *auth.Auth) web.Middleware {
 func Authorize(a *auth.Auth, rule string) web.Middleware {
 	m := func(handler web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := web.AddSpan(ctx, "business.web.v1.mid.authorize", attribute.String("rule", rule))
+			defer span.End()
+
 			claims := auth.GetClaims(ctx)
 			if claims.Subject == "" {
 				return auth.NewAuthError("authorize: you are not authorized for that action, no claims")
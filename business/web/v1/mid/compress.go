@@ -0,0 +1,335 @@
+package mid
+
+import (
+	"context"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Set of content encodings supported by the Compress middleware in order of
+// preference when the client accepts them equally
+const (
+	encodingZstd = "zstd"
+	encodingGzip = "gzip"
+)
+
+var supportedEncodings = []string{encodingZstd, encodingGzip}
+
+// Compress compresses the response body using zstd or gzip depending on the
+// Accept-Encoding header of the request. Bodies smaller than minSize bytes
+// and content types that are already compressed are sent as is. A response
+// that is flushed before it reaches minSize bytes, like a stream, is
+// compressed from that point on
+func Compress(minSize int) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := web.AddSpan(ctx, "business.web.v1.mid.compress")
+			defer span.End()
+
+			// The response varies on the header even when it's not
+			// compressed so caches keep the representations apart.
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				return handler(ctx, w, r)
+			}
+
+			cw := compressWriter{
+				w:        w,
+				encoding: encoding,
+				minSize:  minSize,
+				status:   http.StatusOK,
+			}
+
+			err := handler(ctx, &cw, r)
+
+			if cerr := cw.close(); cerr != nil && err == nil {
+				err = cerr
+			}
+
+			return err
+		}
+
+		return h
+	}
+
+	return m
+}
+
+// negotiateEncoding selects the supported content encoding the client
+// prefers. An empty string means the response must not be compressed
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				q = v
+			}
+		}
+
+		qualities[name] = q
+	}
+
+	var best string
+	var bestQ float64
+	for _, encoding := range supportedEncodings {
+		q, exists := qualities[encoding]
+		if !exists {
+			q, exists = qualities["*"]
+		}
+
+		if exists && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// compressible checks if the content type is worth compressing. Media that
+// is already compressed only gets bigger when compressed again
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+
+	switch typ {
+	case "text":
+		return true
+	case "image":
+		return subtype == "svg+xml"
+	case "audio", "video", "font":
+		return false
+	}
+
+	switch {
+	case subtype == "json", subtype == "xml", subtype == "javascript", subtype == "x-ndjson":
+		return true
+	case strings.HasSuffix(subtype, "+json"), strings.HasSuffix(subtype, "+xml"):
+		return true
+	}
+
+	return false
+}
+
+// =============================================================================
+
+// Pools of compressors so they aren't allocated for every response. The zstd
+// encoder is limited to a single goroutine since it compresses one response.
+var (
+	gzipPool = sync.Pool{
+		New: func() any {
+			return gzip.NewWriter(io.Discard)
+		},
+	}
+
+	zstdPool = sync.Pool{
+		New: func() any {
+			enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			return enc
+		},
+	}
+)
+
+// compressor is the behavior shared by the gzip and zstd writers
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressWriter buffers the start of the response until it knows whether
+// the response should be compressed. The status code is held back until that
+// decision is made since the headers depend on it
+type compressWriter struct {
+	w        http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status      int
+	wroteHeader bool
+	buf         []byte
+
+	decided bool
+	enc     compressor
+}
+
+// Header returns the header map of the response
+func (cw *compressWriter) Header() http.Header {
+	return cw.w.Header()
+}
+
+// WriteHeader records the status code. Responses that can't have a body are
+// sent right away without compression
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader || cw.decided {
+		return
+	}
+
+	// Informational responses are sent as is and don't end the response.
+	if statusCode >= 100 && statusCode < 200 {
+		cw.w.WriteHeader(statusCode)
+		return
+	}
+
+	cw.status = statusCode
+	cw.wroteHeader = true
+
+	if statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+// Write buffers the data until minSize bytes are collected. From then on
+// the data is compressed if the content type allows it
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(p)
+		}
+		return cw.w.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) < cw.minSize {
+		return len(p), nil
+	}
+
+	if err := cw.decide(true); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush sends the data written so far to the client. The response is
+// compressed from this point on when the content type allows it
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.decided {
+		if err := cw.decide(true); err != nil {
+			return
+		}
+	}
+
+	if cw.enc != nil {
+		if err := cw.enc.Flush(); err != nil {
+			return
+		}
+	}
+
+	http.NewResponseController(cw.w).Flush()
+}
+
+// Unwrap provides access to the underlying response writer for the
+// http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.w
+}
+
+// decide writes the headers and the buffered data. The data is compressed
+// when compression is wanted, the content type is compressible and the
+// handler didn't encode the response itself
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+
+	h := cw.w.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	if compress && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+
+		// The compressed bytes differ from the ones the strong entity tag
+		// was computed for so the tag is turned into a weak one.
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+
+		switch cw.encoding {
+		case encodingZstd:
+			enc := zstdPool.Get().(*zstd.Encoder)
+			enc.Reset(cw.w)
+			cw.enc = enc
+		default:
+			enc := gzipPool.Get().(*gzip.Writer)
+			enc.Reset(cw.w)
+			cw.enc = enc
+		}
+	}
+
+	cw.w.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+
+	if cw.enc != nil {
+		_, err := cw.enc.Write(buf)
+		return err
+	}
+
+	_, err := cw.w.Write(buf)
+	return err
+}
+
+// close sends whatever is still buffered uncompressed, since it's smaller
+// than minSize, or finishes the compressed stream
+func (cw *compressWriter) close() error {
+	if !cw.decided {
+		// Nothing was written so the handler is left to decide the
+		// response the client gets.
+		if !cw.wroteHeader && len(cw.buf) == 0 {
+			return nil
+		}
+		return cw.decide(false)
+	}
+
+	if cw.enc == nil {
+		return nil
+	}
+
+	err := cw.enc.Close()
+
+	switch enc := cw.enc.(type) {
+	case *zstd.Encoder:
+		enc.Reset(nil)
+		zstdPool.Put(enc)
+	case *gzip.Writer:
+		enc.Reset(io.Discard)
+		gzipPool.Put(enc)
+	}
+	cw.enc = nil
+
+	return err
+}
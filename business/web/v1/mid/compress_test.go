@@ -0,0 +1,338 @@
+package mid
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// decompress returns a reader of the body decoded with the content encoding
+func decompress(encoding string, body io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "":
+		return body, nil
+
+	case encodingGzip:
+		return gzip.NewReader(body)
+
+	case encodingZstd:
+		r, err := zstd.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return r.IOReadCloser(), nil
+	}
+
+	return nil, fmt.Errorf("unknown content encoding %q", encoding)
+}
+
+// =============================================================================
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", encodingGzip},
+		{"zstd", encodingZstd},
+		{"GZIP", encodingGzip},
+		{"gzip, deflate, br, zstd", encodingZstd},
+		{"gzip;q=1.0, zstd;q=0.5", encodingGzip},
+		{"zstd;q=0, gzip", encodingGzip},
+		{"zstd;q=0, gzip;q=0", ""},
+		{"*", encodingZstd},
+		{"*;q=0", ""},
+		{"*, zstd;q=0", encodingGzip},
+		{"gzip;q=0.5, *;q=0.8", encodingZstd},
+		{"br, deflate", ""},
+		{"identity", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			if got := negotiateEncoding(tt.acceptEncoding); got != tt.want {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.acceptEncoding, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	const minSize = 256
+
+	type record struct {
+		Text string `json:"text"`
+	}
+
+	large := record{Text: strings.Repeat("compress me ", 100)}
+	small := record{Text: "short"}
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 2*minSize)...)
+
+	app := web.NewApp(nil, nil, Compress(minSize))
+
+	app.Handle(http.MethodGet, "/large", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, large, http.StatusOK)
+	})
+	app.Handle(http.MethodHead, "/large", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, large, http.StatusOK)
+	})
+	app.Handle(http.MethodGet, "/small", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, small, http.StatusOK)
+	})
+	app.Handle(http.MethodGet, "/image", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "image/png")
+		_, err := w.Write(png)
+		return err
+	})
+	app.Handle(http.MethodGet, "/undeclared", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		_, err := io.WriteString(w, large.Text)
+		return err
+	})
+	app.Handle(http.MethodGet, "/encoded", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "br")
+		_, err := w.Write(bytes.Repeat([]byte("b"), 2*minSize))
+		return err
+	})
+	app.Handle(http.MethodGet, "/empty", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		acceptEncoding string
+		header         map[string]string
+		status         int
+		encoding       string
+		weakETag       bool
+	}{
+		{"gzip", http.MethodGet, "/large", "gzip", nil, http.StatusOK, encodingGzip, true},
+		{"zstd", http.MethodGet, "/large", "gzip, zstd", nil, http.StatusOK, encodingZstd, true},
+		{"no accept encoding", http.MethodGet, "/large", "", nil, http.StatusOK, "", false},
+		{"refused", http.MethodGet, "/large", "*;q=0", nil, http.StatusOK, "", false},
+		{"below min size", http.MethodGet, "/small", "gzip", nil, http.StatusOK, "", false},
+		{"already compressed media", http.MethodGet, "/image", "gzip", nil, http.StatusOK, "", false},
+		{"detected content type", http.MethodGet, "/undeclared", "zstd", nil, http.StatusOK, encodingZstd, false},
+		{"already encoded", http.MethodGet, "/encoded", "gzip", nil, http.StatusOK, "br", false},
+		{"no content", http.MethodGet, "/empty", "gzip", nil, http.StatusNoContent, "", false},
+		{"not modified", http.MethodGet, "/large", "gzip", map[string]string{"If-None-Match": "*"}, http.StatusNotModified, "", false},
+		{"head", http.MethodHead, "/large", "gzip", nil, http.StatusOK, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status code = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("content encoding = %q, want %q", got, tt.encoding)
+			}
+			if got := w.Header().Values("Vary"); !contains(got, "Accept-Encoding") {
+				t.Errorf("vary = %v, want Accept-Encoding", got)
+			}
+
+			if etag := w.Header().Get("ETag"); etag != "" {
+				if weak := strings.HasPrefix(etag, "W/"); weak != tt.weakETag {
+					t.Errorf("etag = %s, want weak %t", etag, tt.weakETag)
+				}
+			}
+
+			// The server drops the body of a HEAD response so only the
+			// encoding matters.
+			if tt.method == http.MethodHead {
+				return
+			}
+
+			switch {
+			case tt.status != http.StatusOK:
+				if w.Body.Len() != 0 {
+					t.Errorf("body = %d bytes, want none", w.Body.Len())
+				}
+
+			case tt.encoding == "br":
+				if w.Body.Len() != 2*minSize {
+					t.Errorf("body = %d bytes, want the handler's %d bytes", w.Body.Len(), 2*minSize)
+				}
+
+			default:
+				if tt.encoding != "" && w.Header().Get("Content-Length") != "" {
+					t.Errorf("content length %s sent with a compressed body", w.Header().Get("Content-Length"))
+				}
+
+				rd, err := decompress(tt.encoding, w.Body)
+				if err != nil {
+					t.Fatalf("decoding body: %s", err)
+				}
+				body, err := io.ReadAll(rd)
+				if err != nil {
+					t.Fatalf("decoding body: %s", err)
+				}
+				if len(body) == 0 {
+					t.Error("no body")
+				}
+				if tt.path == "/large" && !bytes.Contains(body, []byte(large.Text)) {
+					t.Errorf("decoded body = %.40q, want the record", body)
+				}
+			}
+		})
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCompressFlush(t *testing.T) {
+	for _, encoding := range supportedEncodings {
+		t.Run(encoding, func(t *testing.T) {
+			next := make(chan struct{})
+
+			app := web.NewApp(nil, nil, Compress(1024))
+			app.Handle(http.MethodGet, "/stream", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				records := []string{"first", "second"}
+				return web.RespondStream(ctx, w, func(ctx context.Context) (any, error) {
+					if len(records) == 0 {
+						return nil, io.EOF
+					}
+
+					// The second record is only sent once the client read
+					// the first one.
+					if len(records) == 1 {
+						select {
+						case <-next:
+						case <-time.After(5 * time.Second):
+							return nil, fmt.Errorf("first record was not received")
+						}
+					}
+
+					rec := records[0]
+					records = records[1:]
+					return rec, nil
+				}, http.StatusOK)
+			})
+
+			srv := httptest.NewServer(app)
+			defer srv.Close()
+
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/stream", nil)
+			if err != nil {
+				t.Fatalf("creating request: %s", err)
+			}
+			req.Header.Set("Accept-Encoding", encoding)
+
+			// The transport would decode gzip on its own and hide whether
+			// the response was compressed.
+			client := http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("requesting stream: %s", err)
+			}
+			defer resp.Body.Close()
+
+			if got := resp.Header.Get("Content-Encoding"); got != encoding {
+				t.Fatalf("content encoding = %q, want %q", got, encoding)
+			}
+
+			// The records are far smaller than minSize so they only reach
+			// the client because every flush goes through the encoder.
+			dec, err := decompress(encoding, resp.Body)
+			if err != nil {
+				t.Fatalf("decoding body: %s", err)
+			}
+			rd := bufio.NewReader(dec)
+
+			line, err := rd.ReadString('\n')
+			if err != nil || line != "\"first\"\n" {
+				t.Fatalf("first record = %q, %v", line, err)
+			}
+			close(next)
+
+			line, err = rd.ReadString('\n')
+			if err != nil || line != "\"second\"\n" {
+				t.Fatalf("second record = %q, %v", line, err)
+			}
+
+			if _, err := rd.ReadByte(); err != io.EOF {
+				t.Errorf("reading after the last record: %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestCompressConcurrent(t *testing.T) {
+	app := web.NewApp(nil, nil, Compress(64))
+	app.Handle(http.MethodGet, "/echo/:n", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "text/plain")
+		_, err := io.WriteString(w, strings.Repeat(web.Param(r, "n")+" ", 500))
+		return err
+	})
+
+	// Every response is compressed by an encoder from the pools. A response
+	// that decodes to the body of another request means an encoder was
+	// shared between responses.
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			encoding := supportedEncodings[i%len(supportedEncodings)]
+			n := fmt.Sprint(i)
+
+			for j := 0; j < 10; j++ {
+				r := httptest.NewRequest(http.MethodGet, "/echo/"+n, nil)
+				r.Header.Set("Accept-Encoding", encoding)
+
+				w := httptest.NewRecorder()
+				app.ServeHTTP(w, r)
+
+				rd, err := decompress(w.Header().Get("Content-Encoding"), w.Body)
+				if err != nil {
+					t.Errorf("decoding %s response: %s", encoding, err)
+					return
+				}
+				body, err := io.ReadAll(rd)
+				if err != nil {
+					t.Errorf("decoding %s response: %s", encoding, err)
+					return
+				}
+				if want := strings.Repeat(n+" ", 500); string(body) != want {
+					t.Errorf("request %s got another body: %.20q", n, body)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,187 @@
+package mid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig represents the cross-origin requests the API accepts
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed to call the API. An origin
+	// can contain wildcards like https://*.example.com and * allows any
+	// origin. No origin is allowed when it's empty
+	AllowedOrigins []string
+
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+
+	// MaxAge is how long a browser can cache the result of a preflight
+	// request
+	MaxAge time.Duration
+}
+
+// Validate checks the configuration can be used safely. Allowing any origin
+// to send credentials would let every website act on behalf of the users
+// logged in to the API so it's refused
+func (cfg CORSConfig) Validate() error {
+	if !cfg.AllowCredentials {
+		return nil
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			return errors.New("any origin can't be allowed with credentials")
+		}
+	}
+
+	return nil
+}
+
+// CORS adds the headers browsers need to allow cross-origin requests from
+// the configured origins. Preflight requests are answered without calling
+// the handler, so the middleware must be part of the application middleware
+// to run for the OPTIONS routes the app registers. It panics when the
+// configuration isn't valid
+func CORS(cfg CORSConfig) web.Middleware {
+	if err := cfg.Validate(); err != nil {
+		panic(fmt.Sprintf("mid.CORS: %s", err))
+	}
+
+	allowedMethods := make(map[string]bool)
+	for _, method := range cfg.AllowedMethods {
+		allowedMethods[strings.ToUpper(method)] = true
+	}
+
+	allowedHeaders := make(map[string]bool)
+	for _, header := range cfg.AllowedHeaders {
+		allowedHeaders[http.CanonicalHeaderKey(header)] = true
+	}
+
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+
+	var maxAge string
+	if cfg.MaxAge > 0 {
+		maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			origin := r.Header.Get("Origin")
+
+			// The response depends on the origin even when it's not
+			// allowed so caches keep the responses apart.
+			w.Header().Add("Vary", "Origin")
+
+			if origin == "" {
+				return handler(ctx, w, r)
+			}
+
+			allowOrigin, allowed := matchOrigin(cfg.AllowedOrigins, origin)
+			if !allowed {
+				return handler(ctx, w, r)
+			}
+
+			reqMethod := r.Header.Get("Access-Control-Request-Method")
+			if r.Method != http.MethodOptions || reqMethod == "" {
+				w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+				if cfg.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+				if exposed != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposed)
+				}
+
+				return handler(ctx, w, r)
+			}
+
+			// This is a preflight request. When the method or one of the
+			// headers isn't allowed the request is handled as a regular
+			// OPTIONS request and the browser refuses the actual request.
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+			if !allowedMethods[strings.ToUpper(reqMethod)] {
+				return handler(ctx, w, r)
+			}
+
+			reqHeaders, ok := matchHeaders(allowedHeaders, r.Header.Get("Access-Control-Request-Headers"))
+			if !ok {
+				return handler(ctx, w, r)
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if reqHeaders != "" {
+				w.Header().Set("Access-Control-Allow-Headers", reqHeaders)
+			}
+			if cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if maxAge != "" {
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+
+			return web.Respond(ctx, w, nil, http.StatusNoContent)
+		}
+
+		return h
+	}
+
+	return m
+}
+
+// matchOrigin checks the origin against the allowed origins and returns the
+// value for the Access-Control-Allow-Origin header
+func matchOrigin(allowed []string, origin string) (string, bool) {
+	origin = strings.ToLower(origin)
+
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+
+		if pattern == "*" {
+			return "*", true
+		}
+
+		// A wildcard in the pattern doesn't match a slash so it can't
+		// match across the scheme or the port.
+		if matched, err := path.Match(pattern, origin); err == nil && matched {
+			return origin, true
+		}
+	}
+
+	return "", false
+}
+
+// matchHeaders checks the headers a preflight request asks for against the
+// allowed headers. A * in the allowed headers allows any header
+func matchHeaders(allowed map[string]bool, requested string) (string, bool) {
+	if requested == "" {
+		return "", true
+	}
+
+	var headers []string
+	for _, header := range strings.Split(requested, ",") {
+		header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+		if header == "" {
+			continue
+		}
+
+		if !allowed["*"] && !allowed[header] {
+			return "", false
+		}
+
+		headers = append(headers, header)
+	}
+
+	return strings.Join(headers, ", "), true
+}
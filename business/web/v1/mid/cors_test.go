@@ -0,0 +1,77 @@
+package mid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     CORSConfig
+		wantErr bool
+	}{
+		{"any origin", CORSConfig{AllowedOrigins: []string{"*"}}, false},
+		{"any origin with credentials", CORSConfig{AllowedOrigins: []string{"https://example.com", "*"}, AllowCredentials: true}, true},
+		{"wildcard origin with credentials", CORSConfig{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}, false},
+		{"no origin", CORSConfig{AllowCredentials: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("construction", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("CORS didn't panic for any origin with credentials")
+			}
+		}()
+
+		CORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	})
+}
+
+func TestCORS(t *testing.T) {
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	}
+
+	tests := []struct {
+		name        string
+		cfg         CORSConfig
+		origin      string
+		allowOrigin string
+		credentials string
+	}{
+		{"any origin", CORSConfig{AllowedOrigins: []string{"*"}}, "https://evil.com", "*", ""},
+		{"credentials echo the origin", CORSConfig{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}, "https://app.example.com", "https://app.example.com", "true"},
+		{"origin not allowed", CORSConfig{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}, "https://evil.com", "", ""},
+		{"no origin allowed", CORSConfig{}, "https://app.example.com", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Origin", tt.origin)
+			w := httptest.NewRecorder()
+
+			if err := CORS(tt.cfg)(handler)(context.Background(), w, r); err != nil {
+				t.Fatalf("handling: %s", err)
+			}
+
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("allow origin = %q, want %q", got, tt.allowOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.credentials {
+				t.Errorf("allow credentials = %q, want %q", got, tt.credentials)
+			}
+		})
+	}
+}
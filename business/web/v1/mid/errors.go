@@ -2,61 +2,86 @@ package mid
 
 import (
 	"context"
+	"errors"
 	"github.com/theo-bot/service4.1-video/business/sys/validate"
 	"github.com/theo-bot/service4.1-video/business/web/auth"
 	v1 "github.com/theo-bot/service4.1-video/business/web/v1"
 	"github.com/theo-bot/service4.1-video/foundation/web"
 	"go.uber.org/zap"
 	"net/http"
+	"strings"
 )
 
+// ErrorsConfig represents the way errors are sent to the client
+type ErrorsConfig struct {
+	// Format is used when the client doesn't ask for problem details in
+	// the Accept header. The zero value is the legacy format
+	Format v1.ErrorFormat
+
+	// ProblemTypeBase is the URI the problem types are appended to, like
+	// https://api.example.com/problems/. A relative URI is resolved by the
+	// client against the URL of the request
+	ProblemTypeBase string
+}
+
+// defaultProblemTypeBase is used when no base URI is configured
+const defaultProblemTypeBase = "/problems/"
+
 // Errors handles errors coming out of the call chain. It detects normal
 // application errors which are used to respond to the client in a uniform way.
-// Unexpected errors (status >= 500) are logged
-func Errors(log *zap.SugaredLogger) web.Middleware {
+// Unexpected errors (status >= 500) are logged. Clients asking for
+// application/problem+json get the problem details of RFC 7807, the others
+// get the configured format
+func Errors(log *zap.SugaredLogger, cfg ErrorsConfig) web.Middleware {
+	typeBase := cfg.ProblemTypeBase
+	if typeBase == "" {
+		typeBase = defaultProblemTypeBase
+	}
+
 	m := func(handler web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-			if err := handler(ctx, w, r); err != nil {
-				log.Errorw("ERROR", "trace_id", web.GetTraceID(ctx), "message", err)
+			ctx, span := web.AddSpan(ctx, "business.web.v1.mid.errors")
+			defer span.End()
 
-				var er v1.ErrorResponse
-				var status int
+			if err := handler(ctx, w, r); err != nil {
+				log.Errorw("ERROR", "trace_id", web.GetTraceID(ctx), "span_id", web.GetSpanID(ctx), "message", err)
 
-				switch {
-				case v1.IsRequestError(err):
-					reqErr := v1.GetRequestError(err)
-					er = v1.ErrorResponse{
-						Error: reqErr.Error(),
-					}
-					status = reqErr.Status
-				case auth.IsAuthError(err):
-					er = v1.ErrorResponse{
-						Error: http.StatusText(http.StatusUnauthorized),
+				// A stream has already committed the response so the
+				// client can't be sent an error. Shutdown errors and
+				// client disconnects are returned to the base handler
+				// which knows only the former warrants a shutdown.
+				if web.IsStreamError(err) {
+					if web.IsShutdown(err) || web.IsDisconnect(err) {
+						return err
 					}
-					status = http.StatusUnauthorized
-				case validate.IsFieldErrors(err):
-					fieldErrors := validate.GetFieldErrors(err)
-					er = v1.ErrorResponse{
-						Error:  "data validation error",
-						Fields: fieldErrors.Fields(),
-					}
-					status = http.StatusBadRequest
-				default:
-					er = v1.ErrorResponse{
-						Error: http.StatusText(http.StatusInternalServerError),
-					}
-					status = http.StatusInternalServerError
+					return nil
 				}
 
-				if err := web.Respond(ctx, w, er, status); err != nil {
-					return err
+				pd := problemDetails(err)
+
+				var rerr error
+				if cfg.Format == v1.ErrorFormatProblem || web.Accepts(r.Header.Get("Accept"), web.MediaTypeProblemJSON) {
+					pd.Type = typeBase + pd.Type
+					pd.Instance = web.GetTraceID(ctx)
+					rerr = web.RespondJSON(ctx, w, pd, pd.Status, web.MediaTypeProblemJSON)
+				} else {
+					er := v1.ErrorResponse{
+						Error:  pd.Detail,
+						Fields: pd.Fields,
+					}
+					rerr = web.RespondError(ctx, w, er, pd.Status)
 				}
 
 				// If we receive the shutdown err we need to return it
 				// back to the base handler to shut down the service
+				// even when the error couldn't be sent.
 				if web.IsShutdown(err) {
 					return err
 				}
+
+				if rerr != nil {
+					return rerr
+				}
 			}
 			return nil
 		}
@@ -66,3 +91,72 @@ func Errors(log *zap.SugaredLogger) web.Middleware {
 
 	return m
 }
+
+// problemDetails describes the error the way it's sent to the client. The
+// type is relative to the base URI and the detail is the message of the
+// legacy format
+func problemDetails(err error) v1.ProblemDetails {
+	switch {
+	case v1.IsRequestError(err):
+		reqErr := v1.GetRequestError(err)
+		return v1.ProblemDetails{
+			Type:   problemType(reqErr.Status),
+			Title:  http.StatusText(reqErr.Status),
+			Status: reqErr.Status,
+			Detail: reqErr.Error(),
+		}
+
+	case web.IsDecodeError(err):
+		decErr := web.GetDecodeError(err)
+		return v1.ProblemDetails{
+			Type:   problemType(decErr.Status),
+			Title:  http.StatusText(decErr.Status),
+			Status: decErr.Status,
+			Detail: decErr.Error(),
+		}
+
+	case auth.IsAuthError(err):
+		return v1.ProblemDetails{
+			Type:   v1.ProblemAuth,
+			Title:  http.StatusText(http.StatusUnauthorized),
+			Status: http.StatusUnauthorized,
+			Detail: http.StatusText(http.StatusUnauthorized),
+		}
+
+	case validate.IsFieldErrors(err):
+		fieldErrors := validate.GetFieldErrors(err)
+		return v1.ProblemDetails{
+			Type:   v1.ProblemValidation,
+			Title:  "Data validation error",
+			Status: http.StatusBadRequest,
+			Detail: "data validation error",
+			Fields: fieldErrors.Fields(),
+		}
+
+	case errors.Is(err, context.DeadlineExceeded):
+		return v1.ProblemDetails{
+			Type:   v1.ProblemTimeout,
+			Title:  "Request timeout",
+			Status: http.StatusServiceUnavailable,
+			Detail: "request did not complete in time",
+		}
+	}
+
+	return v1.ProblemDetails{
+		Type:   v1.ProblemInternal,
+		Title:  http.StatusText(http.StatusInternalServerError),
+		Status: http.StatusInternalServerError,
+		Detail: http.StatusText(http.StatusInternalServerError),
+	}
+}
+
+// problemType derives the problem type of a request error from its status
+// code, like not-found for a 404
+func problemType(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+
+	return strings.NewReplacer(" ", "-", "'", "").Replace(strings.ToLower(text))
+}
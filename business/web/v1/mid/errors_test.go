@@ -0,0 +1,84 @@
+package mid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestErrorsTimeout(t *testing.T) {
+	slow := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		<-ctx.Done()
+		return nil
+	}
+
+	handler := Errors(zap.NewNop().Sugar(), ErrorsConfig{})(web.Timeout(10 * time.Millisecond)(slow))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if err := handler(context.Background(), w, r); err != nil {
+		t.Fatalf("error = %v, want nil", err)
+	}
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if want := `{"error":"request did not complete in time"}`; w.Body.String() != want {
+		t.Errorf("body = %s, want %s", w.Body.String(), want)
+	}
+}
+
+func TestErrorsStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		returned bool
+	}{
+		{"error", errors.New("db down"), false},
+		{"disconnect", fmt.Errorf("writing: %w", syscall.EPIPE), true},
+		{"canceled", context.Canceled, true},
+		{"shutdown", web.NewShutdownError("integrity issue"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := false
+			next := func(ctx context.Context) (any, error) {
+				if sent {
+					return nil, tt.err
+				}
+				sent = true
+				return 1, nil
+			}
+
+			stream := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				return web.RespondStream(ctx, w, next, http.StatusOK)
+			}
+
+			handler := Errors(zap.NewNop().Sugar(), ErrorsConfig{})(stream)
+
+			w := httptest.NewRecorder()
+			err := handler(context.Background(), w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			// Only the errors the base handler acts on are returned and the
+			// committed response is never followed by an error body.
+			if returned := err != nil; returned != tt.returned {
+				t.Errorf("error = %v, want returned %t", err, tt.returned)
+			}
+			if err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("error = %v, want it to wrap %v", err, tt.err)
+			}
+			if body := w.Body.String(); body != "1\n" {
+				t.Errorf("body = %q, want %q", body, "1\n")
+			}
+		})
+	}
+}
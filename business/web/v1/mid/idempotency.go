@@ -0,0 +1,172 @@
+package mid
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/theo-bot/service4.1-video/business/web/auth"
+	"github.com/theo-bot/service4.1-video/business/web/idempotency"
+	v1 "github.com/theo-bot/service4.1-video/business/web/v1"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"io"
+	"net/http"
+)
+
+// Set of headers used by the Idempotency middleware
+const (
+	headerIdempotencyKey = "Idempotency-Key"
+	headerReplayed       = "Idempotent-Replayed"
+)
+
+// maxIdempotentBody is the largest request body the Idempotency middleware
+// reads to fingerprint the request. It matches the limit of web.Decode
+const maxIdempotentBody = 1 << 20
+
+// Idempotency stores the response of POST and PATCH requests carrying an
+// Idempotency-Key header. A retry with the same key and body is answered
+// with the stored response without calling the handler again. Keys are
+// scoped to the authenticated subject so it must run after Authenticate
+func Idempotency(store idempotency.Storer) web.Middleware {
+	m := func(handler web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			idemKey := r.Header.Get(headerIdempotencyKey)
+			if idemKey == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+				return handler(ctx, w, r)
+			}
+
+			ctx, span := web.AddSpan(ctx, "business.web.v1.mid.idempotency")
+			defer span.End()
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+			if err != nil {
+				return fmt.Errorf("reading body: %w", err)
+			}
+			if len(body) > maxIdempotentBody {
+				return v1.NewRequestError(errors.New("request body too large"), http.StatusRequestEntityTooLarge)
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			key := auth.GetClaims(ctx).Subject + ":" + idemKey
+			fingerprint := requestFingerprint(r, body)
+
+			rec, reserved, err := store.Reserve(ctx, key, fingerprint)
+			if err != nil {
+				return fmt.Errorf("reserve: key[%s]: %w", idemKey, err)
+			}
+
+			if !reserved {
+				switch {
+				case rec.Fingerprint != fingerprint:
+					return v1.NewRequestError(errors.New("idempotency key was used for a different request"), http.StatusUnprocessableEntity)
+				case !rec.Completed:
+					return v1.NewRequestError(idempotency.ErrInProgress, http.StatusConflict)
+				}
+
+				return replay(ctx, w, rec)
+			}
+
+			rw := recordWriter{
+				ResponseWriter: w,
+				status:         http.StatusOK,
+			}
+
+			if err := handler(ctx, &rw, r); err != nil {
+				// Failed requests aren't stored so the client is free to
+				// try again once the problem is fixed.
+				if rerr := store.Release(ctx, key); rerr != nil {
+					return fmt.Errorf("release: key[%s]: %w: %w", idemKey, rerr, err)
+				}
+				return err
+			}
+
+			rec = idempotency.Record{
+				Fingerprint: fingerprint,
+				StatusCode:  rw.status,
+				Header:      rw.header,
+				Body:        rw.body.Bytes(),
+			}
+
+			if err := store.Complete(ctx, key, rec); err != nil {
+				return fmt.Errorf("complete: key[%s]: %w", idemKey, err)
+			}
+
+			return nil
+		}
+
+		return h
+	}
+
+	return m
+}
+
+// requestFingerprint identifies the request a key was first used for so
+// reuse of the key for a different request can be detected
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replay writes the stored response. Headers the middleware in front of this
+// one already set, like the trace headers, are kept
+func replay(ctx context.Context, w http.ResponseWriter, rec idempotency.Record) error {
+	for k, v := range rec.Header {
+		if _, exists := w.Header()[k]; !exists {
+			w.Header()[k] = v
+		}
+	}
+	w.Header().Set(headerReplayed, "true")
+
+	web.SetStatusCode(ctx, rec.StatusCode)
+	w.WriteHeader(rec.StatusCode)
+
+	if _, err := w.Write(rec.Body); err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// recordWriter keeps a copy of the response the handler writes
+type recordWriter struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+// WriteHeader records the status code and headers of the response
+func (rw *recordWriter) WriteHeader(statusCode int) {
+	if !rw.wroteHeader && statusCode >= 200 {
+		rw.status = statusCode
+		rw.header = rw.ResponseWriter.Header().Clone()
+		rw.wroteHeader = true
+	}
+
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write records the data of the response body
+func (rw *recordWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	rw.body.Write(p)
+
+	return rw.ResponseWriter.Write(p)
+}
+
+// Unwrap provides access to the underlying response writer for the
+// http.ResponseController
+func (rw *recordWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,173 @@
+package mid
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/theo-bot/service4.1-video/business/web/auth"
+	"github.com/theo-bot/service4.1-video/business/web/idempotency"
+	v1 "github.com/theo-bot/service4.1-video/business/web/v1"
+	"github.com/theo-bot/service4.1-video/business/web/v1/apitest"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	var calls int
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"call":%d,"body":%q}`, calls, body)
+		return nil
+	}
+
+	h := Idempotency(idempotency.NewMemory(time.Hour))(handler)
+
+	send := func(subject string, key string, body string) (*httptest.ResponseRecorder, error) {
+		r := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(body))
+		if key != "" {
+			r.Header.Set(headerIdempotencyKey, key)
+		}
+		ctx := auth.SetClaims(context.Background(), auth.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: subject}})
+
+		w := httptest.NewRecorder()
+		err := h(ctx, w, r)
+		return w, err
+	}
+
+	// The first request with a key is reserved and handled.
+	w, err := send("alice", "k1", `{"n":1}`)
+	if err != nil || w.Code != http.StatusCreated || calls != 1 {
+		t.Fatalf("first request: err %v status %d calls %d", err, w.Code, calls)
+	}
+	first := w.Body.String()
+
+	// A retry gets the stored response without calling the handler.
+	w, err = send("alice", "k1", `{"n":1}`)
+	if err != nil || calls != 1 {
+		t.Fatalf("replay: err %v calls %d", err, calls)
+	}
+	if w.Code != http.StatusCreated || w.Body.String() != first || w.Header().Get(headerReplayed) != "true" {
+		t.Errorf("replay: status %d body %s replayed %q, want %d %s true", w.Code, w.Body.String(), w.Header().Get(headerReplayed), http.StatusCreated, first)
+	}
+
+	// The key reused with a different body is rejected.
+	_, err = send("alice", "k1", `{"n":2}`)
+	if re := v1.GetRequestError(err); re == nil || re.Status != http.StatusUnprocessableEntity {
+		t.Errorf("different body: err %v, want a %d request error", err, http.StatusUnprocessableEntity)
+	}
+
+	// Keys are scoped to the subject so another caller isn't replayed the
+	// response of the first one.
+	w, err = send("bob", "k1", `{"n":1}`)
+	if err != nil || w.Header().Get(headerReplayed) != "" || calls != 2 {
+		t.Errorf("other subject: err %v replayed %q calls %d, want a new call", err, w.Header().Get(headerReplayed), calls)
+	}
+
+	// Requests without a key are always handled.
+	send("alice", "", `{"n":1}`)
+	if calls != 3 {
+		t.Errorf("no key: calls %d, want 3", calls)
+	}
+}
+
+// TestIdempotencyRoute runs the middleware on a route of an app so the
+// stored response goes through the error handling and authentication the
+// routes of the API run
+func TestIdempotencyRoute(t *testing.T) {
+	var calls int
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var item struct {
+			Name string `json:"name" validate:"required"`
+		}
+		if err := web.Decode(r, &item); err != nil {
+			return err
+		}
+
+		calls++
+		return web.Respond(ctx, w, map[string]any{"call": calls, "name": item.Name}, http.StatusCreated)
+	}
+
+	var app *web.App
+	test := apitest.New(t, func(test *apitest.Test) http.Handler {
+		app = web.NewApp(test.Shutdown, nil, Errors(test.Log, ErrorsConfig{}), Panics())
+		app.Handle(http.MethodPost, "/items", handler, Authenticate(test.Auth), Idempotency(idempotency.NewMemory(time.Hour)))
+		return app
+	})
+
+	if err := CheckRoutes(app.Routes()); err != nil {
+		t.Fatalf("checking routes: %s", err)
+	}
+
+	alice := test.Token("alice")
+	key := http.Header{headerIdempotencyKey: {"k1"}}
+
+	created := func(call int, replayed string) func(t *testing.T, w *httptest.ResponseRecorder) {
+		return func(t *testing.T, w *httptest.ResponseRecorder) {
+			want := fmt.Sprintf(`{"call":%d,"name":"gopher"}`, call)
+			if got := strings.TrimSpace(w.Body.String()); got != want {
+				t.Errorf("body = %s, want %s", got, want)
+			}
+			if got := w.Header().Get(headerReplayed); got != replayed {
+				t.Errorf("%s = %q, want %q", headerReplayed, got, replayed)
+			}
+		}
+	}
+
+	test.Run(t, []apitest.Case{
+		{
+			Name:       "reserve",
+			Method:     http.MethodPost,
+			URL:        "/items",
+			Token:      alice,
+			Header:     key,
+			Body:       map[string]string{"name": "gopher"},
+			StatusCode: http.StatusCreated,
+			Check:      created(1, ""),
+		},
+		{
+			Name:       "replay",
+			Method:     http.MethodPost,
+			URL:        "/items",
+			Token:      alice,
+			Header:     key,
+			Body:       map[string]string{"name": "gopher"},
+			StatusCode: http.StatusCreated,
+			Check:      created(1, "true"),
+		},
+		{
+			Name:       "key reused with another body",
+			Method:     http.MethodPost,
+			URL:        "/items",
+			Token:      alice,
+			Header:     key,
+			Body:       map[string]string{"name": "rust"},
+			StatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:       "same key for another subject",
+			Method:     http.MethodPost,
+			URL:        "/items",
+			Token:      test.Token("bob"),
+			Header:     key,
+			Body:       map[string]string{"name": "gopher"},
+			StatusCode: http.StatusCreated,
+			Check:      created(2, ""),
+		},
+		{
+			Name:       "anonymous",
+			Method:     http.MethodPost,
+			URL:        "/items",
+			Header:     key,
+			Body:       map[string]string{"name": "gopher"},
+			StatusCode: http.StatusUnauthorized,
+		},
+	})
+}
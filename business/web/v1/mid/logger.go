@@ -12,6 +12,9 @@ import (
 func Logger(log *zap.SugaredLogger) web.Middleware {
 	m := func(handler web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := web.AddSpan(ctx, "business.web.v1.mid.logger")
+			defer span.End()
+
 			v := web.GetValues(ctx)
 
 			path := r.URL.Path
@@ -19,11 +22,11 @@ func Logger(log *zap.SugaredLogger) web.Middleware {
 				path = fmt.Sprintf("%s?%s", path, r.URL.RawQuery)
 			}
 
-			log.Infow("request started", "trace_id", v.TraceID, "method", r.Method, "path", r.URL.Path, "remoteaddr", r.RemoteAddr)
+			log.Infow("request started", "trace_id", v.TraceID, "span_id", v.SpanID, "method", r.Method, "path", r.URL.Path, "remoteaddr", r.RemoteAddr)
 
 			err := handler(ctx, w, r)
 
-			log.Infow("request completed", "trace_id", v.TraceID, "method", r.Method, "path", r.URL.Path,
+			log.Infow("request completed", "trace_id", v.TraceID, "span_id", v.SpanID, "method", r.Method, "path", r.URL.Path,
 				"remoteaddr", r.RemoteAddr, "statuscode", v.StatusCode, "since", time.Since(v.Now))
 
 			return err
@@ -0,0 +1,69 @@
+package mid
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoggerNotModified(t *testing.T) {
+	var buf bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zap.InfoLevel)
+	log := zap.New(core).Sugar()
+
+	app := web.NewApp(nil, nil, Logger(log), Errors(log, ErrorsConfig{}), Metrics())
+	app.Handle(http.MethodGet, "/user", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		web.SetETag(ctx, "v1")
+		return web.Respond(ctx, w, map[string]string{"name": "bill"}, http.StatusOK)
+	})
+
+	responses := func(status string) int64 {
+		v, ok := expvar.Get("responses").(*expvar.Map).Get(status).(*expvar.Int)
+		if !ok {
+			return 0
+		}
+		return v.Value()
+	}
+	before := responses("304")
+
+	r := httptest.NewRequest(http.MethodGet, "/user", nil)
+	r.Header.Set("If-None-Match", `"v1"`)
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("status code = %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	if got := responses("304") - before; got != 1 {
+		t.Errorf("304 responses metered = %d, want 1", got)
+	}
+
+	var completed map[string]any
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &entry); err != nil {
+			t.Fatalf("decoding log entry: %s", err)
+		}
+		if entry["msg"] == "request completed" {
+			completed = entry
+		}
+	}
+
+	if completed == nil {
+		t.Fatalf("request completion not logged:\n%s", buf.String())
+	}
+	if status := completed["statuscode"]; status != float64(http.StatusNotModified) {
+		t.Errorf("logged status code = %v, want %d", status, http.StatusNotModified)
+	}
+}
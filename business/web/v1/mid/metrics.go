@@ -10,6 +10,9 @@ import (
 func Metrics() web.Middleware {
 	m := func(handler web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, span := web.AddSpan(ctx, "business.web.v1.mid.metrics")
+			defer span.End()
+
 			ctx = metrics.Set(ctx)
 
 			err := handler(ctx, w, r)
@@ -21,6 +24,19 @@ func Metrics() web.Middleware {
 				metrics.AddErrors(ctx)
 			}
 
+			// Error responses are written by the error handling middleware
+			// after this point so only successful responses are recorded.
+			v := web.GetValues(ctx)
+			if err == nil && v.StatusCode != 0 {
+				metrics.AddResponse(ctx, v.StatusCode)
+			}
+
+			// Requests to deprecated routes are counted so we know when
+			// it's safe to remove them.
+			if v.DeprecatedRoute != "" {
+				metrics.AddDeprecated(ctx, v.DeprecatedRoute)
+			}
+
 			return err
 		}
 
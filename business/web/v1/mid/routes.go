@@ -0,0 +1,61 @@
+package mid
+
+import (
+	"errors"
+	"fmt"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+)
+
+// Set of middleware names as reported by web.MiddlewareName
+const (
+	nameAuthenticate = "mid.Authenticate"
+	nameAuthorize    = "mid.Authorize"
+	nameIdempotency  = "mid.Idempotency"
+)
+
+// CheckRoutes validates the authentication setup of the routes. Every route
+// must run Authenticate unless it's marked public, and Authorize and
+// Idempotency only work when Authenticate runs before them. Idempotency keys
+// are scoped to the authenticated subject so anonymous callers would share
+// them. All the violations are returned
+func CheckRoutes(routes []web.RouteInfo) error {
+	var errs []error
+
+	for _, rt := range routes {
+		authenticate, authorize, idempotency := -1, -1, -1
+		for i, name := range rt.Middleware {
+			switch name {
+			case nameAuthenticate:
+				if authenticate == -1 {
+					authenticate = i
+				}
+			case nameAuthorize:
+				if authorize == -1 {
+					authorize = i
+				}
+			case nameIdempotency:
+				if idempotency == -1 {
+					idempotency = i
+				}
+			}
+		}
+
+		switch {
+		case authorize != -1 && authenticate == -1:
+			errs = append(errs, fmt.Errorf("route %s %s: runs %s without %s", rt.Method, rt.Path, nameAuthorize, nameAuthenticate))
+		case authorize != -1 && authorize < authenticate:
+			errs = append(errs, fmt.Errorf("route %s %s: runs %s before %s", rt.Method, rt.Path, nameAuthorize, nameAuthenticate))
+		case authenticate == -1 && !rt.Public:
+			errs = append(errs, fmt.Errorf("route %s %s: has no authentication and isn't marked public", rt.Method, rt.Path))
+		}
+
+		switch {
+		case idempotency != -1 && authenticate == -1:
+			errs = append(errs, fmt.Errorf("route %s %s: runs %s without %s", rt.Method, rt.Path, nameIdempotency, nameAuthenticate))
+		case idempotency != -1 && idempotency < authenticate:
+			errs = append(errs, fmt.Errorf("route %s %s: runs %s before %s", rt.Method, rt.Path, nameIdempotency, nameAuthenticate))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,42 @@
+package mid
+
+import (
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"strings"
+	"testing"
+)
+
+func TestCheckRoutes(t *testing.T) {
+	tests := []struct {
+		name       string
+		middleware []string
+		public     bool
+		wantErr    string
+	}{
+		{"authenticated", []string{nameAuthenticate, nameAuthorize, nameIdempotency}, false, ""},
+		{"public", nil, true, ""},
+		{"no authentication", nil, false, "isn't marked public"},
+		{"authorize first", []string{nameAuthorize, nameAuthenticate}, false, "runs mid.Authorize before mid.Authenticate"},
+		{"idempotency first", []string{nameIdempotency, nameAuthenticate}, false, "runs mid.Idempotency before mid.Authenticate"},
+		{"idempotency anonymous", []string{nameIdempotency}, true, "runs mid.Idempotency without mid.Authenticate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := []web.RouteInfo{{
+				Method:     "POST",
+				Path:       "/v1/users",
+				Middleware: tt.middleware,
+				Public:     tt.public,
+			}}
+
+			err := CheckRoutes(routes)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("error = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package v1
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // ErrorResponse is the form used for API responses from failures in the API
 type ErrorResponse struct {
@@ -8,6 +11,49 @@ type ErrorResponse struct {
 	Fields map[string]string `json:"fields,omitempty"`
 }
 
+// ProblemDetails is the form used for API responses from failures in the
+// API when the client asks for application/problem+json as defined by
+// RFC 7807. The field errors are added as an extension member
+type ProblemDetails struct {
+	Type     string            `json:"type"`
+	Title    string            `json:"title"`
+	Status   int               `json:"status"`
+	Detail   string            `json:"detail,omitempty"`
+	Instance string            `json:"instance,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+// ErrorFormat represents the form of the API responses from failures
+type ErrorFormat string
+
+// Set of error formats the API can respond with
+const (
+	ErrorFormatLegacy  ErrorFormat = "legacy"
+	ErrorFormatProblem ErrorFormat = "problem"
+)
+
+// ParseErrorFormat parses the name of an error format. An empty name is the
+// legacy format
+func ParseErrorFormat(format string) (ErrorFormat, error) {
+	switch ErrorFormat(format) {
+	case "", ErrorFormatLegacy:
+		return ErrorFormatLegacy, nil
+	case ErrorFormatProblem:
+		return ErrorFormatProblem, nil
+	}
+
+	return "", fmt.Errorf("unknown error format %q", format)
+}
+
+// Set of problem types used by the API. They are appended to the base URI
+// configured for the problem types
+const (
+	ProblemValidation = "validation-error"
+	ProblemAuth       = "unauthorized"
+	ProblemTimeout    = "timeout"
+	ProblemInternal   = "internal-error"
+)
+
 // RequestError is used to pass an error during the request through the
 // application with web specific context
 type RequestError struct {
@@ -0,0 +1,185 @@
+// Package certstore provides support for serving a TLS certificate from disk
+// that is reloaded when the files are rotated
+package certstore
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Info represents the details of the certificate being served
+type Info struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dnsNames,omitempty"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	LoadedAt  time.Time `json:"loadedAt"`
+}
+
+// Store holds the certificate and key loaded from a pair of PEM files. The
+// files are checked for changes so a rotated certificate is served without
+// restarting the service
+type Store struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	info     Info
+	certStat fileStat
+	keyStat  fileStat
+}
+
+// New constructs a Store with the certificate and key from the specified
+// PEM files
+func New(certFile string, keyFile string) (*Store, error) {
+	s := Store{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// TLSConfig constructs a tls.Config that serves the certificate held by the
+// store and negotiates HTTP/2 when the client supports it
+func (s *Store) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: s.GetCertificate,
+	}
+}
+
+// GetCertificate returns the current certificate. It's used as the
+// GetCertificate function of the tls.Config
+func (s *Store) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cert, nil
+}
+
+// Info returns the details of the current certificate
+func (s *Store) Info() Info {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.info
+}
+
+// Reload loads the certificate and key again when either file changed since
+// they were last loaded. It reports if a new certificate is being served.
+// When the files can't be loaded, like while they are halfway through
+// being replaced, the current certificate is kept
+func (s *Store) Reload() (bool, error) {
+	certStat, err := statFile(s.certFile)
+	if err != nil {
+		return false, fmt.Errorf("stat cert file: %w", err)
+	}
+
+	keyStat, err := statFile(s.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("stat key file: %w", err)
+	}
+
+	s.mu.RLock()
+	unchanged := s.cert != nil && certStat == s.certStat && keyStat == s.keyStat
+	s.mu.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("loading key pair: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, fmt.Errorf("parsing certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	info := Info{
+		Subject:   leaf.Subject.String(),
+		Issuer:    leaf.Issuer.String(),
+		DNSNames:  leaf.DNSNames,
+		Serial:    leaf.SerialNumber.String(),
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+		LoadedAt:  time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cert = &cert
+	s.info = info
+	s.certStat = certStat
+	s.keyStat = keyStat
+
+	return true, nil
+}
+
+// Watch checks the files for changes at the specified interval until the
+// context is canceled. The function is called with the result of every
+// reload that loaded a new certificate or failed. A non-positive interval
+// disables watching and the function returns right away
+func (s *Store) Watch(ctx context.Context, interval time.Duration, fn func(info Info, err error)) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			reloaded, err := s.Reload()
+			if err != nil || reloaded {
+				fn(s.Info(), err)
+			}
+		}
+	}
+}
+
+// =============================================================================
+
+// fileStat is the part of the file information used to detect a change.
+// Files mounted from a Kubernetes secret are replaced through a symlink so
+// following it and comparing the target is enough
+type fileStat struct {
+	modTime int64
+	size    int64
+}
+
+func statFile(name string) (fileStat, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return fileStat{}, err
+	}
+
+	if fi.IsDir() {
+		return fileStat{}, errors.New("is a directory")
+	}
+
+	return fileStat{modTime: fi.ModTime().UnixNano(), size: fi.Size()}, nil
+}
@@ -0,0 +1,241 @@
+package certstore
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pair is a certificate and its private key encoded as PEM
+type pair struct {
+	cert []byte
+	key  []byte
+}
+
+// newPair generates a self-signed certificate for the common name
+func newPair(t *testing.T, name string, serial int64) pair {
+	t.Helper()
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &pk.PublicKey, pk)
+	if err != nil {
+		t.Fatalf("creating certificate: %s", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(pk)
+	if err != nil {
+		t.Fatalf("marshaling key: %s", err)
+	}
+
+	p := pair{
+		cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}
+
+	return p
+}
+
+// write writes the PEM files to the folder. Their modification time is set
+// to the specified time so a rotation is detected even when the files keep
+// the same size
+func write(t *testing.T, dir string, cert []byte, key []byte, mod time.Time) (string, string) {
+	t.Helper()
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	for name, data := range map[string][]byte{certFile: cert, keyFile: key} {
+		if err := os.WriteFile(name, data, 0600); err != nil {
+			t.Fatalf("writing %s: %s", name, err)
+		}
+		if err := os.Chtimes(name, mod, mod); err != nil {
+			t.Fatalf("changing time of %s: %s", name, err)
+		}
+	}
+
+	return certFile, keyFile
+}
+
+// =============================================================================
+
+func TestNew(t *testing.T) {
+	p1 := newPair(t, "one.example.com", 1)
+	p2 := newPair(t, "two.example.com", 2)
+
+	tests := []struct {
+		name string
+		cert []byte
+		key  []byte
+		ok   bool
+	}{
+		{"pair", p1.cert, p1.key, true},
+		{"mismatched key", p1.cert, p2.key, false},
+		{"not pem", []byte("cert"), []byte("key"), false},
+		{"key as cert", p1.key, p1.key, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certFile, keyFile := write(t, t.TempDir(), tt.cert, tt.key, time.Now())
+
+			s, err := New(certFile, keyFile)
+			if (err == nil) != tt.ok {
+				t.Fatalf("error = %v, want ok %t", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+
+			info := s.Info()
+			if info.Subject != "CN=one.example.com" || info.Serial != "1" {
+				t.Errorf("info = %+v, want the first certificate", info)
+			}
+
+			cert, err := s.GetCertificate(nil)
+			if err != nil || cert.Leaf == nil || cert.Leaf.Subject.CommonName != "one.example.com" {
+				t.Errorf("certificate = %v, %v, want the first certificate", cert, err)
+			}
+		})
+	}
+
+	t.Run("missing files", func(t *testing.T) {
+		dir := t.TempDir()
+		if _, err := New(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")); err == nil {
+			t.Error("missing files were loaded")
+		}
+	})
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+
+	p1 := newPair(t, "one.example.com", 1)
+	p2 := newPair(t, "two.example.com", 2)
+	p3 := newPair(t, "three.example.com", 3)
+
+	certFile, keyFile := write(t, dir, p1.cert, p1.key, now)
+
+	s, err := New(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("constructing store: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		cert     []byte
+		key      []byte
+		mod      time.Time
+		reloaded bool
+		ok       bool
+		serial   string
+	}{
+		{"unchanged", nil, nil, time.Time{}, false, true, "1"},
+		{"rotated", p2.cert, p2.key, now.Add(time.Second), true, true, "2"},
+		{"unchanged after rotation", nil, nil, time.Time{}, false, true, "2"},
+		{"halfway through rotation", p3.cert, p2.key, now.Add(2 * time.Second), false, false, "2"},
+		{"rotation completed", p3.cert, p3.key, now.Add(3 * time.Second), true, true, "3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cert != nil {
+				write(t, dir, tt.cert, tt.key, tt.mod)
+			}
+
+			reloaded, err := s.Reload()
+			if (err == nil) != tt.ok {
+				t.Fatalf("error = %v, want ok %t", err, tt.ok)
+			}
+			if reloaded != tt.reloaded {
+				t.Errorf("reloaded = %t, want %t", reloaded, tt.reloaded)
+			}
+
+			// A pair that can't be loaded keeps the current certificate.
+			if serial := s.Info().Serial; serial != tt.serial {
+				t.Errorf("serial = %s, want %s", serial, tt.serial)
+			}
+
+			cert, _ := s.GetCertificate(nil)
+			if serial := cert.Leaf.SerialNumber.String(); serial != tt.serial {
+				t.Errorf("serving serial = %s, want %s", serial, tt.serial)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+
+	p1 := newPair(t, "one.example.com", 1)
+	p2 := newPair(t, "two.example.com", 2)
+
+	certFile, keyFile := write(t, dir, p1.cert, p1.key, now)
+
+	s, err := New(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("constructing store: %s", err)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		done := make(chan struct{})
+		go func() {
+			s.Watch(context.Background(), 0, func(Info, error) {})
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("watch with a zero interval did not return")
+		}
+	})
+
+	t.Run("rotated", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		infos := make(chan Info, 1)
+		go s.Watch(ctx, 10*time.Millisecond, func(info Info, err error) {
+			// A tick between writing the two files fails and the next
+			// one loads the pair.
+			if err != nil {
+				return
+			}
+			infos <- info
+		})
+
+		write(t, dir, p2.cert, p2.key, now.Add(time.Second))
+
+		select {
+		case info := <-infos:
+			if info.Serial != "2" {
+				t.Errorf("serial = %s, want 2", info.Serial)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("rotated certificate was not reloaded")
+		}
+	})
+}
@@ -0,0 +1,553 @@
+// Package jwks provides a KeyLookup implementation for the auth package that
+// fetches the public keys from the JSON Web Key Set of a remote issuer
+package jwks
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Set of default values used when the Config leaves them empty
+const (
+	defaultMinRefresh = 30 * time.Second
+	defaultMaxRefresh = time.Hour
+	defaultTimeout    = 10 * time.Second
+)
+
+// maxBodySize limits the size of the key set document
+const maxBodySize = 1024 * 1024
+
+// ErrKeyNotFound is returned when the key set has no key for the kid
+var ErrKeyNotFound = errors.New("kid lookup failed")
+
+// Config represents the remote key set and how often it's fetched
+type Config struct {
+	// URL of the key set, like https://idp.example.com/.well-known/jwks.json
+	URL string
+
+	// Client used to fetch the key set. A client with a timeout is used
+	// when it's nil
+	Client *http.Client
+
+	// MinRefresh is the shortest time between two fetches. It limits how
+	// often a token with an unknown kid can trigger a fetch and how often
+	// a failed fetch is retried
+	MinRefresh time.Duration
+
+	// MaxRefresh is the longest the key set is cached. It's used when the
+	// response has no max-age and caps the max-age of the response
+	MaxRefresh time.Duration
+
+	// OnInvalidKey is called with the error of a signing key of a supported
+	// type that can't be used. The key is skipped so the other keys of the
+	// set can still be used
+	OnInvalidKey func(kid string, err error)
+}
+
+// Lookup holds the public keys fetched from a remote key set. The keys are
+// kept when a fetch fails so tokens can still be verified while the remote
+// is down
+type Lookup struct {
+	url        string
+	client     *http.Client
+	minRefresh time.Duration
+	maxRefresh time.Duration
+	invalidKey func(kid string, err error)
+
+	// fetchMu serializes the fetches so a burst of tokens with an unknown
+	// kid results in a single request.
+	fetchMu sync.Mutex
+
+	mu          sync.RWMutex
+	keys        map[string]string
+	etag        string
+	expires     time.Time
+	lastAttempt time.Time
+	onRetire    []func(kids []string)
+}
+
+// New constructs a Lookup for the key set at the configured URL. The key set
+// isn't fetched until Refresh is called or a key is looked up
+func New(cfg Config) (*Lookup, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || !u.IsAbs() {
+		return nil, fmt.Errorf("invalid key set url %q", cfg.URL)
+	}
+
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+
+	minRefresh := cfg.MinRefresh
+	if minRefresh <= 0 {
+		minRefresh = defaultMinRefresh
+	}
+
+	maxRefresh := cfg.MaxRefresh
+	if maxRefresh <= 0 {
+		maxRefresh = defaultMaxRefresh
+	}
+	if maxRefresh < minRefresh {
+		maxRefresh = minRefresh
+	}
+
+	invalidKey := cfg.OnInvalidKey
+	if invalidKey == nil {
+		invalidKey = func(kid string, err error) {}
+	}
+
+	l := Lookup{
+		url:        cfg.URL,
+		client:     client,
+		minRefresh: minRefresh,
+		maxRefresh: maxRefresh,
+		invalidKey: invalidKey,
+		keys:       make(map[string]string),
+	}
+
+	return &l, nil
+}
+
+// PrivateKey is part of the KeyLookup interface. A key set only holds public
+// keys so tokens can't be signed with it
+func (l *Lookup) PrivateKey(kid string) (string, error) {
+	return "", errors.New("private keys are not available from a remote key set")
+}
+
+// PublicKey returns the PEM encoded public key for the kid. The key set is
+// fetched again when the kid is unknown, like after the issuer rotated its
+// keys, unless it was fetched less than MinRefresh ago
+func (l *Lookup) PublicKey(kid string) (string, error) {
+	if pem, found := l.key(kid); found {
+		return pem, nil
+	}
+
+	if err := l.refetch(kid); err != nil {
+		return "", fmt.Errorf("fetching key set: %w", err)
+	}
+
+	if pem, found := l.key(kid); found {
+		return pem, nil
+	}
+
+	return "", ErrKeyNotFound
+}
+
+// KIDs returns the kids of the keys currently held sorted by name
+func (l *Lookup) KIDs() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	kids := make([]string, 0, len(l.keys))
+	for kid := range l.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	return kids
+}
+
+// Refresh fetches the key set. It reports if the set of kids changed. When
+// the key set can't be fetched the current keys are kept
+func (l *Lookup) Refresh(ctx context.Context) (bool, error) {
+	l.fetchMu.Lock()
+	changed, retired, err := l.fetch(ctx)
+	l.fetchMu.Unlock()
+
+	l.retire(retired)
+
+	return changed, err
+}
+
+// OnRetire registers a function called with the kids of the keys the remote
+// key set removed or replaced, like a cache of public keys that must forget
+// them. Register auth.InvalidateKeys so the tokens signed with the removed
+// keys are refused right away
+func (l *Lookup) OnRetire(fn func(kids []string)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.onRetire = append(l.onRetire, fn)
+}
+
+// Watch refreshes the key set in the background until the context is
+// canceled. The key set is fetched again when its cache lifetime runs out
+// and a failed fetch is retried after MinRefresh. The function is called
+// when the set of kids changed or a fetch failed
+func (l *Lookup) Watch(ctx context.Context, fn func(kids []string, err error)) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-timer.C:
+			changed, err := l.Refresh(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil || changed {
+				fn(l.KIDs(), err)
+			}
+
+			l.mu.RLock()
+			next := time.Until(l.expires)
+			if err != nil {
+				next = time.Until(l.lastAttempt.Add(l.minRefresh))
+			}
+			l.mu.RUnlock()
+
+			if next < 0 {
+				next = 0
+			}
+			timer.Reset(next)
+		}
+	}
+}
+
+// =============================================================================
+
+// key returns the PEM of the key for the kid
+func (l *Lookup) key(kid string) (string, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	pem, found := l.keys[kid]
+	return pem, found
+}
+
+// refetch fetches the key set for a kid that isn't known unless it was
+// fetched less than MinRefresh ago
+func (l *Lookup) refetch(kid string) error {
+	l.fetchMu.Lock()
+
+	// Another lookup may have fetched the key set while this one waited.
+	if _, found := l.key(kid); found {
+		l.fetchMu.Unlock()
+		return nil
+	}
+
+	l.mu.RLock()
+	recent := time.Since(l.lastAttempt) < l.minRefresh
+	l.mu.RUnlock()
+
+	if recent {
+		l.fetchMu.Unlock()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, retired, err := l.fetch(ctx)
+	l.fetchMu.Unlock()
+
+	l.retire(retired)
+
+	return err
+}
+
+// retire calls the functions registered with OnRetire. They are called
+// outside of the locks so they can use the lookup
+func (l *Lookup) retire(kids []string) {
+	if len(kids) == 0 {
+		return
+	}
+
+	l.mu.RLock()
+	onRetire := l.onRetire
+	l.mu.RUnlock()
+
+	for _, fn := range onRetire {
+		fn(kids)
+	}
+}
+
+// fetch requests the key set and replaces the keys when it changed. It
+// returns the kids of the keys that were removed. The caller must hold
+// fetchMu
+func (l *Lookup) fetch(ctx context.Context) (bool, []string, error) {
+	l.mu.Lock()
+	l.lastAttempt = time.Now()
+	etag := l.etag
+	l.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.url, nil)
+	if err != nil {
+		return false, nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", "application/jwk-set+json, application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return false, nil, fmt.Errorf("requesting key set: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		l.mu.Lock()
+		l.expires = time.Now().Add(l.lifetime(resp.Header))
+		l.mu.Unlock()
+		return false, nil, nil
+	default:
+		return false, nil, fmt.Errorf("requesting key set: unexpected status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return false, nil, fmt.Errorf("reading key set: %w", err)
+	}
+
+	keys, err := parseKeySet(body, l.invalidKey)
+	if err != nil {
+		return false, nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	changed := len(keys) != len(l.keys)
+	for kid, pem := range keys {
+		if l.keys[kid] != pem {
+			changed = true
+		}
+	}
+
+	// A key that's gone or was replaced under the same kid must not be
+	// trusted by the caches of the old key anymore.
+	var retired []string
+	for kid, pem := range l.keys {
+		if keys[kid] != pem {
+			retired = append(retired, kid)
+		}
+	}
+	sort.Strings(retired)
+
+	l.keys = keys
+	l.etag = resp.Header.Get("ETag")
+	l.expires = time.Now().Add(l.lifetime(resp.Header))
+
+	return changed, retired, nil
+}
+
+// lifetime returns how long the response can be cached according to its
+// Cache-Control and Age headers, bounded by MinRefresh and MaxRefresh
+func (l *Lookup) lifetime(header http.Header) time.Duration {
+	lifetime := l.maxRefresh
+
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+
+		switch strings.ToLower(name) {
+		case "no-cache", "no-store":
+			return l.minRefresh
+
+		case "max-age":
+			seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err != nil {
+				continue
+			}
+			lifetime = time.Duration(seconds) * time.Second
+
+			// The response may have spent part of its lifetime in a
+			// cache between us and the issuer.
+			if age, err := strconv.Atoi(header.Get("Age")); err == nil {
+				lifetime -= time.Duration(age) * time.Second
+			}
+		}
+	}
+
+	switch {
+	case lifetime < l.minRefresh:
+		return l.minRefresh
+	case lifetime > l.maxRefresh:
+		return l.maxRefresh
+	}
+
+	return lifetime
+}
+
+// =============================================================================
+
+// jwk represents the fields of a JSON Web Key used to verify signatures
+type jwk struct {
+	KeyType string `json:"kty"`
+	Use     string `json:"use"`
+	KeyID   string `json:"kid"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// parseKeySet converts the signing keys of the key set to PEM encoded public
+// keys keyed by kid. Keys of an unsupported type or meant for encryption are
+// skipped. Keys of a supported type that can't be parsed are skipped as well
+// and reported to the invalid function
+func parseKeySet(body []byte, invalid func(kid string, err error)) (map[string]string, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("decoding key set: %w", err)
+	}
+
+	keys := make(map[string]string)
+	for _, key := range set.Keys {
+		if key.KeyID == "" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+
+		var pub any
+		var err error
+
+		switch {
+		case key.KeyType == "RSA":
+			pub, err = parseRSA(key)
+		case key.KeyType == "EC" && key.Curve == "P-256":
+			pub, err = parseP256(key)
+		case key.KeyType == "OKP" && key.Curve == "Ed25519":
+			pub, err = parseEd25519(key)
+		default:
+			continue
+		}
+		if err != nil {
+			invalid(key.KeyID, fmt.Errorf("parsing key: %w", err))
+			continue
+		}
+
+		pem, err := encodePEM(pub)
+		if err != nil {
+			invalid(key.KeyID, fmt.Errorf("encoding key: %w", err))
+			continue
+		}
+		keys[key.KeyID] = pem
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("key set has no supported signing keys")
+	}
+
+	return keys, nil
+}
+
+// parseRSA constructs the RSA public key from its modulus and exponent
+func parseRSA(key jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(key.N)
+	if err != nil {
+		return nil, fmt.Errorf("decoding modulus: %w", err)
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(key.E)
+	if err != nil {
+		return nil, fmt.Errorf("decoding exponent: %w", err)
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid modulus or exponent")
+	}
+
+	pk := rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}
+
+	return &pk, nil
+}
+
+// parseP256 constructs the ECDSA public key from the coordinates of its
+// point on the P-256 curve
+func parseP256(key jwk) (*ecdsa.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(key.X)
+	if err != nil {
+		return nil, fmt.Errorf("decoding x: %w", err)
+	}
+
+	y, err := base64.RawURLEncoding.DecodeString(key.Y)
+	if err != nil {
+		return nil, fmt.Errorf("decoding y: %w", err)
+	}
+
+	if len(x) != 32 || len(y) != 32 {
+		return nil, errors.New("invalid coordinate length")
+	}
+
+	// The point is validated by parsing its uncompressed form.
+	point := append([]byte{0x04}, append(x, y...)...)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("invalid point: %w", err)
+	}
+
+	pk := ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+
+	return &pk, nil
+}
+
+// parseEd25519 constructs the Ed25519 public key
+func parseEd25519(key jwk) (ed25519.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(key.X)
+	if err != nil {
+		return nil, fmt.Errorf("decoding x: %w", err)
+	}
+
+	if len(x) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key length")
+	}
+
+	return ed25519.PublicKey(x), nil
+}
+
+// encodePEM encodes the public key the way the auth package expects it
+func encodePEM(pub any) (string, error) {
+	asn1Bytes, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("marshaling public key: %w", err)
+	}
+
+	block := pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: asn1Bytes,
+	}
+
+	var b bytes.Buffer
+	if err := pem.Encode(&b, &block); err != nil {
+		return "", fmt.Errorf("encoding public key: %w", err)
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,307 @@
+package jwks
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"github.com/theo-bot/service4.1-video/foundation/keystore"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// issuer serves a key set the test can change between requests
+type issuer struct {
+	mu           sync.Mutex
+	keys         []keystore.JWK
+	etag         string
+	cacheControl string
+	age          string
+	status       int
+	requests     int
+	ifNoneMatch  string
+}
+
+func (is *issuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	is.requests++
+	is.ifNoneMatch = r.Header.Get("If-None-Match")
+
+	if is.status != 0 {
+		w.WriteHeader(is.status)
+		return
+	}
+
+	if is.cacheControl != "" {
+		w.Header().Set("Cache-Control", is.cacheControl)
+	}
+	if is.age != "" {
+		w.Header().Set("Age", is.age)
+	}
+
+	if is.etag != "" {
+		w.Header().Set("ETag", is.etag)
+		if is.ifNoneMatch == is.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(keystore.JWKS{Keys: is.keys})
+}
+
+func (is *issuer) set(fn func(is *issuer)) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	fn(is)
+}
+
+func (is *issuer) count() int {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	return is.requests
+}
+
+func newJWK(t *testing.T, kid string) keystore.JWK {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+
+	jwk, err := keystore.NewJWK(kid, pub)
+	if err != nil {
+		t.Fatalf("constructing jwk: %s", err)
+	}
+
+	return jwk
+}
+
+func newLookup(t *testing.T, is *issuer) *Lookup {
+	t.Helper()
+
+	srv := httptest.NewServer(is)
+	t.Cleanup(srv.Close)
+
+	l, err := New(Config{URL: srv.URL, Client: srv.Client()})
+	if err != nil {
+		t.Fatalf("constructing lookup: %s", err)
+	}
+
+	return l
+}
+
+// =============================================================================
+
+func TestLookupETag(t *testing.T) {
+	is := issuer{keys: []keystore.JWK{newJWK(t, "k1")}, etag: `"v1"`}
+	l := newLookup(t, &is)
+
+	if changed, err := l.Refresh(context.Background()); err != nil || !changed {
+		t.Fatalf("first refresh = %t, %v, want changed", changed, err)
+	}
+
+	changed, err := l.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("second refresh: %s", err)
+	}
+	if changed {
+		t.Errorf("second refresh reported a change for a 304")
+	}
+	var ifNoneMatch string
+	is.set(func(is *issuer) { ifNoneMatch = is.ifNoneMatch })
+	if ifNoneMatch != `"v1"` {
+		t.Errorf("If-None-Match = %q, want %q", ifNoneMatch, `"v1"`)
+	}
+
+	if _, err := l.PublicKey("k1"); err != nil {
+		t.Errorf("key lost after a 304: %s", err)
+	}
+}
+
+func TestLookupLifetime(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		age          string
+		want         time.Duration
+	}{
+		{"no header", "", "", defaultMaxRefresh},
+		{"max-age", "public, max-age=600", "", 10 * time.Minute},
+		{"age", "max-age=600", "120", 8 * time.Minute},
+		{"no-cache", "no-cache", "", defaultMinRefresh},
+		{"below min", "max-age=10", "", defaultMinRefresh},
+		{"older than max-age", "max-age=600", "900", defaultMinRefresh},
+		{"above max", "max-age=86400", "", defaultMaxRefresh},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := issuer{keys: []keystore.JWK{newJWK(t, "k1")}, cacheControl: tt.cacheControl, age: tt.age}
+			l := newLookup(t, &is)
+
+			before := time.Now()
+			if _, err := l.Refresh(context.Background()); err != nil {
+				t.Fatalf("refresh: %s", err)
+			}
+
+			l.mu.RLock()
+			got := l.expires.Sub(before)
+			l.mu.RUnlock()
+
+			if got < tt.want || got > tt.want+time.Second {
+				t.Errorf("lifetime = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupUnknownKID(t *testing.T) {
+	is := issuer{keys: []keystore.JWK{newJWK(t, "k1")}}
+	l := newLookup(t, &is)
+
+	if _, err := l.PublicKey("k1"); err != nil {
+		t.Fatalf("first lookup: %s", err)
+	}
+
+	// The key set was just fetched so an unknown kid doesn't trigger
+	// another fetch.
+	for i := 0; i < 3; i++ {
+		if _, err := l.PublicKey("k2"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("lookup of unknown kid = %v, want ErrKeyNotFound", err)
+		}
+	}
+	if n := is.count(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+
+	// Once MinRefresh passed the unknown kid triggers a fetch finding the
+	// key the issuer added.
+	is.set(func(is *issuer) { is.keys = append(is.keys, newJWK(t, "k2")) })
+	l.mu.Lock()
+	l.lastAttempt = l.lastAttempt.Add(-l.minRefresh)
+	l.mu.Unlock()
+
+	if _, err := l.PublicKey("k2"); err != nil {
+		t.Fatalf("lookup after the issuer added the key: %s", err)
+	}
+	if n := is.count(); n != 2 {
+		t.Errorf("requests = %d, want 2", n)
+	}
+}
+
+func TestLookupStale(t *testing.T) {
+	is := issuer{keys: []keystore.JWK{newJWK(t, "k1")}}
+	l := newLookup(t, &is)
+
+	if _, err := l.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %s", err)
+	}
+
+	is.set(func(is *issuer) { is.status = http.StatusServiceUnavailable })
+
+	if _, err := l.Refresh(context.Background()); err == nil {
+		t.Fatalf("refresh of a failing issuer succeeded")
+	}
+
+	if _, err := l.PublicKey("k1"); err != nil {
+		t.Errorf("stale key not served: %s", err)
+	}
+	if kids := l.KIDs(); !reflect.DeepEqual(kids, []string{"k1"}) {
+		t.Errorf("kids = %v, want [k1]", kids)
+	}
+}
+
+func TestLookupRetire(t *testing.T) {
+	k1, k2, k3 := newJWK(t, "k1"), newJWK(t, "k2"), newJWK(t, "k3")
+
+	is := issuer{keys: []keystore.JWK{k1, k2, k3}}
+	l := newLookup(t, &is)
+
+	var retired [][]string
+	l.OnRetire(func(kids []string) {
+		retired = append(retired, kids)
+
+		// The lookup can be used from the function.
+		l.KIDs()
+	})
+
+	if _, err := l.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %s", err)
+	}
+	if len(retired) != 0 {
+		t.Fatalf("retired on the first fetch: %v", retired)
+	}
+
+	// k2 is removed and k3 is replaced by another key with the same kid.
+	is.set(func(is *issuer) { is.keys = []keystore.JWK{k1, newJWK(t, "k3")} })
+
+	if _, err := l.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %s", err)
+	}
+
+	if want := [][]string{{"k2", "k3"}}; !reflect.DeepEqual(retired, want) {
+		t.Errorf("retired = %v, want %v", retired, want)
+	}
+
+	if _, err := l.PublicKey("k2"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("lookup of removed kid = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestLookupInvalidKey(t *testing.T) {
+	bad := newJWK(t, "bad")
+	bad.X = "not-base64!"
+
+	short := newJWK(t, "short")
+	short.X = short.X[:10]
+
+	is := issuer{keys: []keystore.JWK{newJWK(t, "k1"), bad, short}}
+
+	srv := httptest.NewServer(&is)
+	t.Cleanup(srv.Close)
+
+	invalid := make(map[string]error)
+	cfg := Config{
+		URL:          srv.URL,
+		Client:       srv.Client(),
+		OnInvalidKey: func(kid string, err error) { invalid[kid] = err },
+	}
+
+	l, err := New(cfg)
+	if err != nil {
+		t.Fatalf("constructing lookup: %s", err)
+	}
+
+	// The malformed keys are skipped and the valid key is still used.
+	if _, err := l.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %s", err)
+	}
+	if kids := l.KIDs(); !reflect.DeepEqual(kids, []string{"k1"}) {
+		t.Errorf("kids = %v, want [k1]", kids)
+	}
+	if len(invalid) != 2 || invalid["bad"] == nil || invalid["short"] == nil {
+		t.Errorf("invalid keys = %v, want bad and short", invalid)
+	}
+
+	// A key set without a single usable key is refused so the current keys
+	// are kept.
+	is.set(func(is *issuer) { is.keys = []keystore.JWK{bad} })
+
+	if _, err := l.Refresh(context.Background()); err == nil {
+		t.Fatalf("refresh of a key set without usable keys succeeded")
+	}
+	if kids := l.KIDs(); !reflect.DeepEqual(kids, []string{"k1"}) {
+		t.Errorf("kids = %v, want [k1]", kids)
+	}
+}
@@ -0,0 +1,100 @@
+package keystore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Set of signing algorithms supported for the keys in the keystore. The
+// algorithm is derived from the type of the key
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// Algorithms lists the signing algorithms supported for the keys
+var Algorithms = []string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA}
+
+// ParsePrivateKeyPEM parses a PEM encoded private key. RSA keys are accepted
+// in PKCS#1 form, ECDSA P-256 keys in SEC1 form and all three key types in
+// PKCS#8 form
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var key any
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", block.Type, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	if _, err := Algorithm(signer.Public()); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+// ParsePublicKeyPEM parses a PEM encoded public key in PKIX form
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+
+	if _, err := Algorithm(pub); err != nil {
+		return nil, err
+	}
+
+	return pub, nil
+}
+
+// Algorithm returns the signing algorithm used with the public key
+func Algorithm(pub crypto.PublicKey) (string, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return AlgorithmRS256, nil
+
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return "", fmt.Errorf("unsupported curve %s, only P-256 is supported", pub.Curve.Params().Name)
+		}
+		return AlgorithmES256, nil
+
+	case ed25519.PublicKey:
+		return AlgorithmEdDSA, nil
+	}
+
+	return "", fmt.Errorf("unsupported key type %T", pub)
+}
@@ -2,27 +2,45 @@ package keystore
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"github.com/golang-jwt/jwt/v4"
 	"io"
 	"io/fs"
+	"math/big"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
-// PrivateKey represents ey information
+// ErrKeyNotFound is returned when the keystore has no key for the kid
+var ErrKeyNotFound = errors.New("kid lookup failed")
+
+// PrivateKey represents ey information. The key is an RSA, ECDSA P-256 or
+// Ed25519 key
 type PrivateKey struct {
-	PK  *rsa.PrivateKey
+	PK  crypto.Signer
 	PEM []byte
 }
 
 // KeyStore represents an in memory store impplementation of the
-// KeyLookup interface for use with the auth package
+// KeyLookup interface for use with the auth package. Keys can be added and
+// retired while the store is in use by reloading the folder it was read from
 type KeyStore struct {
-	store map[string]PrivateKey
+	fsys fs.FS
+
+	mu        sync.RWMutex
+	store     map[string]PrivateKey
+	activeKID string
+	onRetire  []func(kids []string)
 }
 
 // New constructs an empty KeyStore ready for use
@@ -44,58 +62,136 @@ func NewMap(store map[string]PrivateKey) *KeyStore {
 // Example: keystore.NewFS(os.DirFS("zrf/keys/")
 // Example: /zarf/keys/3f3a69ca-b2cf-4762-8f56-99c992cd06ed.pem
 func NewFS(fsys fs.FS) (*KeyStore, error) {
-	ks := New()
+	store, err := readFS(fsys)
+	if err != nil {
+		return nil, err
+	}
 
-	fn := func(fileName string, dirEntry fs.DirEntry, err error) error {
-		if err != nil {
-			return fmt.Errorf("walkdir failure: %w", err)
-		}
+	ks := KeyStore{
+		fsys:  fsys,
+		store: store,
+	}
 
-		if dirEntry.IsDir() {
-			return nil
-		}
+	return &ks, nil
+}
 
-		file, err := fsys.Open(fileName)
-		if err != nil {
-			return fmt.Errorf("opening key file: %w", err)
-		}
-		defer file.Close()
+// Reload reads the folder the keystore was constructed from again. Keys
+// with a new file are added and keys whose file is gone are retired. A key
+// whose file changed is reported as both retired and added. The current
+// keys are kept when the folder can't be read
+func (ks *KeyStore) Reload() (added []string, retired []string, err error) {
+	if ks.fsys == nil {
+		return nil, nil, errors.New("keystore is not backed by a folder")
+	}
 
-		// limit PEM filesize to 1 megabyte. This should be reasonable for
-		// almost any PEM file and prevents shenanigans like linking the file
-		// to /dev/random of something like that
-		pem, err := io.ReadAll((io.LimitReader(file, 1024*1024)))
-		if err != nil {
-			return fmt.Errorf("reading auth private key: %w", err)
+	store, err := readFS(ks.fsys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ks.mu.Lock()
+	for kid, key := range ks.store {
+		if newKey, exists := store[kid]; !exists || !bytes.Equal(newKey.PEM, key.PEM) {
+			retired = append(retired, kid)
 		}
+	}
+	for kid, key := range store {
+		if oldKey, exists := ks.store[kid]; !exists || !bytes.Equal(oldKey.PEM, key.PEM) {
+			added = append(added, kid)
+		}
+	}
+	ks.store = store
+	onRetire := ks.onRetire
+	ks.mu.Unlock()
 
-		pk, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
-		if err != nil {
-			return fmt.Errorf("parting auth private key: %w", err)
+	sort.Strings(added)
+	sort.Strings(retired)
+
+	// The functions are called outside of the lock so they can use the
+	// keystore.
+	if len(retired) > 0 {
+		for _, fn := range onRetire {
+			fn(retired)
 		}
+	}
 
-		key := PrivateKey{
-			PK:  pk,
-			PEM: pem,
+	return added, retired, nil
+}
+
+// Watch reloads the folder at the specified interval until the context is
+// canceled. The function is called with the result of every reload that
+// changed the keys or failed
+func (ks *KeyStore) Watch(ctx context.Context, interval time.Duration, fn func(added []string, retired []string, err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			added, retired, err := ks.Reload()
+			if err != nil || len(added) > 0 || len(retired) > 0 {
+				fn(added, retired, err)
+			}
 		}
+	}
+}
 
-		ks.store[strings.TrimSuffix(dirEntry.Name(), ".pem")] = key
+// OnRetire registers a function called with the kids of the keys a reload
+// retired, like a cache of public keys that must forget them
+func (ks *KeyStore) OnRetire(fn func(kids []string)) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
 
-		return nil
+	ks.onRetire = append(ks.onRetire, fn)
+}
+
+// ActiveKID returns the kid of the key new tokens are signed with
+func (ks *KeyStore) ActiveKID() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return ks.activeKID
+}
+
+// SetActiveKID changes the key new tokens are signed with. The key must be
+// in the keystore
+func (ks *KeyStore) SetActiveKID(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, exists := ks.store[kid]; !exists {
+		return fmt.Errorf("kid %q not found", kid)
 	}
+	ks.activeKID = kid
 
-	if err := fs.WalkDir(fsys, ".", fn); err != nil {
-		return nil, fmt.Errorf("walking directory: %w", err)
+	return nil
+}
+
+// KIDs returns the kids of the keys in the keystore sorted by name
+func (ks *KeyStore) KIDs() []string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	kids := make([]string, 0, len(ks.store))
+	for kid := range ks.store {
+		kids = append(kids, kid)
 	}
+	sort.Strings(kids)
 
-	return ks, nil
+	return kids
 }
 
 // PrivateKey searches the keystore for a given kid an retern the private key
 func (ks *KeyStore) PrivateKey(kid string) (string, error) {
+	ks.mu.RLock()
 	privateKey, found := ks.store[kid]
+	ks.mu.RUnlock()
+
 	if !found {
-		return "", errors.New("kid lookup failed")
+		return "", ErrKeyNotFound
 	}
 
 	return string(privateKey.PEM), nil
@@ -103,12 +199,15 @@ func (ks *KeyStore) PrivateKey(kid string) (string, error) {
 
 // PblicKey searches the keystore for a given kid and returns the public key
 func (ks *KeyStore) PublicKey(kid string) (string, error) {
+	ks.mu.RLock()
 	privateKey, found := ks.store[kid]
+	ks.mu.RUnlock()
+
 	if !found {
-		return "", errors.New("kid lookup failed")
+		return "", ErrKeyNotFound
 	}
 
-	asn1Bytes, err := x509.MarshalPKIXPublicKey(&privateKey.PK.PublicKey)
+	asn1Bytes, err := x509.MarshalPKIXPublicKey(privateKey.PK.Public())
 	if err != nil {
 		return "", fmt.Errorf("marshaling public key: %w", err)
 	}
@@ -125,3 +224,141 @@ func (ks *KeyStore) PublicKey(kid string) (string, error) {
 
 	return b.String(), nil
 }
+
+// =============================================================================
+
+// JWK represents the public part of a key as a JSON Web Key as defined by
+// RFC 7517. RSA keys carry the modulus and exponent, ECDSA keys the curve
+// and point and Ed25519 keys the curve and public key as defined by RFC 8037
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS represents a JSON Web Key Set holding the public keys tokens can be
+// verified with
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the keystore as a JSON Web Key Set sorted
+// by kid
+func (ks *KeyStore) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	jwks := JWKS{
+		Keys: make([]JWK, 0, len(ks.store)),
+	}
+
+	for kid, privateKey := range ks.store {
+		jwk, err := NewJWK(kid, privateKey.PK.Public())
+		if err != nil {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID
+	})
+
+	return jwks
+}
+
+// NewJWK constructs the JSON Web Key for the public key
+func NewJWK(kid string, pub crypto.PublicKey) (JWK, error) {
+	alg, err := Algorithm(pub)
+	if err != nil {
+		return JWK{}, err
+	}
+
+	jwk := JWK{
+		Use:       "sig",
+		Algorithm: alg,
+		KeyID:     kid,
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+
+	case *ecdsa.PublicKey:
+		// The coordinates are padded to the size of the curve.
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = b64(pub)
+	}
+
+	return jwk, nil
+}
+
+// =============================================================================
+
+// readFS reads the PEM files rooted inside of the directory keyed by the
+// name of the file without the extension
+func readFS(fsys fs.FS) (map[string]PrivateKey, error) {
+	store := make(map[string]PrivateKey)
+
+	fn := func(fileName string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("walkdir failure: %w", err)
+		}
+
+		if dirEntry.IsDir() {
+			return nil
+		}
+
+		file, err := fsys.Open(fileName)
+		if err != nil {
+			return fmt.Errorf("opening key file: %w", err)
+		}
+		defer file.Close()
+
+		// limit PEM filesize to 1 megabyte. This should be reasonable for
+		// almost any PEM file and prevents shenanigans like linking the file
+		// to /dev/random of something like that
+		pem, err := io.ReadAll((io.LimitReader(file, 1024*1024)))
+		if err != nil {
+			return fmt.Errorf("reading auth private key: %w", err)
+		}
+
+		pk, err := ParsePrivateKeyPEM(pem)
+		if err != nil {
+			return fmt.Errorf("parting auth private key: %w", err)
+		}
+
+		key := PrivateKey{
+			PK:  pk,
+			PEM: pem,
+		}
+
+		store[strings.TrimSuffix(dirEntry.Name(), ".pem")] = key
+
+		return nil
+	}
+
+	if err := fs.WalkDir(fsys, ".", fn); err != nil {
+		return nil, fmt.Errorf("walking directory: %w", err)
+	}
+
+	return store, nil
+}
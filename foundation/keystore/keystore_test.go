@@ -0,0 +1,152 @@
+package keystore
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// writeKey writes a new Ed25519 key for the kid to the folder. The file is
+// renamed into place so a reload never reads half of it
+func writeKey(t *testing.T, dir string, kid string) {
+	t.Helper()
+
+	_, pk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(pk)
+	if err != nil {
+		t.Fatalf("marshaling key: %s", err)
+	}
+
+	tmp := filepath.Join(t.TempDir(), kid+".pem")
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("writing key: %s", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, kid+".pem")); err != nil {
+		t.Fatalf("moving key: %s", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "k1")
+	writeKey(t, dir, "k2")
+
+	ks, err := NewFS(os.DirFS(dir))
+	if err != nil {
+		t.Fatalf("constructing keystore: %s", err)
+	}
+
+	var retired [][]string
+	ks.OnRetire(func(kids []string) {
+		retired = append(retired, kids)
+
+		// The keystore can be used from the function and no longer has the
+		// removed key.
+		if _, err := ks.PublicKey("k1"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("removed key still found: %v", err)
+		}
+	})
+
+	if err := os.Remove(filepath.Join(dir, "k1.pem")); err != nil {
+		t.Fatalf("removing key: %s", err)
+	}
+	writeKey(t, dir, "k2")
+	writeKey(t, dir, "k3")
+
+	added, gone, err := ks.Reload()
+	if err != nil {
+		t.Fatalf("reloading: %s", err)
+	}
+
+	if want := []string{"k2", "k3"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %v, want %v", added, want)
+	}
+	if want := []string{"k1", "k2"}; !reflect.DeepEqual(gone, want) {
+		t.Errorf("retired = %v, want %v", gone, want)
+	}
+	if want := [][]string{{"k1", "k2"}}; !reflect.DeepEqual(retired, want) {
+		t.Errorf("OnRetire calls = %v, want %v", retired, want)
+	}
+	if want := []string{"k2", "k3"}; !reflect.DeepEqual(ks.KIDs(), want) {
+		t.Errorf("kids = %v, want %v", ks.KIDs(), want)
+	}
+}
+
+// TestReloadConcurrent is meant to be run with the race detector. Keys are
+// looked up while the folder is reloaded and the retired keys are reported.
+func TestReloadConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "k1")
+	writeKey(t, dir, "k2")
+
+	ks, err := NewFS(os.DirFS(dir))
+	if err != nil {
+		t.Fatalf("constructing keystore: %s", err)
+	}
+	if err := ks.SetActiveKID("k1"); err != nil {
+		t.Fatalf("setting active kid: %s", err)
+	}
+
+	var mu sync.Mutex
+	var retired int
+	ks.OnRetire(func(kids []string) {
+		mu.Lock()
+		retired += len(kids)
+		mu.Unlock()
+
+		ks.KIDs()
+	})
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				for _, kid := range []string{"k1", "k2", ks.ActiveKID()} {
+					if _, err := ks.PrivateKey(kid); err != nil && !errors.Is(err, ErrKeyNotFound) {
+						t.Errorf("private key %s: %s", kid, err)
+					}
+					if _, err := ks.PublicKey(kid); err != nil && !errors.Is(err, ErrKeyNotFound) {
+						t.Errorf("public key %s: %s", kid, err)
+					}
+				}
+				ks.JWKS()
+			}
+		}()
+	}
+
+	const reloads = 20
+	for i := 0; i < reloads; i++ {
+		writeKey(t, dir, "k2")
+		if _, _, err := ks.Reload(); err != nil {
+			t.Errorf("reloading: %s", err)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	if retired != reloads {
+		t.Errorf("retired keys = %d, want %d", retired, reloads)
+	}
+}
@@ -0,0 +1,151 @@
+// Package tracer provides support for OpenTelemetry tracing
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+// Set of exporters the tracer can send spans to
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOTLP   = "otlp"
+)
+
+// Config represents the information required to initialize tracing
+type Config struct {
+	ServiceName string
+	Build       string
+
+	// Exporter is one of none, stdout, file or otlp. When none is used the
+	// spans are still created so trace and span ids are available, but
+	// they are never exported. Traces started by this service are not
+	// sampled while the caller's sampling decision is still propagated
+	Exporter string
+
+	// Endpoint is the host:port of the OTLP/HTTP collector, Tempo for
+	// instance, used by the otlp exporter
+	Endpoint string
+	Insecure bool
+
+	// FilePath is the file the file exporter appends the spans to
+	FilePath string
+
+	// Probability is the fraction of new traces that are sampled. Traces
+	// started by a caller follow the caller's sampling decision
+	Probability float64
+}
+
+// New constructs a TracerProvider for the configured exporter and registers
+// it as the global provider. The provider must be shut down to flush the
+// spans that have not been exported yet
+func New(cfg Config) (*sdktrace.TracerProvider, error) {
+	res := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+		semconv.ServiceVersion(cfg.Build),
+	)
+
+	sampler := sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Probability))
+
+	var opts []sdktrace.TracerProviderOption
+
+	switch cfg.Exporter {
+	case ExporterNone, "":
+		sampler = sdktrace.ParentBased(sdktrace.NeverSample())
+
+	case ExporterStdout:
+		exporter, err := newWriterExporter(os.Stdout)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+
+	case ExporterFile:
+		file, err := os.OpenFile(cfg.FilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("opening trace file: %w", err)
+		}
+
+		exporter, err := newWriterExporter(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(&fileExporter{SpanExporter: exporter, file: file}))
+
+	case ExporterOTLP:
+		clientOpts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(cfg.Endpoint),
+		}
+		if cfg.Insecure {
+			clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+		}
+
+		exporter, err := otlptracehttp.New(context.Background(), clientOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("creating otlp exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", cfg.Exporter)
+	}
+
+	opts = append(opts,
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sampler),
+	)
+
+	tp := sdktrace.NewTracerProvider(opts...)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return tp, nil
+}
+
+// =============================================================================
+
+// newWriterExporter constructs an exporter writing each span as a JSON
+// document to the writer
+func newWriterExporter(w io.Writer) (sdktrace.SpanExporter, error) {
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, fmt.Errorf("creating writer exporter: %w", err)
+	}
+
+	return exporter, nil
+}
+
+// fileExporter closes the file the spans are written to when the exporter
+// is shut down
+type fileExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+// Shutdown shuts down the exporter and closes the file
+func (fe *fileExporter) Shutdown(ctx context.Context) error {
+	if err := fe.SpanExporter.Shutdown(ctx); err != nil {
+		fe.file.Close()
+		return err
+	}
+
+	return fe.file.Close()
+}
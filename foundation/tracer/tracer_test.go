@@ -0,0 +1,59 @@
+package tracer
+
+import (
+	"context"
+	"github.com/theo-bot/service4.1-video/foundation/web"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExporterNone(t *testing.T) {
+	tp, err := New(Config{ServiceName: "test", Exporter: ExporterNone})
+	if err != nil {
+		t.Fatalf("constructing provider: %s", err)
+	}
+	defer tp.Shutdown(context.Background())
+
+	app := web.NewApp(nil, tp.Tracer("test"))
+	app.Handle(http.MethodGet, "/", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+
+	tests := []struct {
+		name        string
+		traceparent string
+		flags       string
+	}{
+		{"sampled caller", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "01"},
+		{"unsampled caller", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00", "00"},
+		{"new trace", "", "00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.traceparent != "" {
+				r.Header.Set("traceparent", tt.traceparent)
+			}
+
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			// The caller's sampling decision is echoed and propagated even
+			// though nothing is exported.
+			parts := strings.Split(w.Header().Get("traceparent"), "-")
+			if len(parts) != 4 {
+				t.Fatalf("traceparent = %q", w.Header().Get("traceparent"))
+			}
+			if parts[3] != tt.flags {
+				t.Errorf("flags = %s, want %s", parts[3], tt.flags)
+			}
+			if tt.traceparent != "" && parts[1] != "0af7651916cd43dd8448eb211c80319c" {
+				t.Errorf("trace id = %s, want the caller's", parts[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SetETag sets the version of the resource the response represents, like
+// the resource's DateUpdated. Respond uses it to build the entity tag
+// instead of hashing the response body
+func SetETag(ctx context.Context, version string) {
+	v, ok := ctx.Value(key).(*Values)
+	if !ok {
+		return
+	}
+	v.etag = version
+}
+
+// SetLastModified sets the time the resource the response represents was
+// last modified. Respond sends it in the Last-Modified header and uses it
+// to answer If-Modified-Since requests
+func SetLastModified(ctx context.Context, t time.Time) {
+	v, ok := ctx.Value(key).(*Values)
+	if !ok {
+		return
+	}
+	v.lastModified = t
+}
+
+// CacheControl sets the Cache-Control header Respond sends with successful
+// responses of the routes it wraps. Pass it to Handle or Group like any
+// other middleware
+func CacheControl(value string) Middleware {
+	m := func(handler Handler) Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			if v, ok := ctx.Value(key).(*Values); ok {
+				v.cacheControl = value
+			}
+
+			return handler(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return m
+}
+
+// =============================================================================
+
+// setCacheHeaders sets the Cache-Control header configured for the route
+func setCacheHeaders(ctx context.Context, w http.ResponseWriter) {
+	v, ok := ctx.Value(key).(*Values)
+	if !ok || v.cacheControl == "" {
+		return
+	}
+
+	w.Header().Set("Cache-Control", v.cacheControl)
+}
+
+// notModified sets the validators of a successful GET response and checks
+// them against the conditional headers of the request. It returns true when
+// the copy the client holds is still current
+func notModified(ctx context.Context, w http.ResponseWriter, body []byte, mediaType string) bool {
+	v, ok := ctx.Value(key).(*Values)
+	if !ok || v.request == nil {
+		return false
+	}
+
+	r := v.request
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	etag := entityTag(v.etag, body, mediaType)
+	w.Header().Set("ETag", etag)
+
+	if !v.lastModified.IsZero() {
+		w.Header().Set("Last-Modified", v.lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-Modified-Since is ignored when If-None-Match is present since the
+	// entity tag is the more accurate validator.
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, etag)
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !v.lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+
+		// The header only has a precision of seconds.
+		return !v.lastModified.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
+
+// entityTag builds a strong entity tag for the response. The version set by
+// the handler is used when available, otherwise the body is hashed. Entity
+// tags built from a version include the media type since every
+// representation of the resource needs its own strong tag
+func entityTag(version string, body []byte, mediaType string) string {
+	if version == "" {
+		sum := sha256.Sum256(body)
+		return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	}
+
+	if mediaType != MediaTypeJSON {
+		_, subtype, _ := strings.Cut(mediaType, "/")
+		version += "-" + subtype
+	}
+
+	return `"` + strings.ReplaceAll(version, `"`, "") + `"`
+}
+
+// etagMatch performs the weak comparison If-None-Match requires between the
+// list of entity tags in the header and the entity tag of the response
+func etagMatch(header string, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
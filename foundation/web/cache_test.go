@@ -0,0 +1,115 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+	updated := time.Date(2024, 3, 1, 10, 30, 15, 500, time.UTC)
+	lastModified := updated.Format(http.TimeFormat)
+
+	type user struct {
+		Name string `json:"name"`
+	}
+
+	app := NewApp(nil, nil)
+
+	app.Handle(http.MethodGet, "/hashed", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return Respond(ctx, w, user{Name: "bill"}, http.StatusOK)
+	}, CacheControl("private, no-cache"))
+
+	versioned := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		SetETag(ctx, "v7")
+		SetLastModified(ctx, updated)
+		return Respond(ctx, w, user{Name: "bill"}, http.StatusOK)
+	}
+	app.Handle(http.MethodGet, "/versioned", versioned, CacheControl("private, no-cache"))
+	app.Handle(http.MethodPost, "/versioned", versioned, CacheControl("private, no-cache"))
+
+	app.Handle(http.MethodGet, "/missing", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return Respond(ctx, w, user{}, http.StatusNotFound)
+	}, CacheControl("private, no-cache"))
+
+	// The entity tag of the hashed body.
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hashed", nil))
+	hashed := w.Header().Get("ETag")
+	if len(hashed) < 3 || hashed[0] != '"' {
+		t.Fatalf("hashed entity tag = %q, want a strong entity tag", hashed)
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		header       map[string]string
+		status       int
+		etag         string
+		cacheControl string
+	}{
+		{"hashed", http.MethodGet, "/hashed", nil, http.StatusOK, hashed, "private, no-cache"},
+		{"hashed match", http.MethodGet, "/hashed", map[string]string{"If-None-Match": hashed}, http.StatusNotModified, hashed, "private, no-cache"},
+		{"hashed other", http.MethodGet, "/hashed", map[string]string{"If-None-Match": `"other"`}, http.StatusOK, hashed, "private, no-cache"},
+		{"versioned", http.MethodGet, "/versioned", nil, http.StatusOK, `"v7"`, "private, no-cache"},
+		{"versioned match", http.MethodGet, "/versioned", map[string]string{"If-None-Match": `"v7"`}, http.StatusNotModified, `"v7"`, "private, no-cache"},
+		{"weak comparison", http.MethodGet, "/versioned", map[string]string{"If-None-Match": `W/"v7"`}, http.StatusNotModified, `"v7"`, "private, no-cache"},
+		{"list", http.MethodGet, "/versioned", map[string]string{"If-None-Match": `"v6", W/"v7"`}, http.StatusNotModified, `"v7"`, "private, no-cache"},
+		{"any", http.MethodGet, "/versioned", map[string]string{"If-None-Match": "*"}, http.StatusNotModified, `"v7"`, "private, no-cache"},
+		{"stale", http.MethodGet, "/versioned", map[string]string{"If-None-Match": `"v6"`}, http.StatusOK, `"v7"`, "private, no-cache"},
+		{"representation", http.MethodGet, "/versioned", map[string]string{"Accept": MediaTypeXML, "If-None-Match": `"v7"`}, http.StatusOK, `"v7-xml"`, "private, no-cache"},
+		{"modified since same second", http.MethodGet, "/versioned", map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified, `"v7"`, "private, no-cache"},
+		{"modified since later", http.MethodGet, "/versioned", map[string]string{"If-Modified-Since": updated.Add(time.Hour).Format(http.TimeFormat)}, http.StatusNotModified, `"v7"`, "private, no-cache"},
+		{"modified since earlier", http.MethodGet, "/versioned", map[string]string{"If-Modified-Since": updated.Add(-time.Second).Format(http.TimeFormat)}, http.StatusOK, `"v7"`, "private, no-cache"},
+		{"modified since invalid", http.MethodGet, "/versioned", map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK, `"v7"`, "private, no-cache"},
+		{"entity tag wins", http.MethodGet, "/versioned", map[string]string{"If-None-Match": `"v6"`, "If-Modified-Since": lastModified}, http.StatusOK, `"v7"`, "private, no-cache"},
+		{"not a get", http.MethodPost, "/versioned", map[string]string{"If-None-Match": `"v7"`}, http.StatusOK, "", "private, no-cache"},
+		{"error", http.MethodGet, "/missing", nil, http.StatusNotFound, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status code = %d, want %d", w.Code, tt.status)
+			}
+			if etag := w.Header().Get("ETag"); etag != tt.etag {
+				t.Errorf("etag = %q, want %q", etag, tt.etag)
+			}
+			if cc := w.Header().Get("Cache-Control"); cc != tt.cacheControl {
+				t.Errorf("cache control = %q, want %q", cc, tt.cacheControl)
+			}
+
+			switch tt.status {
+			case http.StatusNotModified:
+				if w.Body.Len() != 0 {
+					t.Errorf("body = %q, want none", w.Body.String())
+				}
+				if ct := w.Header().Get("Content-Type"); ct != "" {
+					t.Errorf("content type = %q, want none", ct)
+				}
+
+			case http.StatusOK:
+				if w.Body.Len() == 0 {
+					t.Error("no body")
+				}
+			}
+
+			if tt.path == "/versioned" && tt.method == http.MethodGet {
+				if lm := w.Header().Get("Last-Modified"); lm != lastModified {
+					t.Errorf("last modified = %q, want %q", lm, lastModified)
+				}
+			}
+		})
+	}
+}
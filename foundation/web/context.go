@@ -2,6 +2,8 @@ package web
 
 import (
 	"context"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
 	"time"
 )
 
@@ -11,9 +13,32 @@ const key ctxKey = 1
 
 // Value represent state for each request
 type Values struct {
-	TraceID    string
-	Now        time.Time
-	StatusCode int
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	TraceFlags   string
+	TraceState   string
+	Tracer       trace.Tracer
+	Now          time.Time
+	StatusCode   int
+
+	// DeprecatedRoute is set to the route, like GET /v1/users, when the
+	// request was handled by a deprecated route
+	DeprecatedRoute string
+
+	// route is the method and path pattern of the route handling the
+	// request, like GET /v1/users/:id
+	route string
+
+	// request provides the response helpers access to the request headers
+	// so they can perform content negotiation
+	request *http.Request
+
+	// Cache validators and directives for the response set by the handler
+	// and the route
+	etag         string
+	lastModified time.Time
+	cacheControl string
 }
 
 // GetValues returns the values from the context
@@ -21,7 +46,8 @@ func GetValues(ctx context.Context) *Values {
 	v, ok := ctx.Value(key).(*Values)
 	if !ok {
 		return &Values{
-			TraceID: "00000000-0000-0000-0000-00000000",
+			TraceID: zeroTraceID,
+			SpanID:  zeroSpanID,
 			Now:     time.Now(),
 		}
 	}
@@ -33,12 +59,22 @@ func GetValues(ctx context.Context) *Values {
 func GetTraceID(ctx context.Context) string {
 	v, ok := ctx.Value(key).(*Values)
 	if !ok {
-		return "00000000-0000-0000-0000-00000000"
+		return zeroTraceID
 	}
 
 	return v.TraceID
 }
 
+// GetSpanID returns the span id of the request from the context
+func GetSpanID(ctx context.Context) string {
+	v, ok := ctx.Value(key).(*Values)
+	if !ok {
+		return zeroSpanID
+	}
+
+	return v.SpanID
+}
+
 // GetTime returns the time from the context
 func GetTime(ctx context.Context) time.Time {
 	v, ok := ctx.Value(key).(*Values)
@@ -56,3 +92,12 @@ func SetStatusCode(ctx context.Context, statusCode int) {
 	}
 	v.StatusCode = statusCode
 }
+
+// getRequest returns the request being handled from the context
+func getRequest(ctx context.Context) *http.Request {
+	v, ok := ctx.Value(key).(*Values)
+	if !ok {
+		return nil
+	}
+	return v.request
+}
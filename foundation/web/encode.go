@@ -0,0 +1,480 @@
+package web
+
+import (
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Set of media types supported out of the box
+const (
+	MediaTypeJSON   = "application/json"
+	MediaTypeCSV    = "text/csv"
+	MediaTypeNDJSON = "application/x-ndjson"
+	MediaTypeXML    = "application/xml"
+)
+
+// MediaTypeProblemJSON is the media type of the problem details defined by
+// RFC 7807. It's not negotiated like the registered media types since it
+// only applies to error responses
+const MediaTypeProblemJSON = "application/problem+json"
+
+// Encoder writes the provided value to the writer in a specific media type
+type Encoder func(w io.Writer, data any) error
+
+// encoders holds the registered encoders keyed by media type. The order
+// slice keeps the registration order which is used to break ties between
+// media types other than JSON when the client has no preference
+var encoders = struct {
+	mu    sync.RWMutex
+	m     map[string]Encoder
+	order []string
+}{
+	m: make(map[string]Encoder),
+}
+
+func init() {
+	RegisterEncoder(MediaTypeJSON, encodeJSON)
+	RegisterEncoder(MediaTypeCSV, encodeCSV)
+	RegisterEncoder(MediaTypeNDJSON, encodeNDJSON)
+	RegisterEncoder(MediaTypeXML, encodeXML)
+}
+
+// RegisterEncoder adds an encoder for the specified media type. Registering
+// a media type that already exists replaces its encoder
+func RegisterEncoder(mediaType string, enc Encoder) {
+	mediaType = strings.ToLower(mediaType)
+
+	encoders.mu.Lock()
+	defer encoders.mu.Unlock()
+
+	if _, exists := encoders.m[mediaType]; !exists {
+		encoders.order = append(encoders.order, mediaType)
+	}
+	encoders.m[mediaType] = enc
+}
+
+// MediaTypes returns the list of registered media types
+func MediaTypes() []string {
+	encoders.mu.RLock()
+	defer encoders.mu.RUnlock()
+
+	return append([]string(nil), encoders.order...)
+}
+
+// lookupEncoder returns the encoder for the specified media type
+func lookupEncoder(mediaType string) Encoder {
+	encoders.mu.RLock()
+	defer encoders.mu.RUnlock()
+
+	return encoders.m[mediaType]
+}
+
+// =============================================================================
+
+// acceptRange represents a single media range from an Accept header
+type acceptRange struct {
+	typ     string
+	subtype string
+	q       float64
+}
+
+// parseAccept parses the value of an Accept header into the set of media
+// ranges it contains. Ranges that can't be parsed are ignored
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+		typ, subtype, found := strings.Cut(mediaRange, "/")
+		if !found || typ == "" || subtype == "" {
+			continue
+		}
+
+		ar := acceptRange{
+			typ:     typ,
+			subtype: subtype,
+			q:       1,
+		}
+
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(name) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				ar.q = q
+			}
+		}
+
+		ranges = append(ranges, ar)
+	}
+
+	return ranges
+}
+
+// quality returns the quality the client assigned to the media type and how
+// specific the matching range was. A specificity of -1 means no range
+// matched the media type
+func quality(ranges []acceptRange, mediaType string) (q float64, specificity int) {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+
+	specificity = -1
+	for _, ar := range ranges {
+		var s int
+		switch {
+		case ar.typ == typ && ar.subtype == subtype:
+			s = 2
+		case ar.typ == typ && ar.subtype == "*":
+			s = 1
+		case ar.typ == "*" && ar.subtype == "*":
+			s = 0
+		default:
+			continue
+		}
+
+		// The most specific range that matches decides the quality.
+		if s > specificity {
+			specificity = s
+			q = ar.q
+		}
+	}
+
+	return q, specificity
+}
+
+// Accepts reports whether the Accept header names the media type with a
+// quality above zero. Wildcard ranges don't count so the client must ask for
+// the media type explicitly
+func Accepts(accept string, mediaType string) bool {
+	q, specificity := quality(parseAccept(accept), strings.ToLower(mediaType))
+	return specificity == 2 && q > 0
+}
+
+// negotiate returns the registered media types acceptable to the client
+// ordered by preference. JSON stays the default: it's the only media type
+// when the header is empty, it wins ties and it's preferred when none of the
+// media types the client lists can be produced but it accepts JSON through
+// a wildcard, like text/html first and */* last. The list is empty when none
+// of the registered media types are acceptable
+func negotiate(accept string) []string {
+	if strings.TrimSpace(accept) == "" {
+		return []string{MediaTypeJSON}
+	}
+
+	ranges := parseAccept(accept)
+
+	var top float64
+	for _, ar := range ranges {
+		if ar.q > top {
+			top = ar.q
+		}
+	}
+
+	type candidate struct {
+		mediaType string
+		q         float64
+		index     int
+	}
+
+	var candidates []candidate
+	var listed bool
+	for i, mediaType := range MediaTypes() {
+		q, specificity := quality(ranges, mediaType)
+		if specificity < 0 || q <= 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{mediaType, q, i})
+
+		// A media type matching more than */* was asked for by the client.
+		if specificity > 0 {
+			listed = true
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		if (candidates[i].mediaType == MediaTypeJSON) != (candidates[j].mediaType == MediaTypeJSON) {
+			return candidates[i].mediaType == MediaTypeJSON
+		}
+		return candidates[i].index < candidates[j].index
+	})
+
+	mediaTypes := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		mediaTypes = append(mediaTypes, c.mediaType)
+	}
+
+	// The client's first choice can't be produced and neither can anything
+	// else it lists so the default is served when the client accepts it.
+	if candidates[0].q < top && !listed {
+		for i, mediaType := range mediaTypes {
+			if mediaType == MediaTypeJSON {
+				copy(mediaTypes[1:i+1], mediaTypes[:i])
+				mediaTypes[0] = MediaTypeJSON
+				break
+			}
+		}
+	}
+
+	return mediaTypes
+}
+
+// =============================================================================
+
+// encodeJSON writes the value as a single JSON document
+func encodeJSON(w io.Writer, data any) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(jsonData)
+	return err
+}
+
+// encodeNDJSON writes each element of a slice as a JSON document on its own
+// line. Any other value is written as a single line
+func encodeNDJSON(w io.Writer, data any) error {
+	enc := json.NewEncoder(w)
+
+	v := indirect(reflect.ValueOf(data))
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return enc.Encode(data)
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		if err := enc.Encode(v.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeXML writes the value as an XML document. Slices are wrapped in an
+// items element with an item element for each value
+func encodeXML(w io.Writer, data any) error {
+	enc := xml.NewEncoder(w)
+
+	v := indirect(reflect.ValueOf(data))
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		if err := enc.EncodeElement(data, xml.StartElement{Name: xml.Name{Local: "response"}}); err != nil {
+			return err
+		}
+		return enc.Flush()
+	}
+
+	items := xml.StartElement{Name: xml.Name{Local: "items"}}
+	if err := enc.EncodeToken(items); err != nil {
+		return err
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		if err := enc.EncodeElement(v.Index(i).Interface(), xml.StartElement{Name: xml.Name{Local: "item"}}); err != nil {
+			return err
+		}
+	}
+
+	if err := enc.EncodeToken(items.End()); err != nil {
+		return err
+	}
+
+	return enc.Flush()
+}
+
+// encodeCSV writes a struct or a slice of structs as CSV. The header row is
+// derived from the exported struct fields using the csv tag, then the json
+// tag, then the field name. Values that don't have a natural text form are
+// written as JSON
+func encodeCSV(w io.Writer, data any) error {
+	v := indirect(reflect.ValueOf(data))
+	if !v.IsValid() {
+		return fmt.Errorf("csv: unsupported value %v", data)
+	}
+
+	rows := []reflect.Value{v}
+	typ := v.Type()
+
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		rows = make([]reflect.Value, v.Len())
+		for i := range rows {
+			rows[i] = indirect(v.Index(i))
+		}
+
+		typ = typ.Elem()
+		for typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+	}
+
+	if typ.Kind() != reflect.Struct {
+		return fmt.Errorf("csv: unsupported type %s", typ)
+	}
+
+	fields := csvFields(typ, nil)
+
+	header := make([]string, len(fields))
+	for i, fld := range fields {
+		header[i] = fld.name
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		record := make([]string, len(fields))
+		for i, fld := range fields {
+			value, err := csvValue(row, fld.index)
+			if err != nil {
+				return fmt.Errorf("csv: field %s: %w", fld.name, err)
+			}
+			record[i] = value
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvField represents a column derived from a struct field
+type csvField struct {
+	name  string
+	index []int
+}
+
+// csvFields returns the columns for the exported fields of the struct type.
+// Embedded structs without a name are flattened into the parent
+func csvFields(typ reflect.Type, index []int) []csvField {
+	var fields []csvField
+
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		idx := append(append([]int(nil), index...), i)
+
+		name := tagName(sf.Tag.Get("csv"))
+		if name == "" {
+			name = tagName(sf.Tag.Get("json"))
+		}
+		if name == "-" {
+			continue
+		}
+
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, csvFields(sf.Type, idx)...)
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+
+		fields = append(fields, csvField{name: name, index: idx})
+	}
+
+	return fields
+}
+
+// csvValue returns the text form of the field identified by the index
+func csvValue(row reflect.Value, index []int) (string, error) {
+	if !row.IsValid() {
+		return "", nil
+	}
+
+	v, err := row.FieldByIndexErr(index)
+	if err != nil {
+		// A nil embedded pointer produces an empty value.
+		return "", nil
+	}
+
+	v = indirect(v)
+	if !v.IsValid() {
+		return "", nil
+	}
+
+	if (v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && v.IsNil() {
+		return "", nil
+	}
+
+	// Methods declared with a pointer receiver are only reachable through
+	// the address of the value.
+	candidates := []reflect.Value{v}
+	if v.CanAddr() {
+		candidates = append(candidates, v.Addr())
+	}
+
+	for _, c := range candidates {
+		switch val := c.Interface().(type) {
+		case encoding.TextMarshaler:
+			text, err := val.MarshalText()
+			return string(text), err
+		case fmt.Stringer:
+			return val.String(), nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	}
+
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// tagName returns the name portion of a struct tag value
+func tagName(tag string) string {
+	name, _, _ := strings.Cut(tag, ",")
+	return name
+}
+
+// indirect follows pointers and interfaces until it reaches a value. An
+// invalid value is returned for nil pointers
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+
+	return v
+}
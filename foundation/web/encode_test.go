@@ -0,0 +1,77 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   []string
+	}{
+		{"empty", "", []string{MediaTypeJSON}},
+		{"json", "application/json", []string{MediaTypeJSON}},
+		{"wildcard", "*/*", []string{MediaTypeJSON, MediaTypeCSV, MediaTypeNDJSON, MediaTypeXML}},
+		{"xml", "application/xml", []string{MediaTypeXML}},
+		{"xml preferred", "application/xml, */*;q=0.5", []string{MediaTypeXML, MediaTypeJSON, MediaTypeCSV, MediaTypeNDJSON}},
+		{"tie goes to json", "application/xml, application/json", []string{MediaTypeJSON, MediaTypeXML}},
+		{"xml ranked over wildcard", "application/xml;q=0.9, */*;q=0.8", []string{MediaTypeXML, MediaTypeJSON, MediaTypeCSV, MediaTypeNDJSON}},
+		{"listed type after unavailable first choice", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", []string{MediaTypeXML, MediaTypeJSON, MediaTypeCSV, MediaTypeNDJSON}},
+		{"unavailable first choice and wildcard", "text/html, */*;q=0.8", []string{MediaTypeJSON, MediaTypeCSV, MediaTypeNDJSON, MediaTypeXML}},
+		{"unavailable first choice and type wildcard", "text/html, application/*;q=0.8", []string{MediaTypeJSON, MediaTypeNDJSON, MediaTypeXML}},
+		{"json refused", "text/html, application/xml;q=0.9", []string{MediaTypeXML}},
+		{"nothing acceptable", "image/png", nil},
+		{"zero quality", "application/json;q=0", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiate(tt.accept); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("negotiate(%q) = %v, want %v", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRespondEncoderFailure(t *testing.T) {
+	data := map[string]string{"status": "ok"}
+
+	tests := []struct {
+		name        string
+		accept      string
+		respond     func(ctx context.Context, w http.ResponseWriter, data any, statusCode int) error
+		statusCode  int
+		status      int
+		contentType string
+	}{
+		{"falls back to accepted json", "application/xml, application/json;q=0.5", Respond, http.StatusOK, http.StatusOK, MediaTypeJSON},
+		{"json not accepted", "application/xml", Respond, http.StatusOK, http.StatusNotAcceptable, "text/plain; charset=utf-8"},
+		{"error keeps its status", "image/png", RespondError, http.StatusUnauthorized, http.StatusUnauthorized, MediaTypeJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", tt.accept)
+
+			ctx := context.WithValue(context.Background(), key, &Values{request: r})
+			w := httptest.NewRecorder()
+
+			if err := tt.respond(ctx, w, data, tt.statusCode); err != nil {
+				t.Fatalf("responding: %s", err)
+			}
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("content type = %q, want %q", got, tt.contentType)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package web
+
+import "strings"
+
+// Group is a set of routes sharing a common path prefix and middleware. The
+// group's middleware is executed after the application middleware and before
+// any middleware provided for an individual route
+type Group struct {
+	app         *App
+	prefix      string
+	mw          []Middleware
+	annotations map[string]string
+}
+
+// Group creates a new route group rooted at the specified prefix. Every
+// route registered with the group runs the provided middleware
+func (a *App) Group(prefix string, mw ...Middleware) *Group {
+	return &Group{
+		app:    a,
+		prefix: cleanPrefix(prefix),
+		mw:     mw,
+	}
+}
+
+// Group creates a nested route group. The prefix is appended to the parent's
+// prefix and the middleware is executed after the parent's middleware. The
+// nested group starts with the annotations of the parent
+func (g *Group) Group(prefix string, mw ...Middleware) *Group {
+	return &Group{
+		app:         g.app,
+		prefix:      g.prefix + cleanPrefix(prefix),
+		mw:          g.middleware(mw),
+		annotations: g.annotate(&Route{}).annotations,
+	}
+}
+
+// Annotate records information about every route registered with the group
+// from now on, like the authorization rule the group's middleware enforces
+func (g *Group) Annotate(key string, value string) *Group {
+	if g.annotations == nil {
+		g.annotations = make(map[string]string)
+	}
+	g.annotations[key] = value
+	return g
+}
+
+// Handle sets a handler function for a given HTTP method and path relative
+// to the group's prefix. The returned route can be used to document the route
+func (g *Group) Handle(method string, path string, handler Handler, mw ...Middleware) *Route {
+	rt := g.app.handle(method, joinPath(g.prefix, path), handler, g.middleware(mw))
+	return g.annotate(rt)
+}
+
+// middleware returns a new slice with the group's middleware followed by the
+// provided middleware. A new slice is always returned so groups never share
+// a backing array
+func (g *Group) middleware(mw []Middleware) []Middleware {
+	all := make([]Middleware, 0, len(g.mw)+len(mw))
+	all = append(all, g.mw...)
+	all = append(all, mw...)
+
+	return all
+}
+
+// annotate adds the group's annotations to the route. The route's own
+// annotations are added later so they take precedence
+func (g *Group) annotate(rt *Route) *Route {
+	for k, v := range g.annotations {
+		rt.Annotate(k, v)
+	}
+	return rt
+}
+
+// cleanPrefix makes sure a prefix starts with a slash and has no trailing
+// slash so prefixes and paths can be concatenated
+func cleanPrefix(prefix string) string {
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+
+	return prefix
+}
+
+// joinPath joins the path to the prefix of a group with a single slash. The
+// root path of a group is the prefix itself
+func joinPath(prefix string, path string) string {
+	path = strings.TrimLeft(path, "/")
+	if path == "" {
+		if prefix == "" {
+			return "/"
+		}
+		return prefix
+	}
+
+	return prefix + "/" + path
+}
@@ -0,0 +1,112 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGroupPaths(t *testing.T) {
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		nested string
+		path   string
+		want   string
+	}{
+		{"prefix and path", "/test", "", "/auth", "/test/auth"},
+		{"path without slash", "/test", "", "auth", "/test/auth"},
+		{"prefix with trailing slash", "/v1/", "", "/users", "/v1/users"},
+		{"prefix without slash", "v1", "", "users", "/v1/users"},
+		{"root of the group", "/v1", "", "/", "/v1"},
+		{"empty path", "/v1", "", "", "/v1"},
+		{"empty prefix", "", "", "/users", "/users"},
+		{"empty prefix and path", "", "", "", "/"},
+		{"nested", "/v1/", "/users/", "/:id", "/v1/users/:id"},
+		{"nested without slashes", "v1", "users", ":id", "/v1/users/:id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := NewApp(nil, nil)
+
+			g := app.Group(tt.prefix)
+			if tt.nested != "" {
+				g = g.Group(tt.nested)
+			}
+			g.Handle(http.MethodGet, tt.path, handler)
+
+			routes := app.Routes()
+			if len(routes) != 1 || routes[0].Path != tt.want {
+				t.Fatalf("routes = %v, want %s", routes, tt.want)
+			}
+
+			url := tt.want
+			if rest, found := strings.CutSuffix(url, ":id"); found {
+				url = rest + "1"
+			}
+
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+			if w.Code != http.StatusNoContent {
+				t.Errorf("GET %s status = %d, want %d", url, w.Code, http.StatusNoContent)
+			}
+		})
+	}
+}
+
+func TestGroupMiddlewareOrder(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		m := func(handler Handler) Handler {
+			h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+				calls = append(calls, name)
+				return handler(ctx, w, r)
+			}
+			return h
+		}
+		return m
+	}
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		calls = append(calls, "handler")
+		return nil
+	}
+
+	app := NewApp(nil, nil, record("app"))
+
+	g := app.Group("/a", record("group1"), record("group2"))
+	nested := g.Group("/b", record("nested"))
+	nested.Handle(http.MethodGet, "/c", handler, record("route"))
+
+	// The routes of the parent don't run the middleware of the nested group
+	// even though the groups were built from the same slice.
+	g.Handle(http.MethodGet, "/d", handler, record("other"))
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/a/b/c", []string{"app", "group1", "group2", "nested", "route", "handler"}},
+		{"/a/d", []string{"app", "group1", "group2", "other", "handler"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			calls = nil
+			app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if !reflect.DeepEqual(calls, tt.want) {
+				t.Errorf("calls = %v, want %v", calls, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,584 @@
+package web
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// openAPIVersion is the version of the specification the document follows
+const openAPIVersion = "3.0.3"
+
+// OpenAPIConfig represents the information about the API that can't be
+// derived from the registered routes
+type OpenAPIConfig struct {
+	Title       string
+	Description string
+	Version     string
+	Servers     []string
+
+	// SecuritySchemes are the ways clients can authenticate. The routes
+	// refer to them by name through the AnnotationSecurity annotation
+	SecuritySchemes map[string]SecurityScheme
+
+	// ErrorResponse is a value of the type the API responds with when a
+	// request fails. It's documented as the default response of every
+	// route when set
+	ErrorResponse any
+
+	// ProblemResponse is a value of the type the API responds with when a
+	// request fails and the client asks for application/problem+json. It's
+	// documented along with ErrorResponse when set
+	ProblemResponse any
+}
+
+// SecurityScheme represents a way for clients to authenticate
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// OpenAPI generates an OpenAPI 3 document describing the routes registered
+// with the app. The schemas of the request and response bodies are derived
+// from the Go types and their json and validate tags
+func (a *App) OpenAPI(cfg OpenAPIConfig) OpenAPIDocument {
+	gen := schemaGenerator{
+		schemas: make(map[string]*schema),
+		names:   make(map[reflect.Type]string),
+	}
+
+	doc := OpenAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:       cfg.Title,
+			Description: cfg.Description,
+			Version:     cfg.Version,
+		},
+		Paths: make(map[string]map[string]*operation),
+	}
+
+	for _, url := range cfg.Servers {
+		doc.Servers = append(doc.Servers, openAPIServer{URL: url})
+	}
+
+	var errContent map[string]mediaTypeObject
+	if cfg.ErrorResponse != nil {
+		errContent = jsonContent(gen.schemaOf(reflect.TypeOf(cfg.ErrorResponse)))
+	}
+	if cfg.ProblemResponse != nil {
+		if errContent == nil {
+			errContent = make(map[string]mediaTypeObject)
+		}
+		errContent[MediaTypeProblemJSON] = mediaTypeObject{Schema: gen.schemaOf(reflect.TypeOf(cfg.ProblemResponse))}
+	}
+
+	for _, rt := range a.routes() {
+		path, params := openAPIPath(rt.path)
+
+		op := operation{
+			Summary:     rt.summary,
+			Description: rt.description,
+			Tags:        rt.tags,
+			Parameters:  params,
+			Deprecated:  rt.deprecated,
+			Responses:   make(map[string]*openAPIResponse),
+			Extensions:  make(map[string]string),
+		}
+
+		if rt.request != nil {
+			op.RequestBody = &requestBody{
+				Required: true,
+				Content:  jsonContent(gen.schemaOf(reflect.TypeOf(rt.request))),
+			}
+		}
+
+		for statusCode, body := range rt.responses {
+			resp := openAPIResponse{
+				Description: http.StatusText(statusCode),
+			}
+			if body != nil {
+				resp.Content = jsonContent(gen.schemaOf(reflect.TypeOf(body)))
+			}
+			op.Responses[strconv.Itoa(statusCode)] = &resp
+		}
+
+		if len(op.Responses) == 0 {
+			op.Responses[strconv.Itoa(http.StatusOK)] = &openAPIResponse{
+				Description: http.StatusText(http.StatusOK),
+			}
+		}
+
+		if errContent != nil {
+			op.Responses["default"] = &openAPIResponse{
+				Description: "Error",
+				Content:     errContent,
+			}
+		}
+
+		for k, v := range rt.annotations {
+			if k == AnnotationSecurity {
+				op.Security = []map[string][]string{{v: {}}}
+				continue
+			}
+			op.Extensions["x-"+k] = v
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*operation)
+		}
+		doc.Paths[path][strings.ToLower(rt.method)] = &op
+	}
+
+	doc.Components = components{
+		Schemas:         gen.schemas,
+		SecuritySchemes: cfg.SecuritySchemes,
+	}
+
+	return doc
+}
+
+// OpenAPIHandler returns a handler responding with the OpenAPI document of
+// the app. The document is generated on the first request, once every
+// route has been registered
+func (a *App) OpenAPIHandler(cfg OpenAPIConfig) Handler {
+	var once sync.Once
+	var doc OpenAPIDocument
+
+	h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		once.Do(func() {
+			doc = a.OpenAPI(cfg)
+		})
+
+		return Respond(ctx, w, doc, http.StatusOK)
+	}
+
+	return h
+}
+
+// openAPIPath converts the path of a route to an OpenAPI path template and
+// returns the parameters it contains
+func openAPIPath(path string) (string, []parameter) {
+	var params []parameter
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if len(segment) < 2 || (segment[0] != ':' && segment[0] != '*') {
+			continue
+		}
+
+		name := segment[1:]
+		segments[i] = "{" + name + "}"
+		params = append(params, parameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &schema{Type: "string"},
+		})
+	}
+
+	return strings.Join(segments, "/"), params
+}
+
+func jsonContent(s *schema) map[string]mediaTypeObject {
+	return map[string]mediaTypeObject{
+		MediaTypeJSON: {Schema: s},
+	}
+}
+
+// =============================================================================
+
+// OpenAPIDocument represents an OpenAPI 3 document
+type OpenAPIDocument struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       openAPIInfo                      `json:"info"`
+	Servers    []openAPIServer                  `json:"servers,omitempty"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components components                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type components struct {
+	Schemas         map[string]*schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type operation struct {
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []parameter                 `json:"parameters,omitempty"`
+	RequestBody *requestBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+	Extensions  map[string]string           `json:"-"`
+}
+
+// MarshalJSON adds the specification extensions to the operation's fields
+func (op operation) MarshalJSON() ([]byte, error) {
+	type fields operation
+
+	data, err := json.Marshal(fields(op))
+	if err != nil || len(op.Extensions) == 0 {
+		return data, err
+	}
+
+	ext, err := json.Marshal(op.Extensions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both are JSON objects so the extensions are spliced in before the
+	// closing brace of the fields.
+	data = append(data[:len(data)-1], ',')
+	return append(data, ext[1:]...), nil
+}
+
+type parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                       `json:"required"`
+	Content  map[string]mediaTypeObject `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                     `json:"description"`
+	Content     map[string]mediaTypeObject `json:"content,omitempty"`
+}
+
+type mediaTypeObject struct {
+	Schema *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     bool               `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     bool               `json:"exclusiveMaximum,omitempty"`
+}
+
+// =============================================================================
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// schemaGenerator derives schemas from Go types. Named struct types are
+// added to the components once and referenced from there
+type schemaGenerator struct {
+	schemas map[string]*schema
+	names   map[reflect.Type]string
+}
+
+// schemaOf returns the schema of the JSON encoding of the type
+func (g *schemaGenerator) schemaOf(t reflect.Type) *schema {
+	if t.Kind() == reflect.Pointer {
+		s := g.schemaOf(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	}
+
+	switch {
+	case t == timeType:
+		return &schema{Type: "string", Format: "date-time"}
+	case implements(t, textMarshalerType):
+		s := schema{Type: "string"}
+		if t.Name() == "UUID" {
+			s.Format = "uuid"
+		}
+		return &s
+	case implements(t, jsonMarshalerType):
+		return &schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return g.ref(t)
+	}
+
+	return &schema{}
+}
+
+// ref adds the schema of a named struct type to the components and returns
+// a reference to it. The name is reserved before the fields are walked so
+// recursive types end up referencing themselves
+func (g *schemaGenerator) ref(t reflect.Type) *schema {
+	name, exists := g.names[t]
+	if !exists {
+		name = g.componentName(t)
+		g.names[t] = name
+		g.schemas[name] = &schema{}
+		*g.schemas[name] = *g.structSchema(t)
+	}
+
+	return &schema{Ref: "#/components/schemas/" + name}
+}
+
+// componentName builds a name for the type that includes the package name so
+// types with the same name from different packages don't clash
+func (g *schemaGenerator) componentName(t reflect.Type) string {
+	name := t.Name()
+	if pkg := t.PkgPath(); pkg != "" {
+		name = pkg[strings.LastIndex(pkg, "/")+1:] + "." + name
+	}
+
+	clean := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+
+	// Different types could still end up with the same name, like two
+	// packages named model.
+	candidate := clean
+	for i := 2; ; i++ {
+		if _, exists := g.schemas[candidate]; !exists {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s%d", clean, i)
+	}
+}
+
+// structSchema returns the schema of the fields of the struct
+func (g *schemaGenerator) structSchema(t reflect.Type) *schema {
+	s := schema{
+		Type:       "object",
+		Properties: make(map[string]*schema),
+	}
+
+	g.addFields(&s, t)
+
+	return &s
+}
+
+// addFields adds the fields that are encoded to the schema. Embedded
+// structs without a name are flattened the way encoding/json does it
+func (g *schemaGenerator) addFields(s *schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, ft)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		fs := g.schemaOf(field.Type)
+		if applyValidateTag(fs, field.Tag.Get("validate")) {
+			s.Required = append(s.Required, name)
+		}
+
+		s.Properties[name] = fs
+	}
+}
+
+// applyValidateTag adds the constraints of the validate tag to the schema.
+// It reports if the field is required. Rules after dive apply to the items
+// of an array
+func applyValidateTag(s *schema, tag string) bool {
+	if tag == "" || tag == "-" {
+		return false
+	}
+
+	var required bool
+
+	target := s
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+
+		if name == "required" && target == s {
+			required = true
+			continue
+		}
+
+		if name == "dive" {
+			if target.Items == nil {
+				break
+			}
+			target = target.Items
+			continue
+		}
+
+		// Constraints can't be added next to a reference.
+		if target.Ref != "" {
+			continue
+		}
+
+		applyRule(target, name, param)
+	}
+
+	return required
+}
+
+// applyRule adds the constraint of a single validate rule to the schema.
+// Rules without an OpenAPI equivalent are ignored
+func applyRule(s *schema, name string, param string) {
+	switch name {
+	case "email":
+		s.Format = "email"
+	case "url", "uri":
+		s.Format = "uri"
+	case "uuid", "uuid4":
+		s.Format = "uuid"
+	case "hostname":
+		s.Format = "hostname"
+	case "ipv4":
+		s.Format = "ipv4"
+	case "ipv6":
+		s.Format = "ipv6"
+
+	case "oneof":
+		for _, value := range strings.Fields(param) {
+			s.Enum = append(s.Enum, enumValue(s, value))
+		}
+
+	case "min", "max", "len", "gte", "lte", "gt", "lt":
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return
+		}
+
+		switch s.Type {
+		case "string":
+			setBound(&s.MinLength, &s.MaxLength, name, int(n))
+		case "array", "object":
+			setBound(&s.MinItems, &s.MaxItems, name, int(n))
+		case "integer", "number":
+			switch name {
+			case "min", "gte", "len":
+				s.Minimum = &n
+			case "gt":
+				s.Minimum, s.ExclusiveMinimum = &n, true
+			}
+			switch name {
+			case "max", "lte", "len":
+				s.Maximum = &n
+			case "lt":
+				s.Maximum, s.ExclusiveMaximum = &n, true
+			}
+		}
+	}
+}
+
+// setBound sets the minimum and maximum length for the rule. A strict bound
+// is turned into an inclusive one since lengths are whole numbers
+func setBound(min **int, max **int, rule string, n int) {
+	switch rule {
+	case "min", "gte":
+		*min = &n
+	case "gt":
+		n++
+		*min = &n
+	case "max", "lte":
+		*max = &n
+	case "lt":
+		n--
+		*max = &n
+	case "len":
+		*min, *max = &n, &n
+	}
+}
+
+// enumValue converts a value of a oneof rule to the type of the schema
+func enumValue(s *schema, value string) any {
+	switch s.Type {
+	case "integer":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case "number":
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	}
+
+	return value
+}
+
+// implements checks if the type or a pointer to it implements the interface
+func implements(t reflect.Type, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PointerTo(t).Implements(iface)
+}
@@ -0,0 +1,146 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// updateEnv is the environment variable that makes the tests write the
+// golden files instead of comparing with them
+const updateEnv = "APITEST_UPDATE"
+
+// golden compares the JSON document with the golden file of the specified
+// name in the testdata folder
+func golden(t *testing.T, name string, body []byte) {
+	t.Helper()
+
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("decoding document: %s: %s", err, body)
+	}
+	got, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		t.Fatalf("encoding document: %s", err)
+	}
+	got = append(got, '\n')
+
+	path := filepath.Join("testdata", name+".json")
+
+	if os.Getenv(updateEnv) != "" {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatalf("creating testdata folder: %s", err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("writing golden file: %s", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file, run the test with %s=1 to create it: %s", updateEnv, err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("document does not match golden file %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// =============================================================================
+
+type openAPIAudit struct {
+	DateCreated time.Time  `json:"dateCreated"`
+	DateDeleted *time.Time `json:"dateDeleted"`
+}
+
+type openAPIProduct struct {
+	ID       uuid.UUID         `json:"id"`
+	Name     string            `json:"name" validate:"required,min=3,max=40"`
+	Email    string            `json:"email" validate:"omitempty,email"`
+	Kind     string            `json:"kind" validate:"required,oneof=book game"`
+	Rating   int               `json:"rating" validate:"oneof=1 2 3"`
+	Cost     float64           `json:"cost" validate:"gt=0,lt=1000"`
+	Quantity int32             `json:"quantity" validate:"gte=1"`
+	Tags     []string          `json:"tags" validate:"max=5,dive,min=2"`
+	Labels   map[string]string `json:"labels"`
+	Image    []byte            `json:"image"`
+	Related  []openAPIProduct  `json:"related"`
+	Secret   string            `json:"-"`
+	internal string
+	openAPIAudit
+}
+
+type openAPIError struct {
+	Error string `json:"error"`
+}
+
+type openAPIProblem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	}
+
+	app := NewApp(nil, nil)
+
+	app.Handle(http.MethodGet, "/status", handler).
+		Public().
+		Summary("Status of the service")
+
+	v1 := app.Version("v1")
+	products := v1.Group("/products").
+		Annotate(AnnotationSecurity, "bearerAuth").
+		Annotate(AnnotationRule, "admin_only")
+
+	products.Handle(http.MethodGet, "/:id", handler).
+		Summary("Product by id").
+		Description("Returns the product with the id.").
+		Tags("products").
+		Response(http.StatusOK, openAPIProduct{})
+	products.Handle(http.MethodPost, "", handler).
+		Summary("Create a product").
+		Tags("products").
+		Request(openAPIProduct{}).
+		Response(http.StatusCreated, &openAPIProduct{}).
+		Response(http.StatusConflict, nil)
+	products.Handle(http.MethodDelete, "/:id", handler, Deprecate(Deprecation{})).
+		Summary("Delete a product").
+		Tags("products")
+
+	v1.Handle(http.MethodGet, "/openapi.json", app.OpenAPIHandler(OpenAPIConfig{
+		Title:       "Test API",
+		Description: "API used to test the document",
+		Version:     "1.0.0",
+		Servers:     []string{"https://api.example.com"},
+		SecuritySchemes: map[string]SecurityScheme{
+			"bearerAuth": {
+				Type:         "http",
+				Scheme:       "bearer",
+				BearerFormat: "JWT",
+			},
+		},
+		ErrorResponse:   openAPIError{},
+		ProblemResponse: openAPIProblem{},
+	})).Public()
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/openapi.json", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	golden(t, "openapi", w.Body.Bytes())
+}
@@ -0,0 +1,99 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// pathMethods keeps track of the methods registered for a path so the path
+// can get an OPTIONS route
+type pathMethods struct {
+	methods []string
+
+	// autoOptions is set when the app registered the OPTIONS route and
+	// explicitOptions when it was registered with Handle. An OPTIONS
+	// route registered with Handle after the app registered one is kept
+	// in options and takes over.
+	autoOptions     bool
+	explicitOptions bool
+	options         http.HandlerFunc
+}
+
+// register adds the route to the mux. The first time a path is registered
+// an OPTIONS route is registered for it as well, unless one was registered
+// with Handle, so preflight requests reach the application middleware
+func (a *App) register(method string, path string, h http.HandlerFunc) {
+	a.mu.Lock()
+
+	if a.paths == nil {
+		a.paths = make(map[string]*pathMethods)
+	}
+
+	pm, exists := a.paths[path]
+	if !exists {
+		pm = &pathMethods{}
+		a.paths[path] = pm
+	}
+
+	if method == http.MethodOptions {
+		if pm.autoOptions {
+			pm.options = h
+			a.mu.Unlock()
+			return
+		}
+
+		pm.explicitOptions = true
+		a.mu.Unlock()
+
+		a.ContextMux.Handle(method, path, h)
+		return
+	}
+
+	pm.methods = append(pm.methods, method)
+
+	addOptions := !pm.autoOptions && !pm.explicitOptions
+	pm.autoOptions = true
+
+	a.mu.Unlock()
+
+	a.ContextMux.Handle(method, path, h)
+
+	if addOptions {
+		a.ContextMux.Handle(http.MethodOptions, path, a.options(path, pm))
+	}
+}
+
+// options constructs the OPTIONS route the app registers for a path. It
+// runs the application middleware and responds with the methods the path
+// supports
+func (a *App) options(path string, pm *pathMethods) http.HandlerFunc {
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		a.mu.Lock()
+		methods := append([]string{http.MethodOptions}, pm.methods...)
+		a.mu.Unlock()
+
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+
+		return Respond(ctx, w, nil, http.StatusNoContent)
+	}
+
+	// The route isn't recorded since it's not part of the documented API.
+	var rt Route
+	auto := a.serve(http.MethodOptions, path, wrapRoute(&rt, a.mw, handler))
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		a.mu.Lock()
+		explicit := pm.options
+		a.mu.Unlock()
+
+		if explicit != nil {
+			explicit(w, r)
+			return
+		}
+
+		auto(w, r)
+	}
+
+	return h
+}
@@ -0,0 +1,164 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dimfeld/httptreemux/v5"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Param returns the web call parameters from the request
+func Param(r *http.Request, key string) string {
+	m := httptreemux.ContextParams(r.Context())
+	return m[key]
+}
+
+// validator is the behavior a value being decoded can implement so it is
+// validated as part of decoding. The QueryFilter types in the core packages
+// are an example of this behavior
+type validator interface {
+	Validate() error
+}
+
+// Decoder holds the settings used to decode a request body
+type Decoder struct {
+	// MaxBytes limits the size of the body that will be read. A value of
+	// zero or less means there is no limit
+	MaxBytes int64
+
+	// Strict rejects bodies containing fields that don't exist in the
+	// destination value
+	Strict bool
+}
+
+// defaultDecoder is the decoder used by the Decode function
+var defaultDecoder = Decoder{
+	MaxBytes: 1 << 20,
+	Strict:   true,
+}
+
+// Decode reads the body of an HTTP request looking for a JSON document using
+// the default decoder. The body is limited to 1 megabyte and unknown fields
+// are rejected
+func Decode(r *http.Request, val any) error {
+	return defaultDecoder.Decode(r, val)
+}
+
+// Decode reads the body of an HTTP request looking for a JSON document. The
+// body is decoded into the provided value. If the value implements a
+// Validate method it is called and its error is returned as is
+func (d Decoder) Decode(r *http.Request, val any) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return NewDecodeError(errors.New("request body is empty"), http.StatusBadRequest)
+	}
+
+	body := io.Reader(r.Body)
+	if d.MaxBytes > 0 {
+		body = http.MaxBytesReader(nil, r.Body, d.MaxBytes)
+	}
+
+	decoder := json.NewDecoder(body)
+	if d.Strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(val); err != nil {
+		return decodeError(err)
+	}
+
+	// A body is expected to hold a single JSON document so anything after
+	// the first document is considered malformed
+	if err := decoder.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		if err == nil {
+			return NewDecodeError(errors.New("request body must only contain a single JSON document"), http.StatusBadRequest)
+		}
+		return decodeError(err)
+	}
+
+	if v, ok := val.(validator); ok {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeError converts the errors returned by the JSON decoder into a
+// DecodeError with a message that is safe to return to the client
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return NewDecodeError(errors.New("request body is empty"), http.StatusBadRequest)
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return NewDecodeError(errors.New("request body contains malformed JSON"), http.StatusBadRequest)
+
+	case errors.As(err, &syntaxErr):
+		return NewDecodeError(fmt.Errorf("request body contains malformed JSON at position %d", syntaxErr.Offset), http.StatusBadRequest)
+
+	case errors.As(err, &typeErr):
+		if typeErr.Field != "" {
+			return NewDecodeError(fmt.Errorf("request body contains an invalid value for field %q", typeErr.Field), http.StatusBadRequest)
+		}
+		return NewDecodeError(fmt.Errorf("request body contains an invalid value at position %d", typeErr.Offset), http.StatusBadRequest)
+
+	case errors.As(err, &maxBytesErr):
+		return NewDecodeError(fmt.Errorf("request body must not be larger than %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// The decoder doesn't provide a dedicated error type for unknown
+		// fields so the field name is taken from the message
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return NewDecodeError(fmt.Errorf("request body contains unknown field %s", field), http.StatusBadRequest)
+	}
+
+	return NewDecodeError(fmt.Errorf("decoding request body: %w", err), http.StatusBadRequest)
+}
+
+// =============================================================================
+
+// DecodeError is used to pass an error during the decoding of a request
+// body through the application with the status the client should receive
+type DecodeError struct {
+	Err    error
+	Status int
+}
+
+// NewDecodeError wraps a provided error with an HTTP status code
+func NewDecodeError(err error, status int) error {
+	return &DecodeError{err, status}
+}
+
+// Error implements the error interface. It uses the default message of the
+// wrapped error
+func (de *DecodeError) Error() string {
+	return de.Err.Error()
+}
+
+// Unwrap provides access to the wrapped error
+func (de *DecodeError) Unwrap() error {
+	return de.Err
+}
+
+// IsDecodeError checks if an error of type DecodeError exists
+func IsDecodeError(err error) bool {
+	var de *DecodeError
+	return errors.As(err, &de)
+}
+
+// GetDecodeError returns a copy of the DecodeError pointer
+func GetDecodeError(err error) *DecodeError {
+	var de *DecodeError
+	if !errors.As(err, &de) {
+		return nil
+	}
+	return de
+}
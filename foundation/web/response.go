@@ -1,31 +1,143 @@
 package web
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"net/http"
+	"strings"
 )
 
-// Respond converts a Go value to JSON and sends it to the client
+// Respond converts a Go value to the media type requested by the client in
+// the Accept header and sends it to the client. JSON is used when the client
+// has no preference. If the selected encoder can't encode the value, the next
+// acceptable media type is tried. A 406 is sent when none of the media types
+// acceptable to the client can encode the value. Successful GET responses
+// carry an ETag and a 304 is sent when the client's copy is still current
 func Respond(ctx context.Context, w http.ResponseWriter, data any, statusCode int) error {
+	return respond(ctx, w, data, statusCode, false)
+}
+
+// RespondError is like Respond but meant for error responses. The status
+// code must reach the client so the value is sent as JSON instead of a 406
+// when none of the media types acceptable to the client can encode it
+func RespondError(ctx context.Context, w http.ResponseWriter, data any, statusCode int) error {
+	return respond(ctx, w, data, statusCode, true)
+}
+
+func respond(ctx context.Context, w http.ResponseWriter, data any, statusCode int, fallbackJSON bool) error {
 	SetStatusCode(ctx, statusCode)
 
+	if statusCode >= 200 && statusCode < 300 {
+		setCacheHeaders(ctx, w)
+	}
+
 	if statusCode == http.StatusNoContent {
 		w.WriteHeader(statusCode)
 		return nil
 	}
 
-	jsonData, err := json.Marshal(data)
+	var accept string
+	if r := getRequest(ctx); r != nil {
+		accept = r.Header.Get("Accept")
+	}
+
+	b, mediaType, err := encode(negotiate(accept), data)
 	if err != nil {
 		return err
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	if mediaType == "" {
+		if fallbackJSON {
+			return RespondJSON(ctx, w, data, statusCode, MediaTypeJSON)
+		}
+		return respondNotAcceptable(ctx, w)
+	}
+
+	addVary(w.Header(), "Accept")
+
+	if statusCode == http.StatusOK && notModified(ctx, w, b, mediaType) {
+		SetStatusCode(ctx, http.StatusNotModified)
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", mediaType)
 	w.WriteHeader(statusCode)
 
-	if _, err := w.Write(jsonData); err != nil {
+	if _, err := w.Write(b); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// encode encodes the value with the first of the media types that can
+// encode it. The media type is empty when none of them can. A value JSON
+// can't encode is a bug so that error is returned
+func encode(mediaTypes []string, data any) ([]byte, string, error) {
+	var b bytes.Buffer
+	for _, mediaType := range mediaTypes {
+		b.Reset()
+
+		enc := lookupEncoder(mediaType)
+		if err := enc(&b, data); err != nil {
+			if mediaType == MediaTypeJSON {
+				return nil, "", err
+			}
+			continue
+		}
+
+		return b.Bytes(), mediaType, nil
+	}
+
+	return nil, "", nil
+}
+
+// RespondJSON sends the value as JSON with the content type regardless of
+// the Accept header. It's meant for JSON based media types that are part of
+// the protocol, like application/problem+json for errors
+func RespondJSON(ctx context.Context, w http.ResponseWriter, data any, statusCode int, contentType string) error {
+	SetStatusCode(ctx, statusCode)
+
+	var b bytes.Buffer
+	if err := encodeJSON(&b, data); err != nil {
+		return err
+	}
+
+	addVary(w.Header(), "Accept")
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// respondNotAcceptable tells the client none of the media types it accepts
+// can be produced and lists the ones that can
+func respondNotAcceptable(ctx context.Context, w http.ResponseWriter) error {
+	SetStatusCode(ctx, http.StatusNotAcceptable)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	addVary(w.Header(), "Accept")
+	w.WriteHeader(http.StatusNotAcceptable)
+
+	msg := http.StatusText(http.StatusNotAcceptable) + ": supported media types are " + strings.Join(MediaTypes(), ", ")
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// addVary adds the header field to the Vary header unless it's already
+// listed
+func addVary(header http.Header, field string) {
+	if headerContains(header, "Vary", field) {
+		return
+	}
+
+	header.Add("Vary", field)
+}
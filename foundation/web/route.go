@@ -0,0 +1,201 @@
+package web
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Route represents the information the app keeps about a registered route.
+// The information is used to document and inspect the API. Handle returns
+// the route so it can be described when it's registered
+type Route struct {
+	method      string
+	path        string
+	summary     string
+	description string
+	tags        []string
+	request     any
+	responses   map[int]any
+	annotations map[string]string
+	middleware  []string
+	public      bool
+	deprecated  bool
+}
+
+// RouteInfo represents the information about a registered route that is
+// used to inspect the app
+type RouteInfo struct {
+	Method      string            `json:"method"`
+	Path        string            `json:"path"`
+	Summary     string            `json:"summary,omitempty"`
+	Middleware  []string          `json:"middleware"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Public      bool              `json:"public"`
+	Deprecated  bool              `json:"deprecated,omitempty"`
+}
+
+// Summary sets a short summary of what the route does
+func (rt *Route) Summary(summary string) *Route {
+	rt.summary = summary
+	return rt
+}
+
+// Description sets a longer explanation of the route's behavior
+func (rt *Route) Description(description string) *Route {
+	rt.description = description
+	return rt
+}
+
+// Tags sets the tags used to group the route with related routes
+func (rt *Route) Tags(tags ...string) *Route {
+	rt.tags = tags
+	return rt
+}
+
+// Request sets the type of the request body the route decodes. Pass a
+// value of the type, like user.NewUser{}
+func (rt *Route) Request(body any) *Route {
+	rt.request = body
+	return rt
+}
+
+// Public marks the route as deliberately accessible without authentication
+func (rt *Route) Public() *Route {
+	rt.public = true
+	return rt
+}
+
+// Response sets the type of the body the route responds with for the status
+// code. Pass a value of the type or nil when there is no body
+func (rt *Route) Response(statusCode int, body any) *Route {
+	if rt.responses == nil {
+		rt.responses = make(map[int]any)
+	}
+	rt.responses[statusCode] = body
+	return rt
+}
+
+// =============================================================================
+
+// Set of annotation keys with a meaning to the app
+const (
+	// AnnotationSecurity holds the name of the security scheme clients use
+	// to authenticate for the route
+	AnnotationSecurity = "security"
+
+	// AnnotationRule holds the authorization rule the route enforces
+	AnnotationRule = "rule"
+)
+
+// nameDeprecate is the name of the Deprecate middleware as reported by
+// MiddlewareName
+const nameDeprecate = "web.Deprecate"
+
+// Annotate records information about the route, like the authorization
+// rule it enforces
+func (rt *Route) Annotate(key string, value string) *Route {
+	if rt.annotations == nil {
+		rt.annotations = make(map[string]string)
+	}
+	rt.annotations[key] = value
+	return rt
+}
+
+// wrapRoute wraps the middleware around the handler of the route and records
+// the names of the middleware on it. A route wrapped by Deprecate is marked
+// as deprecated
+func wrapRoute(rt *Route, mw []Middleware, handler Handler) Handler {
+	// The middleware is wrapped from the inside out so the names are added
+	// in front of the ones of the middleware that runs after it.
+	names := make([]string, len(mw))
+	for i, m := range mw {
+		names[i] = MiddlewareName(m)
+		if names[i] == nameDeprecate {
+			rt.deprecated = true
+		}
+	}
+	rt.middleware = append(names, rt.middleware...)
+
+	return wrapMiddleware(mw, handler)
+}
+
+// Routes returns the information about the registered routes sorted by path
+// and method. The middleware is listed in the order it's executed
+func (a *App) Routes() []RouteInfo {
+	routes := a.routes()
+
+	infos := make([]RouteInfo, len(routes))
+	for i, rt := range routes {
+		annotations := make(map[string]string, len(rt.annotations))
+		for k, v := range rt.annotations {
+			annotations[k] = v
+		}
+
+		infos[i] = RouteInfo{
+			Method:      rt.method,
+			Path:        rt.path,
+			Summary:     rt.summary,
+			Middleware:  append([]string(nil), rt.middleware...),
+			Annotations: annotations,
+			Public:      rt.public,
+			Deprecated:  rt.deprecated,
+		}
+	}
+
+	return infos
+}
+
+// MiddlewareName returns the name of the function that constructed the
+// middleware, like mid.Authenticate
+func MiddlewareName(mw Middleware) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(mw).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+
+	return funcName(fn.Name())
+}
+
+// funcName removes the package path and the closure suffixes from the name
+// of a function. The name is the package path followed by the function, with
+// a suffix for every closure like mid.Authorize.func1. The closures of a
+// function inlined into its caller are numbered without the func prefix,
+// like mid.Authorize.1
+func funcName(name string) string {
+	name = name[strings.LastIndex(name, "/")+1:]
+
+	for {
+		i := strings.LastIndex(name, ".")
+		if i == -1 {
+			break
+		}
+
+		n := strings.TrimPrefix(name[i+1:], "func")
+		if n == "" || strings.Trim(n, "0123456789") != "" {
+			break
+		}
+		name = name[:i]
+	}
+
+	return name
+}
+
+// routes returns the registered routes sorted by path and method
+func (a *App) routes() []*Route {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	routes := make([]*Route, len(a.registered))
+	copy(routes, a.registered)
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].path != routes[j].path {
+			return routes[i].path < routes[j].path
+		}
+		return routes[i].method < routes[j].method
+	})
+
+	return routes
+}
@@ -0,0 +1,129 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRouteAnnotations(t *testing.T) {
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	}
+
+	deprecate := Deprecate(Deprecation{Sunset: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)})
+
+	app := NewApp(nil, nil)
+
+	admin := app.Group("/admin").
+		Annotate(AnnotationSecurity, "bearer").
+		Annotate(AnnotationRule, "admin_only")
+	admin.Handle(http.MethodGet, "/a", handler)
+	admin.Handle(http.MethodGet, "/b", handler).
+		Annotate(AnnotationRule, "user_only")
+
+	// A nested group starts with the annotations of its parent and can
+	// change them without affecting the parent.
+	admin.Group("/c").
+		Annotate(AnnotationRule, "any").
+		Handle(http.MethodGet, "/d", handler)
+	admin.Handle(http.MethodGet, "/e", handler)
+
+	app.Handle(http.MethodGet, "/old", handler, deprecate)
+
+	tests := []struct {
+		path       string
+		want       map[string]string
+		deprecated bool
+	}{
+		{"/admin/a", map[string]string{"security": "bearer", "rule": "admin_only"}, false},
+		{"/admin/b", map[string]string{"security": "bearer", "rule": "user_only"}, false},
+		{"/admin/c/d", map[string]string{"security": "bearer", "rule": "any"}, false},
+		{"/admin/e", map[string]string{"security": "bearer", "rule": "admin_only"}, false},
+		{"/old", map[string]string{}, true},
+	}
+
+	routes := app.Routes()
+	if len(routes) != len(tests) {
+		t.Fatalf("got %d routes, want %d", len(routes), len(tests))
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rt := routes[i]
+			if rt.Path != tt.path {
+				t.Fatalf("path = %s, want %s", rt.Path, tt.path)
+			}
+			if !reflect.DeepEqual(rt.Annotations, tt.want) {
+				t.Errorf("annotations = %v, want %v", rt.Annotations, tt.want)
+			}
+			if rt.Deprecated != tt.deprecated {
+				t.Errorf("deprecated = %t, want %t", rt.Deprecated, tt.deprecated)
+			}
+		})
+	}
+
+	// The deprecated route is reported in the values of the requests it
+	// handles.
+	var got string
+	record := func(handler Handler) Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			err := handler(ctx, w, r)
+			got = GetValues(ctx).DeprecatedRoute
+			return err
+		}
+		return h
+	}
+
+	app = NewApp(nil, nil, record)
+	app.Handle(http.MethodGet, "/old/:id", handler, deprecate)
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/old/1", nil))
+
+	if want := "GET /old/:id"; got != want {
+		t.Errorf("deprecated route = %q, want %q", got, want)
+	}
+}
+
+func TestMiddlewareName(t *testing.T) {
+	tests := []struct {
+		name string
+		mw   Middleware
+		want string
+	}{
+		{"func", CacheControl("no-store"), "web.CacheControl"},
+		{"closure", Deprecate(Deprecation{}), "web.Deprecate"},
+		{"nil", nil, "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MiddlewareName(tt.mw); got != tt.want {
+				t.Errorf("MiddlewareName = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuncName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"github.com/theo-bot/service4.1-video/business/web/v1/mid.Authorize.func1", "mid.Authorize"},
+		{"github.com/theo-bot/service4.1-video/business/web/v1/mid.Errors.func1.2", "mid.Errors"},
+		{"github.com/theo-bot/service4.1-video/business/web/v1/mid.Errors.1", "mid.Errors"},
+		{"github.com/theo-bot/service4.1-video/foundation/web.CacheControl", "web.CacheControl"},
+		{"main.main.func1", "main.main"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := funcName(tt.name); got != tt.want {
+				t.Errorf("funcName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
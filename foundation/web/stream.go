@@ -0,0 +1,201 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NextFunc returns the next record to send to the client. It must return
+// io.EOF when there are no more records to send
+type NextFunc func(ctx context.Context) (any, error)
+
+// FromChannel returns a NextFunc that receives records from the channel
+// until the channel is closed
+func FromChannel[T any](ch <-chan T) NextFunc {
+	f := func(ctx context.Context) (any, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case v, ok := <-ch:
+			if !ok {
+				return nil, io.EOF
+			}
+			return v, nil
+		}
+	}
+
+	return f
+}
+
+// RespondStream sends the records returned by next to the client as NDJSON.
+// Each record is flushed as soon as it's written so the client receives it
+// without waiting for the rest of the stream
+func RespondStream(ctx context.Context, w http.ResponseWriter, next NextFunc, statusCode int) error {
+	enc := json.NewEncoder(w)
+
+	write := func(rec any) error {
+		return enc.Encode(rec)
+	}
+
+	w.Header().Set("Content-Type", MediaTypeNDJSON)
+
+	return stream(ctx, w, next, statusCode, write)
+}
+
+// Event represents a server-sent event. Records sent through SSE that are
+// not an Event are sent as the data of an unnamed event
+type Event struct {
+	ID    string
+	Name  string
+	Data  any
+	Retry time.Duration
+}
+
+// SSE sends the records returned by next to the client as server-sent
+// events. Strings are sent as is and any other data is sent as JSON
+func SSE(ctx context.Context, w http.ResponseWriter, next NextFunc) error {
+	write := func(rec any) error {
+		evt, ok := rec.(Event)
+		if !ok {
+			evt = Event{Data: rec}
+		}
+
+		return writeEvent(w, evt)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	return stream(ctx, w, next, http.StatusOK, write)
+}
+
+// stream commits the response and writes the records returned by next
+// until there are no more records, the client goes away or next fails.
+// Once the response is committed every error is returned as a stream error
+func stream(ctx context.Context, w http.ResponseWriter, next NextFunc, statusCode int, write func(rec any) error) error {
+	SetStatusCode(ctx, statusCode)
+
+	rc := http.NewResponseController(w)
+
+	// A stream can legitimately take longer than the server's write timeout
+	// so the deadline is removed for the life of the stream.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	w.WriteHeader(statusCode)
+	if err := flush(rc); err != nil {
+		return newStreamError(err)
+	}
+
+	for {
+		rec, err := next(ctx)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return newStreamError(err)
+		}
+
+		if err := write(rec); err != nil {
+			return newStreamError(err)
+		}
+
+		if err := flush(rc); err != nil {
+			return newStreamError(err)
+		}
+	}
+}
+
+// flush sends any buffered data to the client. Writers that don't support
+// flushing are ignored
+func flush(rc *http.ResponseController) error {
+	if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	return nil
+}
+
+// writeEvent writes a single event using the text/event-stream format
+func writeEvent(w io.Writer, evt Event) error {
+	var b strings.Builder
+
+	if evt.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", escapeField(evt.ID))
+	}
+	if evt.Name != "" {
+		fmt.Fprintf(&b, "event: %s\n", escapeField(evt.Name))
+	}
+	if evt.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %s\n", strconv.FormatInt(evt.Retry.Milliseconds(), 10))
+	}
+
+	data, ok := evt.Data.(string)
+	if !ok {
+		jsonData, err := json.Marshal(evt.Data)
+		if err != nil {
+			return err
+		}
+		data = string(jsonData)
+	}
+
+	// Any line ending ends a line of the event so every line of the data is
+	// sent as a data field.
+	data = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(data)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// fieldEscaper escapes the line endings that would end a field of an event
+var fieldEscaper = strings.NewReplacer("\r", `\r`, "\n", `\n`)
+
+// escapeField escapes the line endings in the value of a single line field,
+// like the id of an event, so the value can't inject other fields
+func escapeField(value string) string {
+	return fieldEscaper.Replace(value)
+}
+
+// =============================================================================
+
+// streamError is used to report an error that happened after a stream
+// committed the response. The client can't be told about these errors
+type streamError struct {
+	err error
+}
+
+// newStreamError wraps the error in a streamError
+func newStreamError(err error) error {
+	return &streamError{err}
+}
+
+// Error is the implementation of the error interface
+func (se *streamError) Error() string {
+	return "streaming: " + se.err.Error()
+}
+
+// Unwrap provides access to the wrapped error
+func (se *streamError) Unwrap() error {
+	return se.err
+}
+
+// IsStreamError checks if the error happened after a stream committed the
+// response to the client
+func IsStreamError(err error) bool {
+	var se *streamError
+	return errors.As(err, &se)
+}
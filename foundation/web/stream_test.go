@@ -0,0 +1,220 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// streamApp returns an app with a single streaming route at /stream. The
+// error returned by the handler is sent on the errs channel
+func streamApp(shutdown chan os.Signal, next NextFunc, errs chan<- error) *App {
+	app := NewApp(shutdown, nil)
+
+	app.Handle(http.MethodGet, "/stream", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		err := RespondStream(ctx, w, next, http.StatusOK)
+		errs <- err
+		return err
+	})
+
+	return app
+}
+
+func TestStreamFlush(t *testing.T) {
+	ch := make(chan int)
+	errs := make(chan error, 1)
+
+	srv := httptest.NewServer(streamApp(make(chan os.Signal, 1), FromChannel(ch), errs))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stream")
+	if err != nil {
+		t.Fatalf("requesting stream: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != MediaTypeNDJSON {
+		t.Errorf("content type = %q, want %q", ct, MediaTypeNDJSON)
+	}
+
+	// Every record has to be received before the next one is sent, which
+	// only happens when each record is flushed as soon as it's written.
+	rd := bufio.NewReader(resp.Body)
+	for i := 1; i <= 3; i++ {
+		select {
+		case ch <- i:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("record %d was not read by the stream", i)
+		}
+
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading record %d: %s", i, err)
+		}
+		if want := fmt.Sprintf("%d\n", i); line != want {
+			t.Errorf("record = %q, want %q", line, want)
+		}
+	}
+
+	close(ch)
+
+	if err := <-errs; err != nil {
+		t.Errorf("stream error = %v, want nil", err)
+	}
+	if _, err := rd.ReadString('\n'); !errors.Is(err, io.EOF) {
+		t.Errorf("reading after the end of the stream: %v, want EOF", err)
+	}
+}
+
+func TestStreamDisconnect(t *testing.T) {
+	ch := make(chan int)
+	errs := make(chan error, 1)
+	shutdown := make(chan os.Signal, 1)
+
+	srv := httptest.NewServer(streamApp(shutdown, FromChannel(ch), errs))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/stream", nil)
+	if err != nil {
+		t.Fatalf("creating request: %s", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("requesting stream: %s", err)
+	}
+	defer resp.Body.Close()
+
+	ch <- 1
+	if _, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil {
+		t.Fatalf("reading record: %s", err)
+	}
+
+	// The client goes away while the stream waits for its next record.
+	cancel()
+
+	select {
+	case err := <-errs:
+		if !IsStreamError(err) {
+			t.Errorf("error = %v, want a stream error", err)
+		}
+		if !IsDisconnect(err) {
+			t.Errorf("error = %v, want a disconnect", err)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end when the client went away")
+	}
+
+	// The base handler runs after the handler returns so the server has to
+	// be closed before checking it didn't signal a shutdown.
+	srv.Close()
+
+	select {
+	case sig := <-shutdown:
+		t.Errorf("client disconnect signaled a shutdown: %v", sig)
+	default:
+	}
+}
+
+func TestStreamShutdown(t *testing.T) {
+	errs := make(chan error, 1)
+	shutdown := make(chan os.Signal, 1)
+
+	sent := false
+	next := func(ctx context.Context) (any, error) {
+		if sent {
+			return nil, NewShutdownError("integrity issue")
+		}
+		sent = true
+		return 1, nil
+	}
+
+	app := streamApp(shutdown, next, errs)
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status code = %d, want %d", w.Code, http.StatusOK)
+	}
+	if body := w.Body.String(); body != "1\n" {
+		t.Errorf("body = %q, want %q", body, "1\n")
+	}
+
+	err := <-errs
+	if !IsStreamError(err) || !IsShutdown(err) {
+		t.Errorf("error = %v, want a stream error wrapping a shutdown error", err)
+	}
+
+	select {
+	case <-shutdown:
+	default:
+		t.Error("shutdown error returned during the stream did not signal a shutdown")
+	}
+}
+
+func TestValidateShutdown(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"error", errors.New("db down"), true},
+		{"shutdown", NewShutdownError("integrity issue"), true},
+		{"broken pipe", fmt.Errorf("writing: %w", syscall.EPIPE), false},
+		{"connection reset", fmt.Errorf("reading: %w", syscall.ECONNRESET), false},
+		{"canceled", context.Canceled, false},
+		{"stream disconnect", newStreamError(syscall.EPIPE), false},
+		{"stream error", newStreamError(errors.New("db down")), true},
+		{"stream shutdown", newStreamError(NewShutdownError("integrity issue")), true},
+		{"shutdown after disconnect", newStreamError(fmt.Errorf("%w: %w", context.Canceled, NewShutdownError("integrity issue"))), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateShutdown(tt.err); got != tt.want {
+				t.Errorf("validateShutdown(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		evt  Event
+		want string
+	}{
+		{"string", Event{Data: "hello"}, "data: hello\n\n"},
+		{"json", Event{Data: map[string]int{"n": 1}}, "data: {\"n\":1}\n\n"},
+		{"fields", Event{ID: "7", Name: "update", Data: "x", Retry: 2 * time.Second}, "id: 7\nevent: update\nretry: 2000\ndata: x\n\n"},
+		{"multiline data", Event{Data: "a\nb\r\nc\rd"}, "data: a\ndata: b\ndata: c\ndata: d\n\n"},
+		{"escaped id", Event{ID: "1\ndata: injected", Data: "x"}, "id: 1\\ndata: injected\ndata: x\n\n"},
+		{"escaped name", Event{Name: "a\r\nevent: b", Data: "x"}, "event: a\\r\\nevent: b\ndata: x\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := writeEvent(&b, tt.evt); err != nil {
+				t.Fatalf("writing event: %s", err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("event = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
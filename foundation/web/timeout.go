@@ -0,0 +1,202 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Timeout sets a deadline on the context of the handlers it wraps. Pass it
+// to Handle or Group like any other middleware. When the deadline is
+// exceeded before the handler responds, an error wrapping
+// context.DeadlineExceeded is returned so the error handling middleware can
+// respond to the client while the handler is still running. Any write the
+// handler makes after that is discarded. A handler that already committed
+// its response, or whose client canceled the request, is waited for like it
+// would be without a deadline
+func Timeout(d time.Duration) Middleware {
+	m := func(handler Handler) Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			// The handler gets its own copy of the request values since it
+			// may still be running after the error response has been sent.
+			// The values are copied back when the handler is waited for.
+			v, ok := ctx.Value(key).(*Values)
+			if !ok {
+				v = &Values{}
+			}
+			hv := *v
+			ctx = context.WithValue(ctx, key, &hv)
+
+			tw := timeoutWriter{
+				w:      w,
+				header: w.Header().Clone(),
+			}
+
+			done := make(chan error, 1)
+			panicChan := make(chan any, 1)
+
+			go func() {
+				defer func() {
+					if rec := recover(); rec != nil {
+						panicChan <- fmt.Sprintf("%v\n%s", rec, debug.Stack())
+					}
+				}()
+
+				done <- handler(ctx, &tw, r.WithContext(ctx))
+			}()
+
+			wait := func() error {
+				select {
+				case rec := <-panicChan:
+					// Re-panic on the request goroutine so the panic is
+					// handled by the middleware wrapping this one.
+					panic(rec)
+
+				case err := <-done:
+					*v = hv
+					return err
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+			case rec := <-panicChan:
+				panic(rec)
+			case err := <-done:
+				*v = hv
+				return err
+			}
+
+			// The client went away before the deadline so this isn't a
+			// timeout. The handler sees the canceled context and is waited
+			// for so its status is known to the logger.
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return wait()
+			}
+
+			tw.mu.Lock()
+
+			// The handler committed its response before the deadline so the
+			// client can't be told about the timeout anymore. The handler
+			// sees the expired context and finishes the response.
+			if tw.wroteHeader {
+				tw.mu.Unlock()
+				return wait()
+			}
+
+			tw.timedOut = true
+			tw.mu.Unlock()
+
+			// The handler may have returned right as the deadline was
+			// exceeded without writing anything, its result is used then.
+			select {
+			case rec := <-panicChan:
+				panic(rec)
+			case err := <-done:
+				*v = hv
+				return err
+			default:
+			}
+
+			return fmt.Errorf("handler did not complete within %s: %w", d, ctx.Err())
+		}
+
+		return h
+	}
+
+	return m
+}
+
+// timeoutWriter guards the response writer from a handler that keeps on
+// running after its deadline is exceeded. The handler gets its own copy of
+// the headers which is only copied to the response when it commits the
+// response
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mu          sync.Mutex
+	timedOut    bool
+	wroteHeader bool
+}
+
+// Header returns the header map for the handler
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// Write sends the data to the client unless the deadline has been exceeded
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+
+	return tw.w.Write(p)
+}
+
+// WriteHeader sends the status code and headers to the client unless the
+// deadline has been exceeded
+func (tw *timeoutWriter) WriteHeader(statusCode int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+
+	tw.writeHeaderLocked(statusCode)
+}
+
+// writeHeaderLocked copies the handler's headers to the response and writes
+// the status code. The mutex must be held by the caller
+func (tw *timeoutWriter) writeHeaderLocked(statusCode int) {
+	dst := tw.w.Header()
+	for k := range dst {
+		if _, exists := tw.header[k]; !exists {
+			delete(dst, k)
+		}
+	}
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+
+	tw.wroteHeader = true
+	tw.w.WriteHeader(statusCode)
+}
+
+// Flush sends any buffered data to the client unless the deadline has been
+// exceeded
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return
+	}
+
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+
+	http.NewResponseController(tw.w).Flush()
+}
+
+// Unwrap provides access to the underlying response writer for the
+// http.ResponseController
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
@@ -0,0 +1,137 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	t.Run("deadline exceeded", func(t *testing.T) {
+		lateWrite := make(chan error, 1)
+		handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			w.Header().Set("X-Late", "true")
+			_, err := w.Write([]byte("late"))
+			lateWrite <- err
+			return nil
+		}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		err := Timeout(10*time.Millisecond)(handler)(context.Background(), w, r)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("error = %v, want context.DeadlineExceeded", err)
+		}
+		if IsStreamError(err) {
+			t.Errorf("error is a stream error, the client can still be told about the timeout")
+		}
+
+		if err := <-lateWrite; !errors.Is(err, http.ErrHandlerTimeout) {
+			t.Errorf("late write error = %v, want http.ErrHandlerTimeout", err)
+		}
+		if w.Body.Len() != 0 || w.Header().Get("X-Late") != "" {
+			t.Errorf("late write reached the client: headers %v body %q", w.Header(), w.Body.String())
+		}
+	})
+
+	t.Run("response started", func(t *testing.T) {
+		handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			SetStatusCode(ctx, http.StatusOK)
+			w.WriteHeader(http.StatusOK)
+			<-ctx.Done()
+			_, err := w.Write([]byte("rest"))
+			return err
+		}
+
+		v := Values{}
+		ctx := context.WithValue(context.Background(), key, &v)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		if err := Timeout(10*time.Millisecond)(handler)(ctx, w, r); err != nil {
+			t.Fatalf("error = %v, want nil", err)
+		}
+		if w.Body.String() != "rest" {
+			t.Errorf("body = %q, want the handler to finish the response", w.Body.String())
+		}
+		if v.StatusCode != http.StatusOK {
+			t.Errorf("values status = %d, want %d", v.StatusCode, http.StatusOK)
+		}
+	})
+
+	t.Run("returned at deadline", func(t *testing.T) {
+		handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			SetStatusCode(ctx, http.StatusNoContent)
+			<-ctx.Done()
+			return nil
+		}
+
+		v := Values{}
+		ctx := context.WithValue(context.Background(), key, &v)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		// The handler returns as soon as the deadline is exceeded so either
+		// its result or the timeout is reported, never anything else.
+		err := Timeout(10*time.Millisecond)(handler)(ctx, w, r)
+		switch {
+		case err == nil:
+			if v.StatusCode != http.StatusNoContent {
+				t.Errorf("values status = %d, want %d", v.StatusCode, http.StatusNoContent)
+			}
+		case !errors.Is(err, context.DeadlineExceeded) || IsStreamError(err):
+			t.Errorf("error = %v, want nil or context.DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("client cancel", func(t *testing.T) {
+		handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			SetStatusCode(ctx, http.StatusServiceUnavailable)
+			return ctx.Err()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		v := Values{}
+		ctx = context.WithValue(ctx, key, &v)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		err := Timeout(time.Minute)(handler)(ctx, w, r)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("error = %v, want the handler's context.Canceled", err)
+		}
+		if v.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("values status = %d, want %d", v.StatusCode, http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("in time", func(t *testing.T) {
+		handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			SetStatusCode(ctx, http.StatusCreated)
+			w.WriteHeader(http.StatusCreated)
+			return nil
+		}
+
+		v := Values{}
+		ctx := context.WithValue(context.Background(), key, &v)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		if err := Timeout(time.Minute)(handler)(ctx, w, r); err != nil {
+			t.Fatalf("error = %v, want nil", err)
+		}
+		if w.Code != http.StatusCreated || v.StatusCode != http.StatusCreated {
+			t.Errorf("status = %d, values status = %d, want %d", w.Code, v.StatusCode, http.StatusCreated)
+		}
+	})
+}
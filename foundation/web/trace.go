@@ -0,0 +1,176 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"strings"
+)
+
+// Set of headers used to propagate the trace context between services. The
+// traceparent and tracestate headers are defined by the W3C Trace Context
+// specification: https://www.w3.org/TR/trace-context/
+const (
+	headerTraceParent = "traceparent"
+	headerTraceState  = "tracestate"
+	headerTraceID     = "X-Trace-ID"
+)
+
+// Set of zero values for the identifiers used when no trace is available
+const (
+	zeroTraceID = "00000000000000000000000000000000"
+	zeroSpanID  = "0000000000000000"
+)
+
+// defaultTraceFlags marks a trace started by this service as sampled
+const defaultTraceFlags = "01"
+
+// traceContext represents the trace information received from a caller
+type traceContext struct {
+	traceID  string
+	parentID string
+	flags    string
+	state    string
+}
+
+// extractTraceContext parses the traceparent and tracestate headers of the
+// request. A new trace is started when the traceparent header is missing
+// or invalid
+func extractTraceContext(r *http.Request) traceContext {
+	tc, err := parseTraceParent(r.Header.Get(headerTraceParent))
+	if err != nil {
+		return traceContext{
+			traceID: newID(16),
+			flags:   defaultTraceFlags,
+		}
+	}
+
+	// The tracestate header is only meaningful as part of the trace the
+	// traceparent header belongs to.
+	tc.state = strings.Join(r.Header.Values(headerTraceState), ",")
+
+	return tc
+}
+
+// parseTraceParent parses a traceparent header in the form of
+// version-traceid-parentid-flags
+func parseTraceParent(value string) (traceContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return traceContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+
+	switch {
+	case !isHex(version, 2) || version == "ff":
+		return traceContext{}, fmt.Errorf("invalid traceparent version %q", version)
+
+	// Version 00 has exactly four fields. Later versions may add fields
+	// which this version of the parser ignores.
+	case version == "00" && len(parts) != 4:
+		return traceContext{}, fmt.Errorf("invalid traceparent %q", value)
+
+	case !isHex(traceID, 32) || traceID == zeroTraceID:
+		return traceContext{}, fmt.Errorf("invalid trace id %q", traceID)
+
+	case !isHex(parentID, 16) || parentID == zeroSpanID:
+		return traceContext{}, fmt.Errorf("invalid parent id %q", parentID)
+
+	case !isHex(flags, 2):
+		return traceContext{}, fmt.Errorf("invalid trace flags %q", flags)
+	}
+
+	tc := traceContext{
+		traceID:  traceID,
+		parentID: parentID,
+		flags:    flags,
+	}
+
+	return tc, nil
+}
+
+// startSpan starts the root span for the request. When the caller provided
+// a trace context the span is started as a child of the caller's span
+func startSpan(ctx context.Context, tracer trace.Tracer, tc traceContext, name string) (context.Context, trace.Span) {
+	if tc.parentID != "" {
+		traceID, _ := trace.TraceIDFromHex(tc.traceID)
+		spanID, _ := trace.SpanIDFromHex(tc.parentID)
+		flags, _ := hex.DecodeString(tc.flags)
+
+		// An invalid tracestate is dropped, the trace itself is still
+		// valid without it.
+		state, _ := trace.ParseTraceState(tc.state)
+
+		sc := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     spanID,
+			TraceFlags: trace.TraceFlags(flags[0]),
+			TraceState: state,
+			Remote:     true,
+		})
+
+		ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+	}
+
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// AddSpan starts a new span as a child of the span in the context. The
+// caller is responsible for ending the span. When the context isn't part of
+// a request, the tracer of the span already in the context is used
+func AddSpan(ctx context.Context, spanName string, keyValues ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer("")
+	if v, ok := ctx.Value(key).(*Values); ok && v.Tracer != nil {
+		tracer = v.Tracer
+	}
+
+	ctx, span := tracer.Start(ctx, spanName)
+	span.SetAttributes(keyValues...)
+
+	return ctx, span
+}
+
+// setTraceHeaders echoes the trace context on the response so the caller
+// can correlate its request with this service's logs
+func setTraceHeaders(w http.ResponseWriter, v *Values) {
+	h := w.Header()
+
+	h.Set(headerTraceParent, fmt.Sprintf("00-%s-%s-%s", v.TraceID, v.SpanID, v.TraceFlags))
+	if v.TraceState != "" {
+		h.Set(headerTraceState, v.TraceState)
+	}
+	h.Set(headerTraceID, v.TraceID)
+}
+
+// newID generates a random identifier of the specified number of bytes
+// encoded as lowercase hex
+func newID(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		// The system's random source failing leaves us without a way to
+		// generate unique identifiers.
+		panic(fmt.Sprintf("generating id: %s", err))
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// isHex checks the value is lowercase hex of the specified length
+func isHex(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+
+	for _, c := range value {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,152 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	const (
+		traceID  = "0af7651916cd43dd8448eb211c80319c"
+		parentID = "b7ad6b7169203331"
+	)
+
+	tests := []struct {
+		name    string
+		value   string
+		success bool
+		flags   string
+	}{
+		{"valid", "00-" + traceID + "-" + parentID + "-01", true, "01"},
+		{"not sampled", "00-" + traceID + "-" + parentID + "-00", true, "00"},
+		{"surrounding spaces", " 00-" + traceID + "-" + parentID + "-01 ", true, "01"},
+		{"later version", "01-" + traceID + "-" + parentID + "-01", true, "01"},
+		{"later version with more fields", "01-" + traceID + "-" + parentID + "-01-extra", true, "01"},
+		{"empty", "", false, ""},
+		{"zero trace id", "00-" + zeroTraceID + "-" + parentID + "-01", false, ""},
+		{"zero parent id", "00-" + traceID + "-" + zeroSpanID + "-01", false, ""},
+		{"version ff", "ff-" + traceID + "-" + parentID + "-01", false, ""},
+		{"version 00 with more fields", "00-" + traceID + "-" + parentID + "-01-extra", false, ""},
+		{"uppercase trace id", "00-" + strings.ToUpper(traceID) + "-" + parentID + "-01", false, ""},
+		{"uppercase parent id", "00-" + traceID + "-" + strings.ToUpper(parentID) + "-01", false, ""},
+		{"uppercase version", "0A-" + traceID + "-" + parentID + "-01", false, ""},
+		{"short trace id", "00-" + traceID[1:] + "-" + parentID + "-01", false, ""},
+		{"long trace id", "00-" + traceID + "0-" + parentID + "-01", false, ""},
+		{"short parent id", "00-" + traceID + "-" + parentID[1:] + "-01", false, ""},
+		{"long parent id", "00-" + traceID + "-" + parentID + "0-01", false, ""},
+		{"short flags", "00-" + traceID + "-" + parentID + "-1", false, ""},
+		{"long version", "000-" + traceID + "-" + parentID + "-01", false, ""},
+		{"not hex", "00-" + strings.Repeat("g", 32) + "-" + parentID + "-01", false, ""},
+		{"missing flags", "00-" + traceID + "-" + parentID, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := parseTraceParent(tt.value)
+			if (err == nil) != tt.success {
+				t.Fatalf("error = %v, want success %t", err, tt.success)
+			}
+			if !tt.success {
+				return
+			}
+
+			if tc.traceID != traceID || tc.parentID != parentID || tc.flags != tt.flags {
+				t.Errorf("trace context = %+v, want %s %s %s", tc, traceID, parentID, tt.flags)
+			}
+		})
+	}
+}
+
+func TestExtractTraceContext(t *testing.T) {
+	const traceParent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		fresh   bool
+		traceID string
+		state   string
+	}{
+		{
+			name:    "caller",
+			header:  http.Header{"Traceparent": {traceParent}, "Tracestate": {"a=1", "b=2"}},
+			traceID: "0af7651916cd43dd8448eb211c80319c",
+			state:   "a=1,b=2",
+		},
+		{
+			name:  "missing",
+			fresh: true,
+		},
+		{
+			name:   "invalid",
+			header: http.Header{"Traceparent": {"00-" + zeroTraceID + "-b7ad6b7169203331-01"}},
+			fresh:  true,
+		},
+		{
+			name:   "state without parent",
+			header: http.Header{"Tracestate": {"a=1"}},
+			fresh:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header = tt.header
+
+			tc := extractTraceContext(r)
+
+			if !tt.fresh {
+				if tc.traceID != tt.traceID || tc.state != tt.state {
+					t.Errorf("trace context = %+v, want trace %s state %s", tc, tt.traceID, tt.state)
+				}
+				return
+			}
+
+			// A new trace is started by this service.
+			if !isHex(tc.traceID, 32) || tc.traceID == zeroTraceID {
+				t.Errorf("trace id = %q, want a new id", tc.traceID)
+			}
+			if tc.parentID != "" || tc.state != "" || tc.flags != defaultTraceFlags {
+				t.Errorf("trace context = %+v, want a new trace", tc)
+			}
+			if other := extractTraceContext(r); other.traceID == tc.traceID {
+				t.Errorf("trace id %s generated twice", tc.traceID)
+			}
+		})
+	}
+}
+
+func TestTraceHeaders(t *testing.T) {
+	app := NewApp(nil, nil)
+	app.Handle(http.MethodGet, "/", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	r.Header.Set("tracestate", "a=1")
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+
+	// The response carries the caller's trace with the span of this
+	// service as the parent.
+	parts := strings.Split(w.Header().Get("traceparent"), "-")
+	if len(parts) != 4 || parts[0] != "00" || parts[1] != "0af7651916cd43dd8448eb211c80319c" || parts[3] != "01" {
+		t.Fatalf("traceparent = %q, want the caller's trace", w.Header().Get("traceparent"))
+	}
+	if !isHex(parts[2], 16) || parts[2] == "b7ad6b7169203331" {
+		t.Errorf("span id = %q, want a new span id", parts[2])
+	}
+	if got := w.Header().Get("tracestate"); got != "a=1" {
+		t.Errorf("tracestate = %q, want %q", got, "a=1")
+	}
+	if got := w.Header().Get("X-Trace-ID"); got != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("trace id header = %q", got)
+	}
+}
@@ -0,0 +1,172 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version creates a route group for a version of the API, like v2. The
+// routes of the version are registered under the /<name> prefix and run the
+// version's middleware, like a middleware responding with a different error
+// shape. Clients can also select the version with a version parameter in the
+// Accept header, like application/json; version=2, on the path without the
+// prefix
+func (a *App) Version(name string, mw ...Middleware) *Group {
+	name = strings.Trim(name, "/")
+
+	a.mu.Lock()
+	var versions []string
+	if current := a.versions.Load(); current != nil {
+		versions = append(versions, *current...)
+	}
+	versions = append(versions, name)
+	a.versions.Store(&versions)
+	a.mu.Unlock()
+
+	return a.Group("/"+name, mw...)
+}
+
+// ServeHTTP routes the request to the handler of its route. A request for a
+// path without a version prefix is routed to the version named in the Accept
+// header when that version has a route for the path
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	versions := a.versions.Load()
+	if versions == nil || hasAnyVersionPrefix(r.URL.Path, *versions) {
+		a.ContextMux.ServeHTTP(w, r)
+		return
+	}
+
+	// The route serving the path can be chosen by the Accept header so
+	// caches must keep the responses to different Accept headers apart.
+	addVary(w.Header(), "Accept")
+
+	if version := acceptVersion(r.Header.Get("Accept"), *versions); version != "" && strings.HasPrefix(r.RequestURI, "/") {
+		vr := r.Clone(r.Context())
+		vr.URL.Path = "/" + version + r.URL.Path
+		vr.URL.RawPath = ""
+
+		// The mux routes on the request URI to keep escaped slashes apart
+		// from the ones separating the path segments.
+		vr.RequestURI = "/" + version + r.RequestURI
+
+		if _, found := a.ContextMux.Lookup(w, vr); found {
+			r = vr
+		}
+	}
+
+	a.ContextMux.ServeHTTP(w, r)
+}
+
+// acceptVersion returns the version named by the version parameter of the
+// Accept header. Both 2 and v2 name the v2 version
+func acceptVersion(accept string, versions []string) string {
+	if !strings.Contains(accept, "version=") {
+		return ""
+	}
+
+	var requested string
+	for _, part := range strings.Split(accept, ",") {
+		for _, param := range strings.Split(part, ";")[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(name) == "version" {
+				requested = strings.Trim(value, `"`)
+				break
+			}
+		}
+		if requested != "" {
+			break
+		}
+	}
+
+	if requested == "" {
+		return ""
+	}
+
+	for _, version := range versions {
+		if version == requested || version == "v"+requested {
+			return version
+		}
+	}
+
+	return ""
+}
+
+// hasAnyVersionPrefix checks if the path starts with one of the versions.
+// The path takes precedence over the Accept header
+func hasAnyVersionPrefix(path string, versions []string) bool {
+	for _, version := range versions {
+		if hasVersionPrefix(path, version) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasVersionPrefix checks if the path already starts with the version
+func hasVersionPrefix(path string, version string) bool {
+	rest, found := strings.CutPrefix(path, "/"+version)
+	return found && (rest == "" || rest[0] == '/')
+}
+
+// =============================================================================
+
+// Deprecation describes the retirement of a route
+type Deprecation struct {
+	// Date is when the route was deprecated. The route is reported as
+	// deprecated without a date when it's zero
+	Date time.Time
+
+	// Sunset is when the route stops responding. It's not reported when
+	// it's zero
+	Sunset time.Time
+
+	// Link points to documentation about the deprecation, like a migration
+	// guide to the next version
+	Link string
+}
+
+// Deprecate marks the routes it wraps as deprecated. Pass it to Handle or
+// Version like any other middleware. Responses carry the Deprecation, Sunset
+// and Link headers so clients learn about it, and the route is recorded in
+// the request values so the requests can be counted
+func Deprecate(d Deprecation) Middleware {
+	deprecation := "true"
+	if !d.Date.IsZero() {
+		deprecation = "@" + strconv.FormatInt(d.Date.Unix(), 10)
+	}
+
+	var sunset string
+	if !d.Sunset.IsZero() {
+		sunset = d.Sunset.UTC().Format(http.TimeFormat)
+	}
+
+	var link string
+	if d.Link != "" {
+		link = "<" + d.Link + `>; rel="deprecation"; type="text/html"`
+	}
+
+	m := func(handler Handler) Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("Deprecation", deprecation)
+			if sunset != "" {
+				w.Header().Set("Sunset", sunset)
+			}
+			if link != "" {
+				w.Header().Add("Link", link)
+			}
+
+			v := GetValues(ctx)
+			v.DeprecatedRoute = v.route
+
+			return handler(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return m
+}
@@ -0,0 +1,156 @@
+package web
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVersionRouting(t *testing.T) {
+
+	// The handlers write the body themselves so the headers checked are
+	// the ones of the routing and not the ones of Respond.
+	text := func(body string) Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			_, err := io.WriteString(w, body+" "+Param(r, "id"))
+			return err
+		}
+		return h
+	}
+
+	app := NewApp(nil, nil)
+
+	app.Handle(http.MethodGet, "/status", text("status"))
+
+	v1 := app.Version("v1")
+	v1.Handle(http.MethodGet, "/users/:id", text("v1 user"))
+	v1.Handle(http.MethodGet, "/orders", text("v1 orders"))
+
+	v2 := app.Version("/v2/")
+	v2.Handle(http.MethodGet, "/users/:id", text("v2 user"))
+
+	tests := []struct {
+		name   string
+		path   string
+		accept string
+		status int
+		body   string
+		vary   bool
+	}{
+		{"v1 prefix", "/v1/users/1", "", http.StatusOK, "v1 user 1", false},
+		{"v2 prefix", "/v2/users/1", "", http.StatusOK, "v2 user 1", false},
+		{"accept version", "/users/1", "application/json; version=2", http.StatusOK, "v2 user 1", true},
+		{"accept version with v", "/users/1", "application/json;version=v1", http.StatusOK, "v1 user 1", true},
+		{"accept quoted version", "/users/1", `application/json; version="2"`, http.StatusOK, "v2 user 1", true},
+		{"accept version of a later media type", "/users/1", "application/xml, application/json; q=0.9; version=1", http.StatusOK, "v1 user 1", true},
+		{"escaped path", "/users/a%2Fb", "application/json; version=2", http.StatusOK, "v2 user a/b", true},
+		{"prefix takes precedence", "/v1/users/1", "application/json; version=2", http.StatusOK, "v1 user 1", false},
+		{"route missing from version", "/orders", "application/json; version=2", http.StatusNotFound, "", true},
+		{"unknown version", "/users/1", "application/json; version=9", http.StatusNotFound, "", true},
+		{"no version", "/users/1", "application/json", http.StatusNotFound, "", true},
+		{"unversioned route", "/status", "application/json; version=2", http.StatusOK, "status ", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status code = %d, want %d", w.Code, tt.status)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+
+			vary := w.Header().Values("Vary")
+			if varies := len(vary) == 1 && vary[0] == "Accept"; varies != tt.vary {
+				t.Errorf("vary = %v, want Accept %t", vary, tt.vary)
+			}
+		})
+	}
+}
+
+func TestVaryOnce(t *testing.T) {
+	app := NewApp(nil, nil)
+	app.Version("v1").Handle(http.MethodGet, "/users", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return Respond(ctx, w, []string{"bill"}, http.StatusOK)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.Header.Set("Accept", "application/json; version=1")
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+
+	// Respond varies on Accept as well, the header lists it once.
+	if vary := w.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Accept" {
+		t.Errorf("vary = %v, want [Accept]", vary)
+	}
+}
+
+func TestDeprecate(t *testing.T) {
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2025, 6, 30, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	tests := []struct {
+		name        string
+		d           Deprecation
+		deprecation string
+		sunset      string
+		link        string
+	}{
+		{"zero", Deprecation{}, "true", "", ""},
+		{"date", Deprecation{Date: date}, "@1704067200", "", ""},
+		{"sunset", Deprecation{Sunset: sunset}, "true", "Mon, 30 Jun 2025 10:00:00 GMT", ""},
+		{
+			"all",
+			Deprecation{Date: date, Sunset: sunset, Link: "https://example.com/migrate"},
+			"@1704067200",
+			"Mon, 30 Jun 2025 10:00:00 GMT",
+			`<https://example.com/migrate>; rel="deprecation"; type="text/html"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := NewApp(nil, nil)
+			app.Version("v1", Deprecate(tt.d)).Handle(http.MethodGet, "/users", handler)
+			app.Version("v2").Handle(http.MethodGet, "/users", handler)
+
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/users", nil))
+
+			if got := w.Header().Get("Deprecation"); got != tt.deprecation {
+				t.Errorf("deprecation = %q, want %q", got, tt.deprecation)
+			}
+			if got := w.Header().Get("Sunset"); got != tt.sunset {
+				t.Errorf("sunset = %q, want %q", got, tt.sunset)
+			}
+			if got := w.Header().Get("Link"); got != tt.link {
+				t.Errorf("link = %q, want %q", got, tt.link)
+			}
+
+			// The next version isn't deprecated.
+			w = httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v2/users", nil))
+
+			if got := w.Header().Get("Deprecation"); got != "" {
+				t.Errorf("v2 deprecation = %q, want none", got)
+			}
+		})
+	}
+}
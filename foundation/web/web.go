@@ -4,10 +4,15 @@ package web
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/dimfeld/httptreemux/v5"
-	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -22,15 +27,39 @@ type Handler func(ctx context.Context, w http.ResponseWriter, r *http.Request) e
 type App struct {
 	*httptreemux.ContextMux
 	shutdown chan os.Signal
+	tracer   trace.Tracer
 	mw       []Middleware
+
+	// Shutdown state used to drain the app before it's stopped
+	shuttingDown atomic.Bool
+	inFlight     atomic.Int64
+	done         chan struct{}
+
+	// Routes registered with the app used to document the API and the
+	// methods registered for every path
+	mu         sync.Mutex
+	registered []*Route
+	paths      map[string]*pathMethods
+
+	// Versions registered with the app. The slice is replaced as a whole
+	// when a version is registered so requests read it without locking
+	versions atomic.Pointer[[]string]
 }
 
-// NewApp creates an App value that handle a set of routes for the application
-func NewApp(shutdown chan os.Signal, mw ...Middleware) *App {
+// NewApp creates an App value that handle a set of routes for the application.
+// A root span is started with the tracer for every request. If the tracer is
+// nil no spans are recorded
+func NewApp(shutdown chan os.Signal, tracer trace.Tracer, mw ...Middleware) *App {
+	if tracer == nil {
+		tracer = noop.NewTracerProvider().Tracer("")
+	}
+
 	return &App{
 		ContextMux: httptreemux.NewContextMux(),
 		shutdown:   shutdown,
+		tracer:     tracer,
 		mw:         mw,
+		done:       make(chan struct{}),
 	}
 }
 
@@ -40,21 +69,105 @@ func (a *App) SignalShutdown() {
 	a.shutdown <- syscall.SIGTERM
 }
 
+// StartShutdown marks the app as shutting down. Requests are still handled
+// while the app drains, but clients are asked to close their connections and
+// the app no longer reports being ready for traffic. Websocket handlers are
+// canceled so their connections are closed
+func (a *App) StartShutdown() {
+	if a.shuttingDown.CompareAndSwap(false, true) {
+		close(a.done)
+	}
+}
+
+// IsShuttingDown reports whether StartShutdown has been called
+func (a *App) IsShuttingDown() bool {
+	return a.shuttingDown.Load()
+}
+
+// InFlight returns the number of requests currently being handled
+func (a *App) InFlight() int64 {
+	return a.inFlight.Load()
+}
+
 // Handle sets a handler function for a given HTTP method and pair
-// to the application server mux
-func (a *App) Handle(method string, path string, handler Handler, mw ...Middleware) {
-	handler = wrapMiddleware(mw, handler)
-	handler = wrapMiddleware(a.mw, handler)
+// to the application server mux. The returned route can be used to
+// document the route
+func (a *App) Handle(method string, path string, handler Handler, mw ...Middleware) *Route {
+	return a.handle(method, path, handler, mw)
+}
+
+// handle wraps the route and application middleware around the handler and
+// registers the result with the mux. The route middleware is executed after
+// the application middleware
+func (a *App) handle(method string, path string, handler Handler, mw []Middleware) *Route {
+	rt := Route{
+		method: method,
+		path:   path,
+	}
+
+	handler = wrapRoute(&rt, mw, handler)
+	handler = wrapRoute(&rt, a.mw, handler)
 
+	a.register(method, path, a.serve(method, path, handler))
+
+	a.mu.Lock()
+	a.registered = append(a.registered, &rt)
+	a.mu.Unlock()
+
+	return &rt
+}
+
+// serve constructs the function the mux calls for the route. It sets up the
+// request values and the root span before the handler is called
+func (a *App) serve(method string, path string, handler Handler) http.HandlerFunc {
 	h := func(w http.ResponseWriter, r *http.Request) {
+		a.inFlight.Add(1)
+		defer a.inFlight.Add(-1)
+
+		// Clients using keep-alive connections are asked to reconnect so
+		// their next request goes to an instance that isn't draining.
+		if a.IsShuttingDown() {
+			w.Header().Set("Connection", "close")
+		}
+
+		tc := extractTraceContext(r)
+
+		ctx, span := startSpan(r.Context(), a.tracer, tc, fmt.Sprintf("%s %s", method, path))
+		span.SetAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.route", path),
+			attribute.String("http.target", r.RequestURI),
+		)
+		defer span.End()
 
 		v := Values{
-			TraceID: uuid.NewString(),
-			Now:     time.Now().UTC(),
+			TraceID:      tc.traceID,
+			SpanID:       newID(8),
+			ParentSpanID: tc.parentID,
+			TraceFlags:   tc.flags,
+			TraceState:   tc.state,
+			Tracer:       a.tracer,
+			Now:          time.Now().UTC(),
+			route:        method + " " + path,
+			request:      r,
+		}
+
+		// When spans are recorded the ids of the root span are used so the
+		// logs and the traces can be correlated.
+		if sc := span.SpanContext(); sc.IsValid() && sc.SpanID().String() != tc.parentID {
+			v.TraceID = sc.TraceID().String()
+			v.SpanID = sc.SpanID().String()
+			v.TraceFlags = sc.TraceFlags().String()
 		}
-		ctx := context.WithValue(r.Context(), key, &v)
 
-		if err := handler(ctx, w, r); err != nil {
+		ctx = context.WithValue(ctx, key, &v)
+
+		setTraceHeaders(w, &v)
+
+		err := handler(ctx, w, r)
+		span.SetAttributes(attribute.Int("http.status_code", v.StatusCode))
+
+		if err != nil {
 			if validateShutdown(err) {
 				a.SignalShutdown()
 				return
@@ -62,12 +175,27 @@ func (a *App) Handle(method string, path string, handler Handler, mw ...Middlewa
 		}
 	}
 
-	a.ContextMux.Handle(method, path, h)
+	return h
 }
 
 // validateShutdown validates the error for special conditions that do not
-// warrant an actual shutdown by the system
+// warrant an actual shutdown by the system. A shutdown error always does,
+// even when it's returned after a stream committed the response
 func validateShutdown(err error) bool {
+	switch {
+	case IsShutdown(err):
+		return true
+
+	case IsDisconnect(err):
+		return false
+	}
+
+	return true
+}
+
+// IsDisconnect checks if the error was caused by the client going away
+// before the response was sent
+func IsDisconnect(err error) bool {
 
 	// Ignore syscall.EPIPE and syscall.ECONNRESET errors which occurs
 	// when a write operation happens on the http.ResponseWriter that
@@ -87,7 +215,7 @@ func validateShutdown(err error) bool {
 		// closed connection causes the peer to reply with an RST packet indicating that the
 		// connection should be terminated immediately. The second write to the socket that
 		// has already received the RST causes the broken pipe error.
-		return false
+		return true
 
 	case errors.Is(err, syscall.ECONNRESET):
 		// Usually, you get connection reset by peer error when you read from the
@@ -97,8 +225,14 @@ func validateShutdown(err error) bool {
 		// end, but the other end crashes and forcibly closes the connection with the RST
 		// packet instead of the TCP FIN, which is used to close a connection under normal
 		// circumstances.
-		return false
+		return true
+
+	case errors.Is(err, context.Canceled):
+		// The request context is canceled when the client closes the
+		// connection, like a stream waiting for its next record when the
+		// client goes away.
+		return true
 	}
 
-	return true
+	return false
 }
@@ -0,0 +1,171 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// websocketGUID is appended to the key of the client to compute the accept
+// value of the handshake as defined by RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocketHandler handles a websocket connection. The context is canceled
+// once the app starts shutting down. When the handler returns the connection
+// is closed with a normal closure, or an internal error when the handler
+// returns an error
+type WebSocketHandler func(ctx context.Context, ws *WebSocket) error
+
+// HandleWebSocket sets a handler for websocket connections on the path. The
+// upgrade request runs through the application and route middleware like
+// any other request, so it can be authenticated and authorized before the
+// connection is upgraded
+func (a *App) HandleWebSocket(path string, handler WebSocketHandler, mw ...Middleware) *Route {
+	return a.handle(http.MethodGet, path, a.upgrade(handler), mw)
+}
+
+// HandleWebSocket sets a handler for websocket connections on the path
+// relative to the group's prefix
+func (g *Group) HandleWebSocket(path string, handler WebSocketHandler, mw ...Middleware) *Route {
+	rt := g.app.HandleWebSocket(joinPath(g.prefix, path), handler, g.middleware(mw)...)
+	return g.annotate(rt)
+}
+
+// upgrade constructs the handler that switches the connection to the
+// websocket protocol and runs the websocket handler on it
+func (a *App) upgrade(handler WebSocketHandler) Handler {
+	h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		if err := checkHandshake(w, r); err != nil {
+			return err
+		}
+
+		netConn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return fmt.Errorf("hijacking connection: %w", err)
+		}
+		defer netConn.Close()
+
+		// The deadlines of the server apply to the request and not to the
+		// connection that outlives it.
+		if err := netConn.SetDeadline(noDeadline); err != nil {
+			return newStreamError(fmt.Errorf("clearing deadlines: %w", err))
+		}
+
+		SetStatusCode(ctx, http.StatusSwitchingProtocols)
+
+		if err := writeHandshake(brw.Writer, w.Header(), r.Header.Get("Sec-WebSocket-Key")); err != nil {
+			return newStreamError(fmt.Errorf("writing handshake: %w", err))
+		}
+
+		ws := newWebSocket(netConn, brw.Reader)
+
+		// The connection is closed with a going away status when the app
+		// shuts down so clients know to reconnect somewhere else.
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		go func() {
+			select {
+			case <-a.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		err = handler(ctx, ws)
+
+		switch {
+		case err == nil:
+			ws.Close(CloseNormal, "")
+			return nil
+
+		case a.IsShuttingDown() && errors.Is(err, context.Canceled):
+			ws.Close(CloseGoingAway, "server shutting down")
+			return nil
+
+		case IsCloseError(err), errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+			// The client closed the connection.
+			return nil
+		}
+
+		ws.Close(CloseInternalError, "")
+
+		// The response was committed by the handshake so the error can only
+		// be logged.
+		return newStreamError(err)
+	}
+
+	return h
+}
+
+// checkHandshake validates the opening handshake of the client. An error
+// is returned for requests that aren't a websocket upgrade
+func checkHandshake(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return NewDecodeError(errors.New("websocket: handshake requires GET"), http.StatusMethodNotAllowed)
+	}
+
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		return NewDecodeError(errors.New("websocket: request is not an upgrade to websocket"), http.StatusUpgradeRequired)
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return NewDecodeError(errors.New("websocket: unsupported version"), http.StatusUpgradeRequired)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key"))
+	if err != nil || len(key) != 16 {
+		return NewDecodeError(errors.New("websocket: invalid Sec-WebSocket-Key"), http.StatusBadRequest)
+	}
+
+	return nil
+}
+
+// writeHandshake sends the response switching the connection to the
+// websocket protocol. The headers set by the middleware, like the trace
+// headers, are sent along
+func writeHandshake(w *bufio.Writer, header http.Header, key string) error {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+
+	fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", http.StatusSwitchingProtocols, http.StatusText(http.StatusSwitchingProtocols))
+	fmt.Fprintf(w, "Upgrade: websocket\r\n")
+	fmt.Fprintf(w, "Connection: Upgrade\r\n")
+	fmt.Fprintf(w, "Sec-WebSocket-Accept: %s\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+
+	for k, values := range header {
+		switch k {
+		case "Upgrade", "Connection", "Content-Type", "Content-Length", "Vary":
+			continue
+		}
+		for _, v := range values {
+			fmt.Fprintf(w, "%s: %s\r\n", k, v)
+		}
+	}
+
+	fmt.Fprintf(w, "\r\n")
+
+	return w.Flush()
+}
+
+// headerContains checks if the comma separated list of tokens in the header
+// contains the token
+func headerContains(header http.Header, key string, token string) bool {
+	for _, value := range header.Values(key) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
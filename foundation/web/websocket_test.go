@@ -0,0 +1,420 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsTestKey is the key of the handshake example of RFC 6455
+const wsTestKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// wsClient is the client side of a websocket connection used to send the
+// frames a test needs, valid or not
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dialWebSocket connects to the path of the server and completes the
+// opening handshake
+func dialWebSocket(t *testing.T, srv *httptest.Server, path string) *wsClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("setting deadline: %s", err)
+	}
+
+	req := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + srv.Listener.Addr().String() + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + wsTestKey + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := io.WriteString(conn, req); err != nil {
+		t.Fatalf("writing handshake: %s", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("reading handshake: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status code = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	return &wsClient{t: t, conn: conn, br: br}
+}
+
+// send writes a frame. Frames sent by a client must be masked so an
+// unmasked frame is only sent to test the server rejects it
+func (c *wsClient) send(fin bool, opcode byte, payload []byte, masked bool) {
+	c.t.Helper()
+
+	var b bytes.Buffer
+
+	first := opcode
+	if fin {
+		first |= finBit
+	}
+	b.WriteByte(first)
+
+	var mask byte
+	if masked {
+		mask = maskBit
+	}
+
+	switch length := len(payload); {
+	case length <= 125:
+		b.WriteByte(mask | byte(length))
+	case length <= 0xFFFF:
+		b.WriteByte(mask | 126)
+		binary.Write(&b, binary.BigEndian, uint16(length))
+	default:
+		b.WriteByte(mask | 127)
+		binary.Write(&b, binary.BigEndian, uint64(length))
+	}
+
+	data := payload
+	if masked {
+		key := [4]byte{0x12, 0x34, 0x56, 0x78}
+		b.Write(key[:])
+
+		data = make([]byte, len(payload))
+		for i := range payload {
+			data[i] = payload[i] ^ key[i%4]
+		}
+	}
+	b.Write(data)
+
+	if _, err := c.conn.Write(b.Bytes()); err != nil {
+		c.t.Fatalf("writing frame: %s", err)
+	}
+}
+
+// sendClose writes a masked close frame with the code and reason
+func (c *wsClient) sendClose(code CloseCode, reason string) {
+	c.t.Helper()
+
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	c.send(true, opClose, append(payload, reason...), true)
+}
+
+// receive reads the next frame sent by the server
+func (c *wsClient) receive() (byte, []byte) {
+	c.t.Helper()
+
+	var b [2]byte
+	if _, err := io.ReadFull(c.br, b[:]); err != nil {
+		c.t.Fatalf("reading frame header: %s", err)
+	}
+
+	if b[0]&finBit == 0 {
+		c.t.Errorf("server sent a fragmented frame")
+	}
+	if b[1]&maskBit != 0 {
+		c.t.Errorf("server sent a masked frame")
+	}
+
+	length := uint64(b[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext uint16
+		binary.Read(c.br, binary.BigEndian, &ext)
+		length = uint64(ext)
+	case 127:
+		binary.Read(c.br, binary.BigEndian, &length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatalf("reading frame payload: %s", err)
+	}
+
+	return b[0] & 0x0F, payload
+}
+
+// receiveClose reads a close frame and checks the server closes the
+// connection after it
+func (c *wsClient) receiveClose() CloseCode {
+	c.t.Helper()
+
+	opcode, payload := c.receive()
+	if opcode != opClose || len(payload) < 2 {
+		c.t.Fatalf("frame = %d %q, want a close frame", opcode, payload)
+	}
+
+	if _, err := c.br.ReadByte(); !errors.Is(err, io.EOF) {
+		c.t.Errorf("reading after the close frame: %v, want EOF", err)
+	}
+
+	return CloseCode(binary.BigEndian.Uint16(payload))
+}
+
+// echoServer starts a server with a websocket at /echo that sends every
+// message back. The read limit of the websocket is set to the limit
+func echoServer(t *testing.T, limit int64) (*httptest.Server, *App) {
+
+	// The errors of the handler are dropped like the error handling
+	// middleware of an app would, so a client violating the protocol
+	// doesn't shut down the app.
+	handled := func(handler Handler) Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			handler(ctx, w, r)
+			return nil
+		}
+		return h
+	}
+
+	app := NewApp(nil, nil, handled)
+
+	app.HandleWebSocket("/echo", func(ctx context.Context, ws *WebSocket) error {
+		ws.SetReadLimit(limit)
+
+		for {
+			typ, msg, err := ws.Read(ctx)
+			if err != nil {
+				return err
+			}
+
+			if err := ws.Write(ctx, typ, msg); err != nil {
+				return err
+			}
+		}
+	})
+
+	srv := httptest.NewServer(app)
+	t.Cleanup(srv.Close)
+
+	return srv, app
+}
+
+// =============================================================================
+
+func TestWebSocketHandshake(t *testing.T) {
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+
+	header := http.Header{}
+	header.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	header.Set("Content-Type", "application/json")
+
+	if err := writeHandshake(w, header, wsTestKey); err != nil {
+		t.Fatalf("writing handshake: %s", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(&b), nil)
+	if err != nil {
+		t.Fatalf("reading handshake: %s", err)
+	}
+
+	// The accept value of the handshake example of RFC 6455.
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("accept = %q, want %q", got, want)
+	}
+	if resp.Header.Get("Traceparent") == "" {
+		t.Error("headers set by the middleware were not sent")
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		t.Errorf("content type = %q, want none", ct)
+	}
+}
+
+func TestWebSocketCheckHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		header  map[string]string
+		status  int
+		success bool
+	}{
+		{"valid", http.MethodGet, map[string]string{}, 0, true},
+		{"keep-alive and upgrade", http.MethodGet, map[string]string{"Connection": "keep-alive, Upgrade"}, 0, true},
+		{"not get", http.MethodPost, map[string]string{}, http.StatusMethodNotAllowed, false},
+		{"not an upgrade", http.MethodGet, map[string]string{"Upgrade": "h2c"}, http.StatusUpgradeRequired, false},
+		{"old version", http.MethodGet, map[string]string{"Sec-WebSocket-Version": "8"}, http.StatusUpgradeRequired, false},
+		{"short key", http.MethodGet, map[string]string{"Sec-WebSocket-Key": "c2hvcnQ="}, http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			r.Header.Set("Upgrade", "websocket")
+			r.Header.Set("Connection", "Upgrade")
+			r.Header.Set("Sec-WebSocket-Key", wsTestKey)
+			r.Header.Set("Sec-WebSocket-Version", "13")
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+
+			err := checkHandshake(httptest.NewRecorder(), r)
+			if (err == nil) != tt.success {
+				t.Fatalf("error = %v, want success %t", err, tt.success)
+			}
+			if err != nil && GetDecodeError(err).Status != tt.status {
+				t.Errorf("status = %d, want %d", GetDecodeError(err).Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestWebSocketMessages(t *testing.T) {
+	srv, _ := echoServer(t, 1<<17)
+
+	long := bytes.Repeat([]byte("a"), 200)
+	huge := bytes.Repeat([]byte("b"), 70000)
+
+	tests := []struct {
+		name string
+		typ  byte
+		msg  []byte
+	}{
+		{"text", opText, []byte("hello")},
+		{"binary", opBinary, []byte{0x00, 0xFF, 0x10}},
+		{"empty", opText, nil},
+		{"16 bit length", opText, long},
+		{"64 bit length", opBinary, huge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dialWebSocket(t, srv, "/echo")
+
+			c.send(true, tt.typ, tt.msg, true)
+
+			opcode, payload := c.receive()
+			if opcode != tt.typ || !bytes.Equal(payload, tt.msg) {
+				t.Errorf("echo = %d %d bytes, want %d %d bytes", opcode, len(payload), tt.typ, len(tt.msg))
+			}
+
+			c.sendClose(CloseNormal, "")
+			if code := c.receiveClose(); code != CloseNormal {
+				t.Errorf("close code = %d, want %d", code, CloseNormal)
+			}
+		})
+	}
+}
+
+func TestWebSocketFragmented(t *testing.T) {
+	srv, _ := echoServer(t, DefaultReadLimit)
+	c := dialWebSocket(t, srv, "/echo")
+
+	// Control frames can be sent between the fragments of a message.
+	c.send(false, opText, []byte("hel"), true)
+	c.send(true, opPing, []byte("p"), true)
+	c.send(false, opContinuation, []byte("lo "), true)
+	c.send(true, opContinuation, []byte("world"), true)
+
+	if opcode, payload := c.receive(); opcode != opPong || string(payload) != "p" {
+		t.Errorf("frame = %d %q, want pong %q", opcode, payload, "p")
+	}
+	if opcode, payload := c.receive(); opcode != opText || string(payload) != "hello world" {
+		t.Errorf("frame = %d %q, want text %q", opcode, payload, "hello world")
+	}
+}
+
+func TestWebSocketPing(t *testing.T) {
+	srv, _ := echoServer(t, DefaultReadLimit)
+	c := dialWebSocket(t, srv, "/echo")
+
+	c.send(true, opPing, []byte("are you there"), true)
+
+	if opcode, payload := c.receive(); opcode != opPong || string(payload) != "are you there" {
+		t.Errorf("frame = %d %q, want pong with the ping payload", opcode, payload)
+	}
+
+	// The pong of a ping sent by the server is consumed by Read.
+	c.send(true, opPong, []byte("late"), true)
+	c.send(true, opText, []byte("after pong"), true)
+
+	if opcode, payload := c.receive(); opcode != opText || string(payload) != "after pong" {
+		t.Errorf("frame = %d %q, want text %q", opcode, payload, "after pong")
+	}
+}
+
+func TestWebSocketClose(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(c *wsClient)
+		want CloseCode
+	}{
+		{"close handshake", func(c *wsClient) { c.sendClose(CloseGoingAway, "bye") }, CloseGoingAway},
+		{"close without status", func(c *wsClient) { c.send(true, opClose, nil, true) }, CloseNormal},
+		{"invalid close code", func(c *wsClient) { c.sendClose(CloseNoStatus, "") }, CloseProtocolError},
+		{"unmasked frame", func(c *wsClient) { c.send(true, opText, []byte("hi"), false) }, CloseProtocolError},
+		{"reserved bits", func(c *wsClient) { c.send(true, opText|0x40, []byte("hi"), true) }, CloseProtocolError},
+		{"unknown opcode", func(c *wsClient) { c.send(true, 0x3, []byte("hi"), true) }, CloseProtocolError},
+		{"fragmented control frame", func(c *wsClient) { c.send(false, opPing, nil, true) }, CloseProtocolError},
+		{"continuation without message", func(c *wsClient) { c.send(true, opContinuation, []byte("hi"), true) }, CloseProtocolError},
+		{"message before previous completed", func(c *wsClient) {
+			c.send(false, opText, []byte("hel"), true)
+			c.send(true, opText, []byte("lo"), true)
+		}, CloseProtocolError},
+		{"oversize message", func(c *wsClient) { c.send(true, opBinary, make([]byte, 17), true) }, CloseMessageTooBig},
+		{"oversize fragments", func(c *wsClient) {
+			c.send(false, opBinary, make([]byte, 10), true)
+			c.send(true, opContinuation, make([]byte, 10), true)
+		}, CloseMessageTooBig},
+		{"invalid utf-8", func(c *wsClient) { c.send(true, opText, []byte{0xff, 0xfe}, true) }, CloseInvalidPayload},
+		{"invalid utf-8 across fragments", func(c *wsClient) {
+			c.send(false, opText, []byte{0xe2, 0x82}, true)
+			c.send(true, opContinuation, []byte{0x28}, true)
+		}, CloseInvalidPayload},
+	}
+
+	srv, _ := echoServer(t, 16)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dialWebSocket(t, srv, "/echo")
+
+			tt.send(c)
+
+			if code := c.receiveClose(); code != tt.want {
+				t.Errorf("close code = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebSocketShutdown(t *testing.T) {
+	srv, app := echoServer(t, DefaultReadLimit)
+	c := dialWebSocket(t, srv, "/echo")
+
+	// A message is echoed first so the handler is known to be reading
+	// when the app starts shutting down.
+	c.send(true, opText, []byte("ready"), true)
+	if opcode, payload := c.receive(); opcode != opText || string(payload) != "ready" {
+		t.Fatalf("frame = %d %q, want text %q", opcode, payload, "ready")
+	}
+
+	app.StartShutdown()
+
+	opcode, payload := c.receive()
+	if opcode != opClose || len(payload) < 2 {
+		t.Fatalf("frame = %d %q, want a close frame", opcode, payload)
+	}
+
+	code := CloseCode(binary.BigEndian.Uint16(payload))
+	if code != CloseGoingAway || !strings.Contains(string(payload[2:]), "shutting down") {
+		t.Errorf("close = %d %q, want %d", code, payload[2:], CloseGoingAway)
+	}
+}
@@ -0,0 +1,499 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the type of a websocket data message
+type MessageType int
+
+// Set of websocket message types
+const (
+	MessageText   MessageType = opText
+	MessageBinary MessageType = opBinary
+)
+
+// CloseCode is the status code sent when a websocket connection is closed
+type CloseCode int
+
+// Set of websocket close codes defined by RFC 6455
+const (
+	CloseNormal          CloseCode = 1000
+	CloseGoingAway       CloseCode = 1001
+	CloseProtocolError   CloseCode = 1002
+	CloseUnsupportedData CloseCode = 1003
+	CloseNoStatus        CloseCode = 1005
+	CloseAbnormal        CloseCode = 1006
+	CloseInvalidPayload  CloseCode = 1007
+	ClosePolicyViolation CloseCode = 1008
+	CloseMessageTooBig   CloseCode = 1009
+	CloseInternalError   CloseCode = 1011
+)
+
+// DefaultReadLimit is the largest message a websocket accepts unless
+// SetReadLimit is used. It matches the limit of Decode
+const DefaultReadLimit = 1 << 20
+
+// Set of frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Set of bits in the first two bytes of a frame
+const (
+	finBit  = 0x80
+	rsvBits = 0x70
+	maskBit = 0x80
+)
+
+// maxControlPayload is the largest payload of a control frame
+const maxControlPayload = 125
+
+// noDeadline clears a deadline on a connection
+var noDeadline time.Time
+
+// CloseError is returned when the websocket connection was closed by the
+// peer with a close frame
+type CloseError struct {
+	Code   CloseCode
+	Reason string
+}
+
+// Error implements the error interface
+func (ce *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d: %s", ce.Code, ce.Reason)
+}
+
+// IsCloseError checks if the error is a CloseError
+func IsCloseError(err error) bool {
+	var ce *CloseError
+	return errors.As(err, &ce)
+}
+
+// protocolError is returned when the peer violates the protocol. The
+// connection is closed with the code
+type protocolError struct {
+	code CloseCode
+	msg  string
+}
+
+func (pe *protocolError) Error() string {
+	return "websocket: " + pe.msg
+}
+
+// =============================================================================
+
+// WebSocket represents a websocket connection. Reads must be done by a
+// single goroutine while writes can be done concurrently. The deadline of
+// the context passed to a read or write is applied to the connection and
+// canceling the context aborts it
+type WebSocket struct {
+	conn      net.Conn
+	br        *bufio.Reader
+	readLimit int64
+
+	// Set once reading failed since the stream of frames can't be trusted
+	// from that point on.
+	readErr error
+
+	wmu       sync.Mutex
+	closeSent bool
+}
+
+func newWebSocket(conn net.Conn, br *bufio.Reader) *WebSocket {
+	return &WebSocket{
+		conn:      conn,
+		br:        br,
+		readLimit: DefaultReadLimit,
+	}
+}
+
+// SetReadLimit sets the largest message in bytes the websocket accepts. The
+// connection is closed with CloseMessageTooBig when a peer sends a larger
+// message
+func (ws *WebSocket) SetReadLimit(limit int64) {
+	ws.readLimit = limit
+}
+
+// RemoteAddr returns the address of the peer
+func (ws *WebSocket) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
+
+// Read reads the next data message. Pings are answered and close frames are
+// acknowledged while reading, in which case a CloseError is returned
+func (ws *WebSocket) Read(ctx context.Context) (MessageType, []byte, error) {
+	if ws.readErr != nil {
+		return 0, nil, ws.readErr
+	}
+
+	stop, err := ws.watch(ctx, ws.conn.SetReadDeadline)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer stop()
+
+	typ, msg, err := ws.readMessage()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = fmt.Errorf("%w: %w", ctxErr, err)
+		}
+
+		var pe *protocolError
+		if errors.As(err, &pe) {
+			ws.Close(pe.code, pe.msg)
+		}
+
+		ws.readErr = err
+		return 0, nil, err
+	}
+
+	return typ, msg, nil
+}
+
+// ReadJSON reads the next data message and decodes it as JSON into the
+// value
+func (ws *WebSocket) ReadJSON(ctx context.Context, v any) error {
+	_, msg, err := ws.Read(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(msg, v); err != nil {
+		return fmt.Errorf("websocket: decoding message: %w", err)
+	}
+
+	return nil
+}
+
+// Write sends a data message
+func (ws *WebSocket) Write(ctx context.Context, typ MessageType, data []byte) error {
+	if typ != MessageText && typ != MessageBinary {
+		return fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+
+	return ws.writeFrame(ctx, byte(typ), data)
+}
+
+// WriteJSON sends the value encoded as JSON in a text message
+func (ws *WebSocket) WriteJSON(ctx context.Context, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("websocket: encoding message: %w", err)
+	}
+
+	return ws.writeFrame(ctx, opText, data)
+}
+
+// Ping sends a ping frame. The pong the peer sends back is consumed by Read
+func (ws *WebSocket) Ping(ctx context.Context, data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: ping payload too large")
+	}
+
+	return ws.writeFrame(ctx, opPing, data)
+}
+
+// Close sends a close frame with the code and reason and closes the
+// connection. Calling Close more than once has no effect
+func (ws *WebSocket) Close(code CloseCode, reason string) error {
+	ws.wmu.Lock()
+	sent := ws.closeSent
+	ws.wmu.Unlock()
+
+	if !sent {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		ws.writeClose(ctx, code, reason)
+	}
+
+	return ws.conn.Close()
+}
+
+// =============================================================================
+
+// watch applies the deadline of the context to the connection and aborts
+// the operation when the context is canceled. The returned function must be
+// called once the operation completes
+func (ws *WebSocket) watch(ctx context.Context, setDeadline func(time.Time) error) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := setDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("websocket: setting deadline: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		setDeadline(time.Now())
+	})
+
+	return func() { stop() }, nil
+}
+
+// readMessage reads frames until a complete data message is received
+func (ws *WebSocket) readMessage() (MessageType, []byte, error) {
+	var typ MessageType
+	var msg []byte
+	var fragmented bool
+
+	for {
+		h, err := ws.readHeader()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if h.opcode >= opClose {
+			payload, err := ws.readPayload(h)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			if err := ws.handleControl(h.opcode, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		switch {
+		case h.opcode == opContinuation && !fragmented:
+			return 0, nil, &protocolError{CloseProtocolError, "continuation frame without a message"}
+		case h.opcode != opContinuation && fragmented:
+			return 0, nil, &protocolError{CloseProtocolError, "new message before the previous one completed"}
+		}
+
+		if int64(len(msg))+int64(h.length) > ws.readLimit || h.length > uint64(ws.readLimit) {
+			return 0, nil, &protocolError{CloseMessageTooBig, "message too big"}
+		}
+
+		payload, err := ws.readPayload(h)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if h.opcode != opContinuation {
+			typ = MessageType(h.opcode)
+		}
+		msg = append(msg, payload...)
+
+		if !h.fin {
+			fragmented = true
+			continue
+		}
+
+		if typ == MessageText && !utf8.Valid(msg) {
+			return 0, nil, &protocolError{CloseInvalidPayload, "text message is not valid UTF-8"}
+		}
+
+		return typ, msg, nil
+	}
+}
+
+// handleControl answers pings and acknowledges close frames. Pongs are
+// ignored since pings aren't matched with their pongs
+func (ws *WebSocket) handleControl(opcode byte, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	switch opcode {
+	case opPing:
+		if err := ws.writeFrame(ctx, opPong, payload); err != nil {
+			return fmt.Errorf("websocket: answering ping: %w", err)
+		}
+		return nil
+
+	case opPong:
+		return nil
+	}
+
+	ce := CloseError{Code: CloseNoStatus}
+
+	switch {
+	case len(payload) == 1:
+		return &protocolError{CloseProtocolError, "invalid close payload"}
+
+	case len(payload) >= 2:
+		ce.Code = CloseCode(binary.BigEndian.Uint16(payload))
+		ce.Reason = string(payload[2:])
+
+		if !validCloseCode(ce.Code) {
+			return &protocolError{CloseProtocolError, "invalid close code"}
+		}
+		if !utf8.ValidString(ce.Reason) {
+			return &protocolError{CloseInvalidPayload, "close reason is not valid UTF-8"}
+		}
+	}
+
+	// The close frame is echoed to complete the closing handshake.
+	code := ce.Code
+	if code == CloseNoStatus {
+		code = CloseNormal
+	}
+	ws.writeClose(ctx, code, "")
+
+	return &ce
+}
+
+// frameHeader represents the header of a frame
+type frameHeader struct {
+	fin    bool
+	opcode byte
+	length uint64
+	mask   [4]byte
+}
+
+// readHeader reads and validates the header of the next frame
+func (ws *WebSocket) readHeader() (frameHeader, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(ws.br, b[:]); err != nil {
+		return frameHeader{}, err
+	}
+
+	h := frameHeader{
+		fin:    b[0]&finBit != 0,
+		opcode: b[0] & 0x0F,
+		length: uint64(b[1] & 0x7F),
+	}
+
+	if b[0]&rsvBits != 0 {
+		return frameHeader{}, &protocolError{CloseProtocolError, "reserved bits set without extension"}
+	}
+
+	switch h.opcode {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+	default:
+		return frameHeader{}, &protocolError{CloseProtocolError, fmt.Sprintf("unknown opcode %d", h.opcode)}
+	}
+
+	// Clients must mask every frame they send.
+	if b[1]&maskBit == 0 {
+		return frameHeader{}, &protocolError{CloseProtocolError, "frame is not masked"}
+	}
+
+	switch h.length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return frameHeader{}, err
+		}
+		h.length = uint64(binary.BigEndian.Uint16(ext[:]))
+
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return frameHeader{}, err
+		}
+		h.length = binary.BigEndian.Uint64(ext[:])
+		if h.length>>63 != 0 {
+			return frameHeader{}, &protocolError{CloseProtocolError, "invalid payload length"}
+		}
+	}
+
+	if h.opcode >= opClose && (!h.fin || h.length > maxControlPayload) {
+		return frameHeader{}, &protocolError{CloseProtocolError, "invalid control frame"}
+	}
+
+	if _, err := io.ReadFull(ws.br, h.mask[:]); err != nil {
+		return frameHeader{}, err
+	}
+
+	return h, nil
+}
+
+// readPayload reads and unmasks the payload of the frame
+func (ws *WebSocket) readPayload(h frameHeader) ([]byte, error) {
+	payload := make([]byte, h.length)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= h.mask[i%4]
+	}
+
+	return payload, nil
+}
+
+// writeClose sends a close frame. Nothing can be written after it
+func (ws *WebSocket) writeClose(ctx context.Context, code CloseCode, reason string) error {
+	// The reason is truncated so the payload fits in a control frame.
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+
+	return ws.writeFrame(ctx, opClose, payload)
+}
+
+// writeFrame sends the payload in a single unmasked frame
+func (ws *WebSocket) writeFrame(ctx context.Context, opcode byte, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+
+	if ws.closeSent {
+		return &CloseError{Code: CloseNormal, Reason: "connection is closing"}
+	}
+
+	stop, err := ws.watch(ctx, ws.conn.SetWriteDeadline)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	header := make([]byte, 2, 10)
+	header[0] = finBit | opcode
+
+	switch length := len(payload); {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	buffers := net.Buffers{header, payload}
+	if _, err := buffers.WriteTo(ws.conn); err != nil {
+		return fmt.Errorf("websocket: writing frame: %w", err)
+	}
+
+	if opcode == opClose {
+		ws.closeSent = true
+	}
+
+	return nil
+}
+
+// validCloseCode checks if the close code can be sent in a close frame
+func validCloseCode(code CloseCode) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+
+	return false
+}